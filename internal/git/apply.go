@@ -0,0 +1,897 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ApplyError reports why a patch does not apply. For a hunk that does not
+// match, Line is the line of the target where the hunk was expected and
+// Expected and Found are the first lines that differ there, with their line
+// endings; an empty Found is the end of the file and an empty Expected means
+// the hunk should have ended the file.
+type ApplyError struct {
+	Path     string
+	Hunk     int
+	Line     int
+	Expected string
+	Found    string
+	Reason   string
+}
+
+func (e *ApplyError) Error() string {
+	if e.Hunk == 0 {
+		return fmt.Sprintf("%s: %s", e.Path, e.Reason)
+	}
+	msg := fmt.Sprintf("patch failed: %s:%d: hunk #%d does not apply", e.Path, e.Line, e.Hunk)
+	if e.Reason != "" {
+		return msg + ": " + e.Reason
+	}
+	return fmt.Sprintf("%s: expected %s, found %s", msg, describeLine(e.Expected), describeLine(e.Found))
+}
+
+// HunkOffset is a hunk that applied away from where its header placed it:
+// Line is where it applied in the target and Offset how far it moved.
+type HunkOffset struct {
+	Path   string
+	Hunk   int
+	Line   int
+	Offset int
+}
+
+// String reports the offset the way git apply does.
+func (o HunkOffset) String() string {
+	lines := "lines"
+	if o.Offset == 1 || o.Offset == -1 {
+		lines = "line"
+	}
+	return fmt.Sprintf("%s: hunk #%d succeeded at %d (offset %d %s).", o.Path, o.Hunk, o.Line, o.Offset, lines)
+}
+
+func describeLine(line string) string {
+	switch {
+	case line == "":
+		return "end of file"
+	case !strings.HasSuffix(line, "\n"):
+		return fmt.Sprintf("%q without newline", line)
+	}
+	// Only the newline goes, so that a carriage return shows
+	return fmt.Sprintf("%q", strings.TrimSuffix(line, "\n"))
+}
+
+// filePatch is the part of a patch that changes one file. Binary holds the
+// lines of a binary patch, starting with its "GIT binary patch" or "Binary
+// files" line.
+type filePatch struct {
+	OldPath  string
+	NewPath  string
+	OldMode  string
+	NewMode  string
+	OldHash  string
+	NewHash  string
+	IsNew    bool
+	IsDelete bool
+	IsCopy   bool
+	Binary   []string
+	Hunks    []Hunk
+	reverse  bool
+}
+
+// parsePatch splits a patch in the format of "git diff" into the changes of
+// each file. Hunk bodies are read by the counts in their headers, as git
+// apply does without --recount.
+func parsePatch(patch []byte) ([]*filePatch, error) {
+	lines := strings.Split(string(patch), "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	var patches []*filePatch
+	var fp *filePatch
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if strings.HasPrefix(line, "diff --git ") {
+			path := diffSectionPath(line)
+			fp = &filePatch{OldPath: path, NewPath: path}
+			patches = append(patches, fp)
+			continue
+		}
+		if fp == nil {
+			if strings.HasPrefix(line, "@@ ") {
+				return nil, fmt.Errorf("patch fragment without header at line %d: %s", i+1, line)
+			}
+			continue
+		}
+
+		corrupt := &ApplyError{Path: fp.NewPath, Reason: fmt.Sprintf("corrupt patch at line %d", i+1)}
+		switch {
+		case strings.HasPrefix(line, "@@ "):
+			hunk := Hunk{Type: HunkTypeHunk, Text: []string{line}}
+			if parseHunkRanges(&hunk) != nil {
+				return nil, corrupt
+			}
+			oldLeft, newLeft := hunk.OldCnt, hunk.NewCnt
+			for oldLeft > 0 || newLeft > 0 {
+				i++
+				if i == len(lines) {
+					return nil, &ApplyError{Path: fp.NewPath, Reason: fmt.Sprintf("corrupt patch at line %d", i+1)}
+				}
+				body := lines[i]
+				kind := byte(' ')
+				if body != "" {
+					kind = body[0]
+				}
+				switch kind {
+				case ' ':
+					oldLeft--
+					newLeft--
+				case '-':
+					oldLeft--
+				case '+':
+					newLeft--
+				case '\\':
+				default:
+					oldLeft = -1
+				}
+				if oldLeft < 0 || newLeft < 0 {
+					return nil, &ApplyError{Path: fp.NewPath, Reason: fmt.Sprintf("corrupt patch at line %d", i+1)}
+				}
+				hunk.Text = append(hunk.Text, body)
+			}
+			if i+1 < len(lines) && strings.HasPrefix(lines[i+1], "\\") {
+				i++
+				hunk.Text = append(hunk.Text, lines[i])
+			}
+			fp.Hunks = append(fp.Hunks, hunk)
+		case line == "GIT binary patch" || strings.HasPrefix(line, "Binary files "):
+			for ; i < len(lines) && !strings.HasPrefix(lines[i], "diff --git "); i++ {
+				fp.Binary = append(fp.Binary, lines[i])
+			}
+			i--
+		case len(fp.Hunks) > 0:
+			return nil, corrupt
+		case strings.HasPrefix(line, "old mode "):
+			fp.OldMode = strings.TrimPrefix(line, "old mode ")
+		case strings.HasPrefix(line, "new mode "):
+			fp.NewMode = strings.TrimPrefix(line, "new mode ")
+		case strings.HasPrefix(line, "deleted file mode "):
+			fp.IsDelete = true
+			fp.OldMode = strings.TrimPrefix(line, "deleted file mode ")
+		case strings.HasPrefix(line, "new file mode "):
+			fp.IsNew = true
+			fp.NewMode = strings.TrimPrefix(line, "new file mode ")
+		case strings.HasPrefix(line, "rename from "):
+			fp.OldPath = unquotePath(strings.TrimPrefix(line, "rename from "))
+		case strings.HasPrefix(line, "copy from "):
+			fp.OldPath = unquotePath(strings.TrimPrefix(line, "copy from "))
+			fp.IsCopy = true
+		case strings.HasPrefix(line, "rename to "):
+			fp.NewPath = unquotePath(strings.TrimPrefix(line, "rename to "))
+		case strings.HasPrefix(line, "copy to "):
+			fp.NewPath = unquotePath(strings.TrimPrefix(line, "copy to "))
+		case strings.HasPrefix(line, "index "):
+			fields := strings.Fields(strings.TrimPrefix(line, "index "))
+			if hashes := strings.SplitN(fields[0], "..", 2); len(hashes) == 2 {
+				fp.OldHash, fp.NewHash = hashes[0], hashes[1]
+			}
+			if len(fields) > 1 && fp.OldMode == "" && fp.NewMode == "" {
+				fp.OldMode, fp.NewMode = fields[1], fields[1]
+			}
+		case strings.HasPrefix(line, "--- "):
+			if name, ok := patchName(line[4:], "a/"); ok {
+				fp.OldPath = name
+			}
+		case strings.HasPrefix(line, "+++ "):
+			if name, ok := patchName(line[4:], "b/"); ok {
+				fp.NewPath = name
+			}
+		case strings.HasPrefix(line, "similarity index "), strings.HasPrefix(line, "dissimilarity index "):
+		case strings.HasPrefix(line, " "), strings.HasPrefix(line, "+"), strings.HasPrefix(line, "-"):
+			return nil, corrupt
+		}
+	}
+	return patches, nil
+}
+
+// patchName returns the path of a "---" or "+++" line, which is false for
+// /dev/null.
+func patchName(name, prefix string) (string, bool) {
+	name = unquotePath(strings.TrimSuffix(name, "\t"))
+	if name == "/dev/null" {
+		return "", false
+	}
+	return strings.TrimPrefix(name, prefix), true
+}
+
+// reversed returns the patch that undoes fp.
+func (fp *filePatch) reversed() *filePatch {
+	result := *fp
+	result.OldPath, result.NewPath = fp.NewPath, fp.OldPath
+	result.OldMode, result.NewMode = fp.NewMode, fp.OldMode
+	result.OldHash, result.NewHash = fp.NewHash, fp.OldHash
+	result.IsNew, result.IsDelete = fp.IsDelete, fp.IsNew
+	result.reverse = !fp.reverse
+
+	result.Hunks = make([]Hunk, len(fp.Hunks))
+	for i, hunk := range fp.Hunks {
+		reversed := hunk
+		reversed.OldLine, reversed.NewLine = hunk.NewLine, hunk.OldLine
+		reversed.OldCnt, reversed.NewCnt = hunk.NewCnt, hunk.OldCnt
+		reversed.Text = make([]string, len(hunk.Text))
+		for j, line := range hunk.Text {
+			switch {
+			case j == 0:
+			case strings.HasPrefix(line, "+"):
+				line = "-" + line[1:]
+			case strings.HasPrefix(line, "-"):
+				line = "+" + line[1:]
+			}
+			reversed.Text[j] = line
+		}
+		result.Hunks[i] = reversed
+	}
+	return &result
+}
+
+// hunkImages returns the lines a hunk expects to find and the lines it
+// leaves in their place, each with its line ending, along with the number
+// of context lines before the first change and after the last one.
+func hunkImages(hunk *Hunk) (pre, post []string, leading, trailing int) {
+	var last byte
+	changed := false
+	for _, line := range hunk.Text[1:] {
+		kind, text := byte(' '), ""
+		if line != "" {
+			kind, text = line[0], line[1:]
+		}
+
+		switch kind {
+		case '\\':
+			// The line before has no newline at the end of the file
+			if last != '+' && len(pre) > 0 {
+				pre[len(pre)-1] = strings.TrimSuffix(pre[len(pre)-1], "\n")
+			}
+			if last != '-' && len(post) > 0 {
+				post[len(post)-1] = strings.TrimSuffix(post[len(post)-1], "\n")
+			}
+			continue
+		case ' ':
+			pre = append(pre, text+"\n")
+			post = append(post, text+"\n")
+			if changed {
+				trailing++
+			} else {
+				leading++
+			}
+		case '-':
+			pre = append(pre, text+"\n")
+			changed = true
+			trailing = 0
+		case '+':
+			post = append(post, text+"\n")
+			changed = true
+			trailing = 0
+		}
+		last = kind
+	}
+	return pre, post, leading, trailing
+}
+
+// hunkStart returns the index of the line where the header of hunk places
+// it, once the hunks before it are applied.
+func hunkStart(hunk *Hunk) int {
+	if hunk.NewCnt > 0 {
+		return hunk.NewLine - 1
+	}
+	return hunk.NewLine
+}
+
+// applyHunks applies the hunks of one file in order, the way "git apply
+// --allow-overlap" does: a hunk must match exactly, but when it is not
+// where its header says it may have moved, and the closest place it matches
+// is used. A hunk that starts at the first line must stay there, and one
+// without trailing context must end the file. The OfsDelta of each hunk is
+// set to how many lines it moved.
+func applyHunks(path string, lines []string, hunks []Hunk) ([]string, error) {
+	for i := range hunks {
+		hunk := &hunks[i]
+		pre, post, _, trailing := hunkImages(hunk)
+
+		expected := hunkStart(hunk)
+		if expected > len(lines) {
+			expected = len(lines)
+		}
+		matchBeginning := hunk.OldLine <= 1
+		matchEnd := trailing == 0
+
+		pos := findHunk(lines, pre, expected, matchBeginning, matchEnd)
+		if pos < 0 {
+			return nil, hunkMismatch(path, i+1, lines, pre, expected, matchBeginning, matchEnd)
+		}
+		hunk.OfsDelta = pos - expected
+
+		result := make([]string, 0, len(lines)-len(pre)+len(post))
+		result = append(result, lines[:pos]...)
+		result = append(result, post...)
+		lines = append(result, lines[pos+len(pre):]...)
+	}
+	return lines, nil
+}
+
+// findHunk returns the position closest to pos where pre matches lines, or
+// -1 when there is none.
+func findHunk(lines, pre []string, pos int, matchBeginning, matchEnd bool) int {
+	last := len(lines) - len(pre)
+	matches := func(at int) bool {
+		if at < 0 || at > last {
+			return false
+		}
+		for k, line := range pre {
+			if lines[at+k] != line {
+				return false
+			}
+		}
+		return true
+	}
+
+	switch {
+	case matchBeginning && matchEnd:
+		if last == 0 && matches(0) {
+			return 0
+		}
+		return -1
+	case matchBeginning:
+		if matches(0) {
+			return 0
+		}
+		return -1
+	case matchEnd:
+		if matches(last) {
+			return last
+		}
+		return -1
+	}
+
+	for d := 0; pos-d >= 0 || pos+d <= last; d++ {
+		if matches(pos + d) {
+			return pos + d
+		}
+		if d > 0 && matches(pos-d) {
+			return pos - d
+		}
+	}
+	return -1
+}
+
+// hunkMismatch describes the first line that keeps a hunk from applying
+// where it was expected.
+func hunkMismatch(path string, number int, lines, pre []string, pos int, matchBeginning, matchEnd bool) error {
+	if matchBeginning {
+		pos = 0
+	}
+
+	err := &ApplyError{Path: path, Hunk: number, Line: pos + 1}
+	for k, expected := range pre {
+		found := ""
+		if pos+k < len(lines) {
+			found = lines[pos+k]
+		}
+		if found != expected {
+			err.Line, err.Expected, err.Found = pos+k+1, expected, found
+			return err
+		}
+	}
+	if end := pos + len(pre); matchEnd && end < len(lines) {
+		err.Line, err.Found = end+1, lines[end]
+		return err
+	}
+	err.Reason = "it does not match where it belongs"
+	return err
+}
+
+// applyBinary applies a binary patch with the full index line git needs to
+// trust it: a literal or a delta against the old content, and the reverse
+// of it as the second part.
+func applyBinary(fp *filePatch, old []byte) ([]byte, error) {
+	if len(fp.Binary) == 0 || fp.Binary[0] != "GIT binary patch" || len(fp.OldHash) != 40 || len(fp.NewHash) != 40 {
+		return nil, &ApplyError{Path: fp.NewPath, Reason: "cannot apply binary patch without full index line"}
+	}
+	if blobHash(old) != fp.OldHash && !(fp.OldHash == nullHash && len(old) == 0) {
+		return nil, &ApplyError{Path: fp.OldPath, Reason: fmt.Sprintf("the patch applies to '%s' (%s), which does not match the current contents", fp.OldPath, fp.OldHash)}
+	}
+
+	method, data, rest, err := decodeBinaryHunk(fp.Binary[1:])
+	if err == nil && fp.reverse {
+		method, data, _, err = decodeBinaryHunk(rest)
+	}
+	if err != nil {
+		return nil, &ApplyError{Path: fp.NewPath, Reason: err.Error()}
+	}
+
+	result := data
+	if method == "delta" {
+		if result, err = applyDelta(old, data); err != nil {
+			return nil, &ApplyError{Path: fp.NewPath, Reason: fmt.Sprintf("binary patch does not apply: %v", err)}
+		}
+	}
+	if blobHash(result) != fp.NewHash && !(fp.NewHash == nullHash && len(result) == 0) {
+		return nil, &ApplyError{Path: fp.NewPath, Reason: "binary patch does not apply"}
+	}
+	return result, nil
+}
+
+// decodeBinaryHunk decodes one "literal" or "delta" part of a binary patch
+// and returns the lines after it.
+func decodeBinaryHunk(lines []string) (string, []byte, []string, error) {
+	if len(lines) == 0 {
+		return "", nil, nil, fmt.Errorf("cannot reverse-apply a binary patch without the reverse hunk")
+	}
+	method, sizeText, _ := strings.Cut(lines[0], " ")
+	size, err := strconv.Atoi(sizeText)
+	if err != nil || (method != "literal" && method != "delta") {
+		return "", nil, nil, fmt.Errorf("unrecognized binary patch: %s", lines[0])
+	}
+
+	var compressed []byte
+	i := 1
+	for ; i < len(lines) && lines[i] != ""; i++ {
+		decoded, err := decodeBase85Line(lines[i])
+		if err != nil {
+			return "", nil, nil, err
+		}
+		compressed = append(compressed, decoded...)
+	}
+	if i < len(lines) {
+		i++
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("corrupt binary patch: %v", err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("corrupt binary patch: %v", err)
+	}
+	if len(data) != size {
+		return "", nil, nil, fmt.Errorf("corrupt binary patch: expected %d bytes, got %d", size, len(data))
+	}
+	return method, data, lines[i:], nil
+}
+
+const base85Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz!#$%&()*+-;<=>?@^_`{|}~"
+
+// decodeBase85Line decodes a line of a binary patch: a letter for its
+// length, A-Z for 1-26 bytes and a-z for 27-52, then the bytes in base 85.
+func decodeBase85Line(line string) ([]byte, error) {
+	if line == "" {
+		return nil, fmt.Errorf("corrupt binary patch line")
+	}
+	var length int
+	switch c := line[0]; {
+	case c >= 'A' && c <= 'Z':
+		length = int(c-'A') + 1
+	case c >= 'a' && c <= 'z':
+		length = int(c-'a') + 27
+	default:
+		return nil, fmt.Errorf("corrupt binary patch line: %s", line)
+	}
+
+	encoded := line[1:]
+	if len(encoded)%5 != 0 || len(encoded)/5*4 < length {
+		return nil, fmt.Errorf("corrupt binary patch line: %s", line)
+	}
+	var decoded []byte
+	for i := 0; i < len(encoded); i += 5 {
+		var acc uint64
+		for _, c := range []byte(encoded[i : i+5]) {
+			value := strings.IndexByte(base85Alphabet, c)
+			if value < 0 {
+				return nil, fmt.Errorf("corrupt binary patch line: %s", line)
+			}
+			acc = acc*85 + uint64(value)
+		}
+		if acc > 0xffffffff {
+			return nil, fmt.Errorf("corrupt binary patch line: %s", line)
+		}
+		decoded = append(decoded, byte(acc>>24), byte(acc>>16), byte(acc>>8), byte(acc))
+	}
+	return decoded[:length], nil
+}
+
+// applyTarget is what a patch is applied to: the index or the worktree.
+type applyTarget interface {
+	// name is how errors refer to the target.
+	name() string
+	// read returns the content and mode of path, and false when the target
+	// has no such file.
+	read(path string) ([]byte, string, bool, error)
+	write(path string, content []byte, mode string) error
+	remove(path string) error
+	// flush makes the writes take effect.
+	flush() error
+}
+
+// patchApplier applies patches to a target without writing anything until
+// all of them are known to apply.
+type patchApplier struct {
+	target  applyTarget
+	reverse bool
+	results []applyResult
+}
+
+type applyResult struct {
+	path    string
+	content []byte
+	mode    string
+	deleted bool
+}
+
+// read looks at the results of earlier patches before the target.
+func (a *patchApplier) read(path string) ([]byte, string, bool, error) {
+	for i := len(a.results) - 1; i >= 0; i-- {
+		if result := a.results[i]; result.path == path {
+			return result.content, result.mode, !result.deleted, nil
+		}
+	}
+	return a.target.read(path)
+}
+
+// apply works out the result of each file patch and returns the hunks that
+// applied away from where their headers placed them.
+func (a *patchApplier) apply(patches []*filePatch) ([]HunkOffset, error) {
+	var offsets []HunkOffset
+	for _, fp := range patches {
+		if a.reverse {
+			if fp.IsCopy {
+				return nil, &ApplyError{Path: fp.NewPath, Reason: "cannot reverse-apply a copy"}
+			}
+			fp = fp.reversed()
+		}
+		if fp.OldMode == gitlinkMode || fp.NewMode == gitlinkMode {
+			return nil, &ApplyError{Path: fp.NewPath, Reason: "cannot apply a submodule change"}
+		}
+
+		var old []byte
+		oldMode := fp.OldMode
+		if fp.IsNew {
+			if _, _, exists, err := a.read(fp.NewPath); err != nil {
+				return nil, err
+			} else if exists {
+				return nil, &ApplyError{Path: fp.NewPath, Reason: "already exists in " + a.target.name()}
+			}
+		} else {
+			content, mode, exists, err := a.read(fp.OldPath)
+			if err != nil {
+				return nil, err
+			}
+			if !exists {
+				return nil, &ApplyError{Path: fp.OldPath, Reason: "does not exist in " + a.target.name()}
+			}
+			old, oldMode = content, mode
+		}
+		if fp.OldPath != fp.NewPath && !fp.IsNew {
+			if _, _, exists, err := a.read(fp.NewPath); err != nil {
+				return nil, err
+			} else if exists {
+				return nil, &ApplyError{Path: fp.NewPath, Reason: "already exists in " + a.target.name()}
+			}
+		}
+
+		var content []byte
+		if len(fp.Binary) > 0 {
+			var err error
+			if content, err = applyBinary(fp, old); err != nil {
+				return nil, err
+			}
+		} else {
+			hunks := append([]Hunk{}, fp.Hunks...)
+			lines, err := applyHunks(fp.OldPath, splitContentLines(old), hunks)
+			if err != nil {
+				return nil, err
+			}
+			content = []byte(strings.Join(lines, ""))
+			for i, hunk := range hunks {
+				if hunk.OfsDelta != 0 {
+					line := hunkStart(&hunk) + hunk.OfsDelta + 1
+					offsets = append(offsets, HunkOffset{Path: fp.OldPath, Hunk: i + 1, Line: line, Offset: hunk.OfsDelta})
+				}
+			}
+		}
+
+		if fp.IsDelete {
+			if len(content) > 0 {
+				return nil, &ApplyError{Path: fp.OldPath, Reason: "removal patch leaves file contents"}
+			}
+			a.results = append(a.results, applyResult{path: fp.OldPath, deleted: true})
+			continue
+		}
+
+		mode := fp.NewMode
+		if mode == "" {
+			mode = oldMode
+		}
+		if mode == "" {
+			mode = "100644"
+		}
+		if fp.OldPath != fp.NewPath && !fp.IsNew && !fp.IsCopy {
+			a.results = append(a.results, applyResult{path: fp.OldPath, deleted: true})
+		}
+		a.results = append(a.results, applyResult{path: fp.NewPath, content: content, mode: mode})
+	}
+	return offsets, nil
+}
+
+// commit writes the results to the target.
+func (a *patchApplier) commit() error {
+	for _, result := range a.results {
+		var err error
+		if result.deleted {
+			err = a.target.remove(result.path)
+		} else {
+			err = a.target.write(result.path, result.content, result.mode)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return a.target.flush()
+}
+
+const gitlinkMode = "160000"
+
+// indexTarget applies patches to the index: blobs are written to the object
+// database and the entries updated with a single "update-index --index-info".
+type indexTarget struct {
+	repo    *Repository
+	records bytes.Buffer
+}
+
+func (t *indexTarget) name() string {
+	return "index"
+}
+
+func (t *indexTarget) read(path string) ([]byte, string, bool, error) {
+	output, err := t.repo.RunCommand("ls-files", "-s", "-z", "--", path)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	for _, entry := range strings.Split(string(output), "\x00") {
+		info, name, ok := strings.Cut(entry, "\t")
+		fields := strings.Fields(info)
+		if !ok || name != path || len(fields) != 3 {
+			continue
+		}
+		if fields[2] != "0" {
+			return nil, "", false, &ApplyError{Path: path, Reason: "is unmerged in the index"}
+		}
+		if fields[1] == emptyBlobHash && t.intentToAdd(path) {
+			return nil, "", false, nil
+		}
+		if fields[0] == gitlinkMode {
+			return nil, fields[0], true, nil
+		}
+		content, err := t.repo.RunCommand("cat-file", "blob", fields[1])
+		if err != nil {
+			return nil, "", false, err
+		}
+		return content, fields[0], true, nil
+	}
+	return nil, "", false, nil
+}
+
+// intentToAdd reports whether path was added with "git add -N", which a
+// patch creating the file may replace.
+func (t *indexTarget) intentToAdd(path string) bool {
+	data, err := os.ReadFile(t.repo.RepoPath("index"))
+	if err != nil {
+		return false
+	}
+	entries, err := parseIndex(data)
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		if entry.Path == path {
+			return entry.IntentToAdd
+		}
+	}
+	return false
+}
+
+func (t *indexTarget) write(path string, content []byte, mode string) error {
+	output, err := t.repo.RunCommandWithEnv(nil, content, "hash-object", "-w", "--stdin", "--no-filters")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(&t.records, "%s %s\t%s\x00", mode, strings.TrimSpace(string(output)), path)
+	return nil
+}
+
+func (t *indexTarget) remove(path string) error {
+	fmt.Fprintf(&t.records, "0 %s\t%s\x00", nullHash, path)
+	return nil
+}
+
+func (t *indexTarget) flush() error {
+	if t.records.Len() == 0 {
+		return nil
+	}
+	return t.repo.RunCommandWithStdin(t.records.Bytes(), "update-index", "-z", "--index-info")
+}
+
+// worktreeTarget applies patches to the files of the worktree. Files that
+// git converts, by core.autocrlf or attributes, are patched in the form
+// they have in the index and converted back when written.
+type worktreeTarget struct {
+	repo  *Repository
+	root  string
+	rules *conversionRules
+}
+
+func (t *worktreeTarget) name() string {
+	return "working directory"
+}
+
+func (t *worktreeTarget) read(path string) ([]byte, string, bool, error) {
+	full := filepath.Join(t.root, filepath.FromSlash(path))
+	info, err := os.Lstat(full)
+	if os.IsNotExist(err) {
+		return nil, "", false, nil
+	}
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(full)
+		return []byte(target), "120000", true, err
+	case info.IsDir():
+		return nil, gitlinkMode, true, nil
+	}
+	content, err := os.ReadFile(full)
+	if err != nil {
+		return nil, "", false, err
+	}
+	mode := "100644"
+	if info.Mode()&0100 != 0 {
+		mode = "100755"
+	}
+	if t.converts(path) {
+		if content, err = t.clean(path, content); err != nil {
+			return nil, "", false, err
+		}
+	}
+	return content, mode, true, nil
+}
+
+// clean converts content the way "git add" would. The blob git writes on the
+// way goes to a scratch object directory, so that reading the worktree, as
+// checking a patch does, leaves the repository's objects alone.
+func (t *worktreeTarget) clean(path string, content []byte) ([]byte, error) {
+	objects, err := os.MkdirTemp(t.repo.gitDir, "addp-objects-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(objects)
+
+	env := []string{"GIT_OBJECT_DIRECTORY=" + objects}
+	output, err := t.repo.commandOutputWithEnv(env, content, "hash-object", "-w", "--stdin", "--path="+path)
+	if err != nil {
+		return nil, err
+	}
+	return t.repo.commandOutputWithEnv(env, nil, "cat-file", "blob", strings.TrimSpace(string(output)))
+}
+
+// converts reports whether git converts the content of path.
+func (t *worktreeTarget) converts(path string) bool {
+	if t.rules == nil {
+		backend := t.repo.Backend()
+		t.rules = newConversionRules(backend, newOSDirFS(t.repo.gitDir), newOSDirFS(t.root), globalAttributes(backend))
+	}
+	return t.rules.converts(path)
+}
+
+func (t *worktreeTarget) write(path string, content []byte, mode string) error {
+	full := filepath.Join(t.root, filepath.FromSlash(path))
+	if err := os.MkdirAll(filepath.Dir(full), 0777); err != nil {
+		return err
+	}
+	if info, err := os.Lstat(full); err == nil && (mode == "120000" || !info.Mode().IsRegular()) {
+		if err := os.Remove(full); err != nil {
+			return err
+		}
+	}
+
+	if mode == "120000" {
+		return os.Symlink(string(content), full)
+	}
+	if t.converts(path) {
+		// Smudge the content the way "git checkout" would
+		output, err := t.repo.commandOutput(content, "hash-object", "-w", "--stdin", "--no-filters")
+		if err != nil {
+			return err
+		}
+		if content, err = t.repo.commandOutput(nil, "cat-file", "--filters", "--path="+path, strings.TrimSpace(string(output))); err != nil {
+			return err
+		}
+	}
+	perm := os.FileMode(0644)
+	if mode == "100755" {
+		perm = 0755
+	}
+	if err := os.WriteFile(full, content, perm); err != nil {
+		return err
+	}
+	return os.Chmod(full, perm)
+}
+
+// remove deletes the file and the directories it leaves empty.
+func (t *worktreeTarget) remove(path string) error {
+	full := filepath.Join(t.root, filepath.FromSlash(path))
+	if err := os.Remove(full); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for dir := filepath.Dir(full); dir != t.root && strings.HasPrefix(dir, t.root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (t *worktreeTarget) flush() error {
+	return nil
+}
+
+// applyBuiltin applies patch for mode, or with check only makes sure it
+// would apply, without running git apply. It returns the hunks that applied
+// away from where their headers placed them.
+func (r *Repository) applyBuiltin(patch []byte, mode PatchMode, check bool) ([]HunkOffset, error) {
+	patches, err := parsePatch(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	reverse, cached := false, false
+	for _, arg := range mode.ApplyCmd {
+		reverse = reverse || arg == "-R"
+		cached = cached || arg == "--cached"
+	}
+
+	var appliers []*patchApplier
+	if mode.UpdatesIndex() {
+		appliers = append(appliers, &patchApplier{target: &indexTarget{repo: r}, reverse: reverse})
+	}
+	if !cached {
+		appliers = append(appliers, &patchApplier{target: &worktreeTarget{repo: r, root: r.workTree}, reverse: reverse})
+	}
+
+	var offsets []HunkOffset
+	for i, applier := range appliers {
+		applied, err := applier.apply(patches)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			offsets = applied
+		}
+	}
+	if check {
+		return offsets, nil
+	}
+	for _, applier := range appliers {
+		if err := applier.commit(); err != nil {
+			return nil, err
+		}
+	}
+	return offsets, nil
+}
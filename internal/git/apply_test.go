@@ -0,0 +1,426 @@
+package git
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// mapTarget is an applyTarget kept in memory.
+type mapTarget struct {
+	files map[string]applyResult
+}
+
+func (t *mapTarget) name() string { return "index" }
+func (t *mapTarget) flush() error { return nil }
+
+func (t *mapTarget) read(path string) ([]byte, string, bool, error) {
+	file, ok := t.files[path]
+	return file.content, file.mode, ok, nil
+}
+
+func (t *mapTarget) write(path string, content []byte, mode string) error {
+	t.files[path] = applyResult{path: path, content: content, mode: mode}
+	return nil
+}
+
+func (t *mapTarget) remove(path string) error {
+	delete(t.files, path)
+	return nil
+}
+
+func TestParsePatch(t *testing.T) {
+	patch := `diff --git a/old b/new
+similarity index 90%
+rename from old
+rename to new
+index 1111111..2222222
+--- a/old
++++ b/new
+@@ -1,2 +1,2 @@
+ a
+-b
++B
+\ No newline at end of file
+diff --git a/script b/script
+old mode 100644
+new mode 100755
+diff --git a/gone b/gone
+deleted file mode 100644
+index 3333333..0000000
+--- a/gone
++++ /dev/null
+@@ -1 +0,0 @@
+-x
+`
+	patches, err := parsePatch([]byte(patch))
+	if err != nil {
+		t.Fatalf("parsePatch() failed: %v", err)
+	}
+	if len(patches) != 3 {
+		t.Fatalf("Expected 3 file patches, got %d", len(patches))
+	}
+
+	rename := patches[0]
+	if rename.OldPath != "old" || rename.NewPath != "new" || rename.OldHash != "1111111" {
+		t.Errorf("Unexpected rename patch: %+v", rename)
+	}
+	if len(rename.Hunks) != 1 || len(rename.Hunks[0].Text) != 5 || rename.Hunks[0].OldCnt != 2 {
+		t.Errorf("Unexpected hunks: %+v", rename.Hunks)
+	}
+
+	if mode := patches[1]; mode.OldMode != "100644" || mode.NewMode != "100755" || len(mode.Hunks) != 0 {
+		t.Errorf("Unexpected mode patch: %+v", mode)
+	}
+
+	if gone := patches[2]; !gone.IsDelete || gone.OldMode != "100644" || gone.NewPath != "gone" {
+		t.Errorf("Unexpected deletion patch: %+v", gone)
+	}
+}
+
+func TestParsePatchCorrupt(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		line  string
+	}{
+		{
+			name:  "too few lines",
+			patch: "diff --git a/f b/f\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n",
+			line:  "line 6",
+		},
+		{
+			name:  "too many lines",
+			patch: "diff --git a/f b/f\n@@ -1,2 +1,2 @@\n a\n-b\n+B\n+C\n",
+			line:  "line 6",
+		},
+		{
+			name:  "bad line",
+			patch: "diff --git a/f b/f\n@@ -1,2 +1,2 @@\n a\n*b\n",
+			line:  "line 4",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parsePatch([]byte(test.patch))
+			var applyErr *ApplyError
+			if !errors.As(err, &applyErr) || !strings.Contains(applyErr.Reason, "corrupt patch at "+test.line) {
+				t.Errorf("Expected a corrupt patch at %s, got %v", test.line, err)
+			}
+		})
+	}
+}
+
+func TestApplyHunks(t *testing.T) {
+	lines := splitContentLines([]byte("1\n2\n3\n4\n5\n6\n7\n8\n9\n"))
+	hunks := []Hunk{
+		// Expected at line 2, but found two lines further down
+		{Text: []string{"@@ -2,3 +2,3 @@", " 4", "-5", "+five", " 6"}, OldLine: 2, OldCnt: 3, NewLine: 2, NewCnt: 3},
+		{Text: []string{"@@ -8,2 +8,1 @@", " 8", "-9"}, OldLine: 8, OldCnt: 2, NewLine: 8, NewCnt: 1},
+	}
+
+	result, err := applyHunks("f", lines, hunks)
+	if err != nil {
+		t.Fatalf("applyHunks() failed: %v", err)
+	}
+	if content := strings.Join(result, ""); content != "1\n2\n3\n4\nfive\n6\n7\n8\n" {
+		t.Errorf("applyHunks() = %q", content)
+	}
+	if hunks[0].OfsDelta != 2 || hunks[1].OfsDelta != 0 {
+		t.Errorf("Expected offsets 2 and 0, got %d and %d", hunks[0].OfsDelta, hunks[1].OfsDelta)
+	}
+}
+
+func TestApplyHunksMismatch(t *testing.T) {
+	lines := splitContentLines([]byte("a\nb\nc\nd\ne\n"))
+
+	tests := []struct {
+		name     string
+		hunk     Hunk
+		expected ApplyError
+		message  string
+	}{
+		{
+			name:     "changed line",
+			hunk:     Hunk{Text: []string{"@@ -2,3 +2,3 @@", " b", "-x", "+y", " d"}, OldLine: 2, OldCnt: 3, NewLine: 2, NewCnt: 3},
+			expected: ApplyError{Path: "f", Hunk: 1, Line: 3, Expected: "x\n", Found: "c\n"},
+			message:  `patch failed: f:3: hunk #1 does not apply: expected "x", found "c"`,
+		},
+		{
+			name:     "not at the end",
+			hunk:     Hunk{Text: []string{"@@ -4,1 +4,2 @@", " d", "+new"}, OldLine: 4, OldCnt: 1, NewLine: 4, NewCnt: 2},
+			expected: ApplyError{Path: "f", Hunk: 1, Line: 5, Found: "e\n"},
+			message:  `patch failed: f:5: hunk #1 does not apply: expected end of file, found "e"`,
+		},
+		{
+			name:     "missing newline",
+			hunk:     Hunk{Text: []string{"@@ -5 +5 @@", "-e", `\ No newline at end of file`, "+E"}, OldLine: 5, OldCnt: 1, NewLine: 5, NewCnt: 1},
+			expected: ApplyError{Path: "f", Hunk: 1, Line: 5, Expected: "e", Found: "e\n"},
+			message:  `patch failed: f:5: hunk #1 does not apply: expected "e" without newline, found "e"`,
+		},
+		{
+			name:     "carriage return",
+			hunk:     Hunk{Text: []string{"@@ -1,2 +1,2 @@", " a\r", "-b", "+B"}, OldLine: 1, OldCnt: 2, NewLine: 1, NewCnt: 2},
+			expected: ApplyError{Path: "f", Hunk: 1, Line: 1, Expected: "a\r\n", Found: "a\n"},
+			message:  `patch failed: f:1: hunk #1 does not apply: expected "a\r", found "a"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := applyHunks("f", lines, []Hunk{test.hunk})
+			var applyErr *ApplyError
+			if !errors.As(err, &applyErr) {
+				t.Fatalf("Expected an ApplyError, got %v", err)
+			}
+			if *applyErr != test.expected {
+				t.Errorf("applyHunks() error = %+v, expected %+v", *applyErr, test.expected)
+			}
+			if applyErr.Error() != test.message {
+				t.Errorf("Error() = %q, expected %q", applyErr.Error(), test.message)
+			}
+		})
+	}
+}
+
+func TestApplyHunksNoNewline(t *testing.T) {
+	lines := splitContentLines([]byte("a\nb"))
+	hunks := []Hunk{{
+		Text:    []string{"@@ -1,2 +1,2 @@", " a", "-b", `\ No newline at end of file`, "+b"},
+		OldLine: 1, OldCnt: 2, NewLine: 1, NewCnt: 2,
+	}}
+
+	result, err := applyHunks("f", lines, hunks)
+	if err != nil {
+		t.Fatalf("applyHunks() failed: %v", err)
+	}
+	if content := strings.Join(result, ""); content != "a\nb\n" {
+		t.Errorf("applyHunks() = %q, expected %q", content, "a\nb\n")
+	}
+}
+
+func TestFilePatchReversed(t *testing.T) {
+	fp := &filePatch{
+		OldPath: "a", NewPath: "b", OldMode: "100644", NewMode: "100755", IsNew: true,
+		Hunks: []Hunk{{Text: []string{"@@ -0,0 +1 @@", "+x"}, OldLine: 0, OldCnt: 0, NewLine: 1, NewCnt: 1}},
+	}
+
+	reversed := fp.reversed()
+	if reversed.OldPath != "b" || reversed.NewPath != "a" || reversed.OldMode != "100755" || !reversed.IsDelete || reversed.IsNew {
+		t.Errorf("Unexpected reversed patch: %+v", reversed)
+	}
+	hunk := reversed.Hunks[0]
+	if !reflect.DeepEqual(hunk.Text, []string{"@@ -0,0 +1 @@", "-x"}) || hunk.OldLine != 1 || hunk.OldCnt != 1 || hunk.NewCnt != 0 {
+		t.Errorf("Unexpected reversed hunk: %+v", hunk)
+	}
+	if fp.Hunks[0].Text[1] != "+x" {
+		t.Error("reversed() should not change the original patch")
+	}
+}
+
+func TestApplyBinary(t *testing.T) {
+	patch := `diff --git a/f b/f
+index 20b5be91886d0b6f26dc98a225c0dac05fe2c86e..39c99e870faefd5e253799f4536a8ef5ff81f090 100644
+GIT binary patch
+literal 4
+LcmYdfNJ<6(0<Qrl
+
+literal 3
+KcmYdfNCE%>hycU@
+
+`
+	patches, err := parsePatch([]byte(patch))
+	if err != nil {
+		t.Fatalf("parsePatch() failed: %v", err)
+	}
+
+	result, err := applyBinary(patches[0], []byte("a\x00b"))
+	if err != nil || string(result) != "a\x00bc" {
+		t.Errorf("applyBinary() = %q, %v", result, err)
+	}
+
+	result, err = applyBinary(patches[0].reversed(), []byte("a\x00bc"))
+	if err != nil || string(result) != "a\x00b" {
+		t.Errorf("applyBinary() in reverse = %q, %v", result, err)
+	}
+
+	if _, err := applyBinary(patches[0], []byte("other")); err == nil {
+		t.Error("Expected applyBinary() to refuse content the patch is not for")
+	}
+}
+
+func TestDecodeBase85Line(t *testing.T) {
+	decoded, err := decodeBase85Line("KcmYdfNCE%>hycU@")
+	if err != nil || len(decoded) != 11 {
+		t.Errorf("decodeBase85Line() = %v, %v", decoded, err)
+	}
+
+	for _, line := range []string{"", "0abcde", "Babcd", "B~~~~~"} {
+		if _, err := decodeBase85Line(line); err == nil {
+			t.Errorf("Expected decodeBase85Line(%q) to fail", line)
+		}
+	}
+}
+
+func TestPatchApplier(t *testing.T) {
+	target := &mapTarget{files: map[string]applyResult{
+		"old":  {content: []byte("a\nb\n"), mode: "100644"},
+		"kept": {content: []byte("x\n"), mode: "100755"},
+	}}
+
+	patch := `diff --git a/old b/new
+rename from old
+rename to new
+--- a/old
++++ b/new
+@@ -1,2 +1,2 @@
+ a
+-b
++c
+diff --git a/new b/new
+--- a/new
++++ b/new
+@@ -1,2 +1,3 @@
+ a
+ c
++d
+diff --git a/kept b/kept
+deleted file mode 100755
+--- a/kept
++++ /dev/null
+@@ -1 +0,0 @@
+-x
+`
+	patches, err := parsePatch([]byte(patch))
+	if err != nil {
+		t.Fatalf("parsePatch() failed: %v", err)
+	}
+
+	applier := &patchApplier{target: target}
+	offsets, err := applier.apply(patches)
+	if err != nil {
+		t.Fatalf("apply() failed: %v", err)
+	}
+	if len(offsets) != 0 {
+		t.Errorf("Expected no offsets, got %v", offsets)
+	}
+	if len(target.files) != 2 {
+		t.Error("apply() should not write before commit()")
+	}
+
+	if err := applier.commit(); err != nil {
+		t.Fatalf("commit() failed: %v", err)
+	}
+	if len(target.files) != 1 || string(target.files["new"].content) != "a\nc\nd\n" || target.files["new"].mode != "100644" {
+		t.Errorf("Unexpected files after apply: %+v", target.files)
+	}
+
+	// The reverse brings the files back
+	applier = &patchApplier{target: target, reverse: true}
+	reversed := []*filePatch{patches[2], patches[1], patches[0]}
+	if _, err := applier.apply(reversed); err != nil {
+		t.Fatalf("reverse apply() failed: %v", err)
+	}
+	applier.commit()
+	if string(target.files["old"].content) != "a\nb\n" || string(target.files["kept"].content) != "x\n" {
+		t.Errorf("Unexpected files after reverse apply: %+v", target.files)
+	}
+}
+
+func TestPatchApplierOffsets(t *testing.T) {
+	target := &mapTarget{files: map[string]applyResult{
+		"f": {content: []byte("0\n0\n1\n2\n3\n4\n5\n6\n"), mode: "100644"},
+	}}
+
+	patch := `diff --git a/f b/f
+--- a/f
++++ b/f
+@@ -2,3 +2,3 @@
+ 2
+-3
++three
+ 4
+@@ -6 +6 @@
+-6
++six
+`
+	patches, err := parsePatch([]byte(patch))
+	if err != nil {
+		t.Fatalf("parsePatch() failed: %v", err)
+	}
+
+	offsets, err := (&patchApplier{target: target}).apply(patches)
+	if err != nil {
+		t.Fatalf("apply() failed: %v", err)
+	}
+	expected := []string{
+		"f: hunk #1 succeeded at 4 (offset 2 lines).",
+		"f: hunk #2 succeeded at 8 (offset 2 lines).",
+	}
+	if len(offsets) != len(expected) {
+		t.Fatalf("Expected %d offsets, got %v", len(expected), offsets)
+	}
+	for i, offset := range offsets {
+		if offset.String() != expected[i] {
+			t.Errorf("offsets[%d] = %q, expected %q", i, offset.String(), expected[i])
+		}
+	}
+
+	if s := (HunkOffset{Path: "f", Hunk: 1, Line: 2, Offset: -1}).String(); s != "f: hunk #1 succeeded at 2 (offset -1 line)." {
+		t.Errorf("String() = %q", s)
+	}
+}
+
+func TestPatchApplierErrors(t *testing.T) {
+	target := &mapTarget{files: map[string]applyResult{
+		"f": {content: []byte("x\ny\n"), mode: "100644"},
+	}}
+
+	tests := []struct {
+		patch  string
+		reason string
+	}{
+		{"diff --git a/f b/f\nnew file mode 100644\n--- /dev/null\n+++ b/f\n@@ -0,0 +1 @@\n+x\n", "f: already exists in index"},
+		{"diff --git a/g b/g\n--- a/g\n+++ b/g\n@@ -1 +1 @@\n-x\n+z\n", "g: does not exist in index"},
+		{"diff --git a/f b/f\ndeleted file mode 100644\n--- a/f\n+++ /dev/null\n@@ -1,2 +1 @@\n-x\n y\n", "f: removal patch leaves file contents"},
+	}
+
+	for _, test := range tests {
+		patches, err := parsePatch([]byte(test.patch))
+		if err != nil {
+			t.Fatalf("parsePatch() failed: %v", err)
+		}
+		applier := &patchApplier{target: target}
+		if _, err := applier.apply(patches); err == nil || err.Error() != test.reason {
+			t.Errorf("apply() error = %v, expected %q", err, test.reason)
+		}
+	}
+}
+
+func TestWorktreeTargetReadConverts(t *testing.T) {
+	dir, git := newScratchRepository(t)
+	git("config", "core.autocrlf", "true")
+	if err := os.WriteFile(filepath.Join(dir, "f"), []byte("a\r\nb\r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := NewRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := git("count-objects")
+	content, _, _, err := (&worktreeTarget{repo: repo, root: repo.WorkTree()}).read("f")
+	if err != nil {
+		t.Fatalf("read() failed: %v", err)
+	}
+	if string(content) != "a\nb\n" {
+		t.Errorf("read() = %q, expected the content with LF line endings", content)
+	}
+	if after := git("count-objects"); after != before {
+		t.Errorf("read() wrote objects: %q before, %q after", before, after)
+	}
+}
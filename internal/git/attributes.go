@@ -0,0 +1,218 @@
+package git
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// conversionAttrs are the attributes under which git converts the content
+// of a file between the worktree and the index: line endings, filter
+// drivers, $Id$ expansion and encodings.
+var conversionAttrs = map[string]bool{
+	"text":                  true,
+	"crlf":                  true,
+	"eol":                   true,
+	"filter":                true,
+	"ident":                 true,
+	"working-tree-encoding": true,
+}
+
+// attrRule is one line of a .gitattributes style file, keeping only the
+// conversion attributes it sets (true) or unsets (false).
+type attrRule struct {
+	base     string
+	re       *regexp.Regexp
+	basename bool
+	attrs    map[string]bool
+}
+
+// parseAttributesFile parses the lines of an attributes file whose patterns
+// are relative to the directory base ("" for the top of the worktree).
+// Macros, including the built-in binary, are expanded with those defined in
+// macros, which the file's own [attr] lines are added to.
+func parseAttributesFile(data []byte, base string, macros map[string]map[string]bool) []attrRule {
+	var rules []attrRule
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(strings.TrimSuffix(line, "\r"))
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		attrs := make(map[string]bool)
+		for _, field := range fields[1:] {
+			setAttr(attrs, field, macros)
+		}
+		if name, ok := strings.CutPrefix(fields[0], "[attr]"); ok {
+			macros[name] = attrs
+			continue
+		}
+
+		pattern := strings.Trim(fields[0], `"`)
+		// Negative patterns are not allowed and directories have no content
+		if len(attrs) == 0 || strings.HasPrefix(pattern, "!") || strings.HasSuffix(pattern, "/") {
+			continue
+		}
+
+		rule := attrRule{base: base, attrs: attrs, basename: !strings.Contains(pattern, "/")}
+		re, err := regexp.Compile("^" + globToRegexp(strings.TrimPrefix(pattern, "/"), true) + "$")
+		if err != nil {
+			continue
+		}
+		rule.re = re
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// setAttr records what an attribute field of a rule does to the conversion
+// attributes: "attr" and "attr=value" set it, "-attr" and "!attr" unset it.
+// A macro name stands for the attributes it was defined with.
+func setAttr(attrs map[string]bool, field string, macros map[string]map[string]bool) {
+	name, _, _ := strings.Cut(field, "=")
+	set := true
+	if strings.HasPrefix(name, "-") || strings.HasPrefix(name, "!") {
+		name, set = name[1:], false
+	}
+
+	if conversionAttrs[name] {
+		attrs[name] = set
+		return
+	}
+	if name == "binary" && set {
+		attrs["text"] = false
+		return
+	}
+	if macro, ok := macros[name]; ok && set {
+		for attr, value := range macro {
+			attrs[attr] = value
+		}
+	}
+}
+
+// conversionRules tells which paths git converts when it reads them from or
+// writes them to the worktree, from core.autocrlf and the attributes files
+// that apply to them. core.eol only matters for paths the text attribute is
+// set on, so it needs no check of its own.
+type conversionRules struct {
+	autocrlf bool
+	worktree fs.FS
+	base     []attrRule // The global attributes file
+	info     []attrRule // $GIT_DIR/info/attributes, which takes precedence
+	macros   map[string]map[string]bool
+	dirs     map[string][]attrRule
+}
+
+// newConversionRules takes the rules of global, the content of the global
+// attributes file, and reads info/attributes; the attributes files of the
+// worktree are read as paths are asked about.
+func newConversionRules(config Backend, gitDir, worktree fs.FS, global []byte) *conversionRules {
+	c := &conversionRules{
+		worktree: worktree,
+		macros:   make(map[string]map[string]bool),
+		dirs:     make(map[string][]attrRule),
+	}
+	if value, ok := config.Config("core.autocrlf"); ok {
+		enabled, err := parseConfigBool(value)
+		c.autocrlf = enabled || (err != nil && strings.EqualFold(value, "input"))
+	}
+	c.base = parseAttributesFile(global, "", c.macros)
+	if data, err := fs.ReadFile(gitDir, "info/attributes"); err == nil {
+		c.info = parseAttributesFile(data, "", c.macros)
+	}
+	return c
+}
+
+// converts reports whether git would convert the content of the file name.
+func (c *conversionRules) converts(name string) bool {
+	if c.autocrlf {
+		return true
+	}
+
+	dirs := []string{""}
+	if dir := path.Dir(name); dir != "." {
+		parts := strings.Split(dir, "/")
+		for i := range parts {
+			dirs = append(dirs, strings.Join(parts[:i+1], "/"))
+		}
+	}
+
+	// Later rules take precedence: deeper directories over the ones above
+	// them, and info/attributes over all of them
+	rules := c.base
+	for _, dir := range dirs {
+		rules = append(rules[:len(rules):len(rules)], c.dirRules(dir)...)
+	}
+	rules = append(rules[:len(rules):len(rules)], c.info...)
+
+	attrs := make(map[string]bool)
+	for _, rule := range rules {
+		rel := name
+		if rule.base != "" {
+			if !strings.HasPrefix(name, rule.base+"/") {
+				continue
+			}
+			rel = name[len(rule.base)+1:]
+		}
+		if rule.basename {
+			rel = path.Base(rel)
+		}
+		if rule.re.MatchString(rel) {
+			for attr, set := range rule.attrs {
+				attrs[attr] = set
+			}
+		}
+	}
+	for _, set := range attrs {
+		if set {
+			return true
+		}
+	}
+	return false
+}
+
+// dirRules returns the rules of the .gitattributes file in dir.
+func (c *conversionRules) dirRules(dir string) []attrRule {
+	if rules, ok := c.dirs[dir]; ok {
+		return rules
+	}
+	var rules []attrRule
+	if data, err := fs.ReadFile(c.worktree, path.Join(dir, ".gitattributes")); err == nil {
+		rules = parseAttributesFile(data, dir, c.macros)
+	}
+	c.dirs[dir] = rules
+	return rules
+}
+
+// globalAttributes returns the content of core.attributesFile, or of git's
+// default for it.
+func globalAttributes(config Backend) []byte {
+	file := globalConfigFile(config, "core.attributesfile", "attributes")
+	if file == "" {
+		return nil
+	}
+	data, _ := os.ReadFile(file)
+	return data
+}
+
+// globalConfigFile returns the file named by key, or git's default for it
+// under $XDG_CONFIG_HOME/git or ~/.config/git.
+func globalConfigFile(config Backend, key, name string) string {
+	if file, ok := config.Config(key); ok {
+		if strings.HasPrefix(file, "~/") {
+			home, _ := os.UserHomeDir()
+			file = filepath.Join(home, file[2:])
+		}
+		return file
+	}
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "git", name)
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".config", "git", name)
+	}
+	return ""
+}
@@ -0,0 +1,53 @@
+package git
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestConversionRules(t *testing.T) {
+	gitDir := fstest.MapFS{
+		"info/attributes": {Data: []byte("*.bin binary\n")},
+	}
+	worktree := fstest.MapFS{
+		".gitattributes": {Data: []byte(`# comment
+[attr]lfs filter=lfs -text
+*.txt text
+*.jpg lfs
+docs/*.md eol=crlf
+raw.txt -text
+*.bin text
+`)},
+		"sub/.gitattributes": {Data: []byte("*.txt !text\nkeep.txt ident\n")},
+	}
+	rules := newConversionRules(&stubBackend{}, gitDir, worktree, []byte("*.c text=auto\n"))
+
+	tests := []struct {
+		path     string
+		expected bool
+	}{
+		{"a.txt", true},
+		{"deep/a.txt", true},
+		{"raw.txt", false},
+		{"sub/a.txt", false},
+		{"sub/keep.txt", true},
+		{"photo.jpg", true},
+		{"docs/a.md", true},
+		{"docs/deep/a.md", false},
+		{"a.md", false},
+		{"x.bin", false},
+		{"main.c", true},
+		{"Makefile", false},
+	}
+
+	for _, test := range tests {
+		if result := rules.converts(test.path); result != test.expected {
+			t.Errorf("converts(%q) = %v, expected %v", test.path, result, test.expected)
+		}
+	}
+
+	autocrlf := newConversionRules(&stubBackend{config: map[string]string{"core.autocrlf": "input"}}, gitDir, worktree, nil)
+	if !autocrlf.converts("Makefile") {
+		t.Error("Expected core.autocrlf to convert every file")
+	}
+}
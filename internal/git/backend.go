@@ -0,0 +1,432 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Backend answers the read-only questions a Repository asks of git: config
+// values, HEAD, and diffs. Everything that writes still goes through the
+// Repository's command runners, which tell the backend what they changed.
+type Backend interface {
+	// Config returns the value of a config key and whether it is set.
+	Config(key string) (string, bool)
+	// Color returns the escape sequence for a color config key, as
+	// "git config --get-color" does.
+	Color(key, defaultColor string) string
+	// Head returns the commit HEAD points to; false on an unborn branch.
+	Head() (string, bool)
+	// Diff returns the output of a diff command, given without its
+	// pathspec, limited to paths.
+	Diff(args []string, paths []string) ([]string, error)
+	// Status returns the paths that differ between reference and the
+	// index, unless filter is "file-only", and between the index and the
+	// worktree, unless filter is "index-only".
+	Status(filter, reference string, paths []string) (map[string]*FileStatus, error)
+	// Untracked lists the files that are neither tracked nor ignored.
+	Untracked() ([]string, error)
+	// Apply runs an apply command, such as "apply --cached", on a patch.
+	Apply(args []string, patch []byte) error
+	// SetDiffScope forgets all cached state and makes later diffs cover
+	// the given pathspec, or the whole tree when it is empty.
+	SetDiffScope(paths []string)
+	// Invalidate forgets what is cached about paths, or everything but the
+	// config when no paths are given.
+	Invalidate(paths ...string)
+}
+
+// NewExecBackend returns a backend that runs git for every question.
+func NewExecBackend(r *Repository) Backend {
+	return &execBackend{repo: r}
+}
+
+type execBackend struct {
+	repo *Repository
+}
+
+func (b *execBackend) Config(key string) (string, bool) {
+	output, err := b.repo.RunCommand("config", key)
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(output)), true
+}
+
+func (b *execBackend) Color(key, defaultColor string) string {
+	output, err := b.repo.RunCommand("config", "--get-color", key, defaultColor)
+	if err != nil {
+		return ""
+	}
+	return string(output)
+}
+
+func (b *execBackend) Head() (string, bool) {
+	output, err := b.repo.RunCommand("rev-parse", "--verify", "-q", "HEAD")
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(output)), true
+}
+
+func (b *execBackend) Diff(args []string, paths []string) ([]string, error) {
+	cmd := append(append(append([]string{}, args...), "--"), paths...)
+	return b.repo.RunCommandLines(cmd...)
+}
+
+func (b *execBackend) Apply(args []string, patch []byte) error {
+	return b.repo.RunCommandWithStdin(patch, args...)
+}
+
+func (b *execBackend) SetDiffScope(paths []string) {}
+
+func (b *execBackend) Invalidate(paths ...string) {}
+
+// NewCachingBackend returns a backend that reads the whole config once,
+// remembers HEAD, and diffs every path of the current scope with a single
+// command per set of diff options, handing out the section of each file.
+func NewCachingBackend(r *Repository) Backend {
+	return &cachingBackend{exec: execBackend{repo: r}}
+}
+
+type cachingBackend struct {
+	exec   execBackend
+	config map[string]string
+	head   *string
+	scope  []string
+	diffs  map[string]map[string][]string
+}
+
+func (b *cachingBackend) Config(key string) (string, bool) {
+	if b.config == nil {
+		b.config = make(map[string]string)
+		if output, err := b.exec.repo.RunCommand("config", "-l", "-z"); err == nil {
+			b.config = parseConfigList(string(output))
+		}
+	}
+	value, ok := b.config[canonicalConfigKey(key)]
+	return value, ok
+}
+
+// Color resolves the color from the config read once, the way "git config
+// --get-color" would; a bad value gives no color, as git's error would.
+func (b *cachingBackend) Color(key, defaultColor string) string {
+	value, ok := b.Config(key)
+	if !ok {
+		value = defaultColor
+	}
+	color, err := parseColor(value)
+	if err != nil {
+		return ""
+	}
+	return color
+}
+
+func (b *cachingBackend) Head() (string, bool) {
+	if b.head == nil {
+		head, _ := b.exec.Head()
+		b.head = &head
+	}
+	return *b.head, *b.head != ""
+}
+
+// Diff answers a single path from the batch diff of the current scope,
+// running the batch the first time the options are used. Renames and copies
+// are diffed over two paths and are never batched; paths the batch does not
+// know about, or that were invalidated, are diffed on their own.
+func (b *cachingBackend) Diff(args []string, paths []string) ([]string, error) {
+	if len(paths) != 1 {
+		return b.exec.Diff(args, paths)
+	}
+
+	key := strings.Join(args, "\x00")
+	batch, ok := b.diffs[key]
+	if !ok {
+		lines, err := b.exec.Diff(args, b.scope)
+		if err != nil {
+			return nil, err
+		}
+		batch = splitDiffByPath(lines)
+		if b.diffs == nil {
+			b.diffs = make(map[string]map[string][]string)
+		}
+		b.diffs[key] = batch
+	}
+
+	if lines, ok := batch[paths[0]]; ok {
+		return lines, nil
+	}
+	return b.exec.Diff(args, paths)
+}
+
+func (b *cachingBackend) Status(filter, reference string, paths []string) (map[string]*FileStatus, error) {
+	return b.exec.Status(filter, reference, paths)
+}
+
+func (b *cachingBackend) Untracked() ([]string, error) {
+	return b.exec.Untracked()
+}
+
+func (b *cachingBackend) Apply(args []string, patch []byte) error {
+	return b.exec.Apply(args, patch)
+}
+
+func (b *cachingBackend) SetDiffScope(paths []string) {
+	b.Invalidate()
+	b.scope = append([]string{}, paths...)
+}
+
+func (b *cachingBackend) Invalidate(paths ...string) {
+	if len(paths) == 0 {
+		b.head = nil
+		b.diffs = nil
+		return
+	}
+	for _, batch := range b.diffs {
+		for _, path := range paths {
+			delete(batch, path)
+		}
+	}
+}
+
+// parseConfigList parses the output of "git config -l -z": entries end in
+// NUL and separate the key from the value with a newline. A key without a
+// value is a boolean set to true. Later entries override earlier ones.
+func parseConfigList(output string) map[string]string {
+	config := make(map[string]string)
+	for _, entry := range strings.Split(output, "\x00") {
+		if entry == "" {
+			continue
+		}
+		key, value := entry, "true"
+		if nl := strings.Index(entry, "\n"); nl != -1 {
+			key, value = entry[:nl], entry[nl+1:]
+		}
+		config[canonicalConfigKey(key)] = value
+	}
+	return config
+}
+
+// canonicalConfigKey lowercases the section and variable name of a config
+// key; only the subsection between them is case sensitive.
+func canonicalConfigKey(key string) string {
+	first := strings.Index(key, ".")
+	last := strings.LastIndex(key, ".")
+	if first == -1 {
+		return strings.ToLower(key)
+	}
+	return strings.ToLower(key[:first]) + key[first:last] + strings.ToLower(key[last:])
+}
+
+// parseConfigBool interprets a config value the way "git config --bool" does.
+func parseConfigBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "true", "yes", "on":
+		return true, nil
+	case "false", "no", "off", "":
+		return false, nil
+	}
+	if n, err := strconv.Atoi(value); err == nil {
+		return n != 0, nil
+	}
+	return false, fmt.Errorf("bad boolean config value '%s'", value)
+}
+
+// stdoutIsTerminal reports whether standard output is a terminal. Tests
+// replace it.
+var stdoutIsTerminal = func() bool {
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// parseColorBool interprets a color setting such as color.diff, as "git
+// config --get-colorbool" does: auto colors only output to a terminal that
+// is not dumb.
+func parseColorBool(value string) bool {
+	switch strings.ToLower(value) {
+	case "never":
+		return false
+	case "always":
+		return true
+	case "auto":
+		term := os.Getenv("TERM")
+		return term != "" && term != "dumb" && stdoutIsTerminal()
+	}
+	use, err := parseConfigBool(value)
+	return err == nil && use
+}
+
+// colorNames are the colors git knows by name, in ANSI order.
+var colorNames = []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+// colorAttrs are the SGR codes of the attributes a color may have, and of
+// their negations.
+var colorAttrs = map[string][2]int{
+	"bold":    {1, 22},
+	"dim":     {2, 22},
+	"italic":  {3, 23},
+	"ul":      {4, 24},
+	"blink":   {5, 25},
+	"reverse": {7, 27},
+	"strike":  {9, 29},
+}
+
+// parseColor turns a color config value, such as "bold red ul", into its
+// escape sequence the way git does: "reset" first, then the attributes in
+// order, the foreground and the background. Like git, it takes color names
+// in any case but attributes only in lower case.
+func parseColor(value string) (string, error) {
+	words := strings.Fields(value)
+	if len(words) == 0 {
+		return "", nil
+	}
+
+	reset := false
+	attrs := make(map[int]bool)
+	var colors []string
+	for _, word := range words {
+		lower := strings.ToLower(word)
+		if lower == "reset" {
+			reset = true
+			continue
+		}
+		if color, ok := parseColorWord(lower, len(colors) == 1); ok {
+			if len(colors) == 2 {
+				return "", fmt.Errorf("invalid color value: %s", value)
+			}
+			colors = append(colors, color)
+			continue
+		}
+
+		name, negate := word, false
+		if rest, ok := strings.CutPrefix(name, "no"); ok {
+			name, negate = strings.TrimPrefix(rest, "-"), true
+		}
+		codes, ok := colorAttrs[name]
+		if !ok {
+			return "", fmt.Errorf("invalid color value: %s", value)
+		}
+		if negate {
+			attrs[codes[1]] = true
+		} else {
+			attrs[codes[0]] = true
+		}
+	}
+
+	var codes []string
+	if reset {
+		codes = append(codes, "")
+	}
+	for code := 0; code < 30; code++ {
+		if attrs[code] {
+			codes = append(codes, strconv.Itoa(code))
+		}
+	}
+	for _, color := range colors {
+		if color != "" {
+			codes = append(codes, color)
+		}
+	}
+	if len(codes) == 0 {
+		return "", nil
+	}
+	if reset && len(codes) == 1 {
+		return "\x1b[m", nil
+	}
+	return "\x1b[" + strings.Join(codes, ";") + "m", nil
+}
+
+// parseColorWord returns the SGR parameters of a color word, as foreground
+// or as background, and whether it is a color at all. "normal" is a color
+// without parameters.
+func parseColorWord(word string, background bool) (string, bool) {
+	base, bright, extended := 30, 90, "38"
+	if background {
+		base, bright, extended = 40, 100, "48"
+	}
+
+	switch {
+	case word == "normal":
+		return "", true
+	case word == "default":
+		return strconv.Itoa(base + 9), true
+	case strings.HasPrefix(word, "#") && len(word) == 7:
+		rgb, err := strconv.ParseUint(word[1:], 16, 32)
+		if err != nil {
+			return "", false
+		}
+		return fmt.Sprintf("%s;2;%d;%d;%d", extended, rgb>>16, rgb>>8&0xff, rgb&0xff), true
+	}
+	for i, name := range colorNames {
+		if word == name {
+			return strconv.Itoa(base + i), true
+		}
+		if word == "bright"+name {
+			return strconv.Itoa(bright + i), true
+		}
+	}
+	if n, err := strconv.Atoi(word); err == nil && n >= -1 && n < 256 {
+		switch {
+		case n < 0:
+			return "", true
+		case n < 8:
+			return strconv.Itoa(base + n), true
+		case n < 16:
+			return strconv.Itoa(bright + n - 8), true
+		}
+		return fmt.Sprintf("%s;5;%d", extended, n), true
+	}
+	return "", false
+}
+
+var diffColorRe = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// splitDiffByPath splits the output of a multi-file diff into the sections
+// of each destination path. Colored output is split the same way.
+func splitDiffByPath(lines []string) map[string][]string {
+	sections := make(map[string][]string)
+	start := -1
+	path := ""
+
+	for i, line := range lines {
+		plain := diffColorRe.ReplaceAllString(line, "")
+		if !strings.HasPrefix(plain, "diff --git ") {
+			continue
+		}
+		if start >= 0 {
+			sections[path] = lines[start:i:i]
+		}
+		start = i
+		path = diffSectionPath(plain)
+	}
+	if start >= 0 {
+		sections[path] = lines[start:len(lines):len(lines)]
+	}
+	return sections
+}
+
+// diffSectionPath returns the destination path of a "diff --git" line.
+func diffSectionPath(line string) string {
+	rest := strings.TrimPrefix(line, "diff --git ")
+
+	if strings.HasSuffix(rest, `"`) {
+		if ix := strings.LastIndex(rest, ` "b/`); ix != -1 {
+			return strings.TrimPrefix(unquotePath(rest[ix+1:]), "b/")
+		}
+	}
+
+	// Without a rename both sides are the same path, which may contain " b/"
+	if strings.HasPrefix(rest, "a/") && (len(rest)-5)%2 == 0 {
+		n := (len(rest) - 5) / 2
+		if path := rest[2 : 2+n]; rest[2+n:] == " b/"+path {
+			return path
+		}
+	}
+
+	if ix := strings.LastIndex(rest, " b/"); ix != -1 {
+		return rest[ix+3:]
+	}
+	return rest
+}
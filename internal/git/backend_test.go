@@ -0,0 +1,232 @@
+package git
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseConfigList(t *testing.T) {
+	output := "core.bare\nfalse\x00Diff.Algorithm\nhistogram\x00" +
+		"branch.Main.Remote\norigin\x00interactive.singleKey\x00" +
+		"color.diff\nauto\x00color.diff\nnever\x00user.name\nA\nB\x00"
+
+	config := parseConfigList(output)
+	expected := map[string]string{
+		"core.bare":             "false",
+		"diff.algorithm":        "histogram",
+		"branch.Main.remote":    "origin",
+		"interactive.singlekey": "true",
+		"color.diff":            "never",
+		"user.name":             "A\nB",
+	}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("parseConfigList() = %q, expected %q", config, expected)
+	}
+}
+
+func TestCanonicalConfigKey(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"diff.algorithm", "diff.algorithm"},
+		{"Interactive.SingleKey", "interactive.singlekey"},
+		{"Branch.Main.Remote", "branch.Main.remote"},
+		{"url.Https://Example.com/.insteadOf", "url.Https://Example.com/.insteadof"},
+		{"NoDot", "nodot"},
+	}
+
+	for _, test := range tests {
+		if result := canonicalConfigKey(test.input); result != test.expected {
+			t.Errorf("canonicalConfigKey(%q) = %q, expected %q", test.input, result, test.expected)
+		}
+	}
+}
+
+func TestParseConfigBool(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+		valid    bool
+	}{
+		{"true", true, true},
+		{"Yes", true, true},
+		{"on", true, true},
+		{"1", true, true},
+		{"42", true, true},
+		{"false", false, true},
+		{"no", false, true},
+		{"OFF", false, true},
+		{"0", false, true},
+		{"", false, true},
+		{"maybe", false, false},
+	}
+
+	for _, test := range tests {
+		result, err := parseConfigBool(test.input)
+		if result != test.expected || (err == nil) != test.valid {
+			t.Errorf("parseConfigBool(%q) = %v, %v; expected %v, valid %v", test.input, result, err, test.expected, test.valid)
+		}
+	}
+}
+
+func TestParseColorBool(t *testing.T) {
+	t.Setenv("TERM", "xterm")
+	defer func(saved func() bool) { stdoutIsTerminal = saved }(stdoutIsTerminal)
+	stdoutIsTerminal = func() bool { return true }
+
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"always", true},
+		{"never", false},
+		{"auto", true},
+		{"true", true},
+		{"false", false},
+		{"bogus", false},
+	}
+
+	for _, test := range tests {
+		if result := parseColorBool(test.input); result != test.expected {
+			t.Errorf("parseColorBool(%q) = %v, expected %v", test.input, result, test.expected)
+		}
+	}
+
+	t.Setenv("TERM", "dumb")
+	if parseColorBool("auto") {
+		t.Error("Expected no color for auto on a dumb terminal")
+	}
+
+	t.Setenv("TERM", "xterm")
+	stdoutIsTerminal = func() bool { return false }
+	if parseColorBool("auto") {
+		t.Error("Expected no color for auto when output is not a terminal")
+	}
+	if !parseColorBool("always") {
+		t.Error("Expected color for always when output is not a terminal")
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		invalid  bool
+	}{
+		{input: "", expected: ""},
+		{input: "normal", expected: ""},
+		{input: "reset", expected: "\x1b[m"},
+		{input: "RESET", expected: "\x1b[m"},
+		{input: "red", expected: "\x1b[31m"},
+		{input: "Red", expected: "\x1b[31m"},
+		{input: "red bold", expected: "\x1b[1;31m"},
+		{input: "reset red", expected: "\x1b[;31m"},
+		{input: "red blue", expected: "\x1b[31;44m"},
+		{input: "normal red", expected: "\x1b[41m"},
+		{input: "default", expected: "\x1b[39m"},
+		{input: "brightblue brightgreen", expected: "\x1b[94;102m"},
+		{input: "bold ul blink reverse strike dim italic", expected: "\x1b[1;2;3;4;5;7;9m"},
+		{input: "nobold no-ul", expected: "\x1b[22;24m"},
+		{input: "7", expected: "\x1b[37m"},
+		{input: "8", expected: "\x1b[90m"},
+		{input: "255", expected: "\x1b[38;5;255m"},
+		{input: "-1", expected: ""},
+		{input: "#ff8000 #000000", expected: "\x1b[38;2;255;128;0;48;2;0;0;0m"},
+		{input: "red blue green", invalid: true},
+		{input: "Bold", invalid: true},
+		{input: "256", invalid: true},
+		{input: "foo", invalid: true},
+	}
+
+	for _, test := range tests {
+		result, err := parseColor(test.input)
+		if (err != nil) != test.invalid {
+			t.Errorf("parseColor(%q) error = %v, expected invalid %v", test.input, err, test.invalid)
+			continue
+		}
+		if !test.invalid && result != test.expected {
+			t.Errorf("parseColor(%q) = %q, expected %q", test.input, result, test.expected)
+		}
+	}
+}
+
+func TestDiffSectionPath(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"diff --git a/file.txt b/file.txt", "file.txt"},
+		{"diff --git a/dir/with space.txt b/dir/with space.txt", "dir/with space.txt"},
+		{"diff --git a/x b/y b/x b/y", "x b/y"},
+		{"diff --git a/old.txt b/new.txt", "new.txt"},
+		{`diff --git "a/tab\there" "b/tab\there"`, "tab\there"},
+	}
+
+	for _, test := range tests {
+		if result := diffSectionPath(test.input); result != test.expected {
+			t.Errorf("diffSectionPath(%q) = %q, expected %q", test.input, result, test.expected)
+		}
+	}
+}
+
+func TestSplitDiffByPath(t *testing.T) {
+	lines := []string{
+		"diff --git a/a.txt b/a.txt",
+		"index 1111111..2222222 100644",
+		"--- a/a.txt",
+		"+++ b/a.txt",
+		"@@ -1 +1 @@",
+		"-a",
+		"+A",
+		"\x1b[1mdiff --git a/b.txt b/b.txt\x1b[m",
+		"\x1b[1mindex 3333333..4444444 100644\x1b[m",
+		"Binary files a/b.txt and b/b.txt differ",
+	}
+
+	sections := splitDiffByPath(lines)
+	expected := map[string][]string{
+		"a.txt": lines[0:7],
+		"b.txt": lines[7:10],
+	}
+	if !reflect.DeepEqual(sections, expected) {
+		t.Errorf("splitDiffByPath() = %q, expected %q", sections, expected)
+	}
+
+	if sections := splitDiffByPath(nil); len(sections) != 0 {
+		t.Errorf("Expected no sections for an empty diff, got %q", sections)
+	}
+}
+
+func TestPatchPaths(t *testing.T) {
+	patch := "diff --git a/a.txt b/a.txt\n--- a/a.txt\n+++ b/a.txt\n@@ -1 +1 @@\n-a\n+A\n" +
+		"diff --git a/old.txt b/new.txt\nrename from old.txt\nrename to new.txt\n"
+
+	expected := []string{"a.txt", "new.txt"}
+	if paths := patchPaths([]byte(patch)); !reflect.DeepEqual(paths, expected) {
+		t.Errorf("patchPaths() = %q, expected %q", paths, expected)
+	}
+}
+
+func TestCachingBackendInvalidate(t *testing.T) {
+	head := "abc"
+	backend := &cachingBackend{
+		head: &head,
+		diffs: map[string]map[string][]string{
+			"diff-files\x00-p": {"a.txt": {"diff --git a/a.txt b/a.txt"}, "b.txt": {"diff --git a/b.txt b/b.txt"}},
+		},
+	}
+
+	backend.Invalidate("a.txt")
+	if _, ok := backend.diffs["diff-files\x00-p"]["a.txt"]; ok {
+		t.Error("Expected a.txt to be dropped from the batch")
+	}
+	if _, ok := backend.diffs["diff-files\x00-p"]["b.txt"]; !ok {
+		t.Error("Expected b.txt to stay in the batch")
+	}
+
+	backend.Invalidate()
+	if backend.head != nil || backend.diffs != nil {
+		t.Error("Expected HEAD and diffs to be forgotten")
+	}
+}
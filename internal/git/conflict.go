@@ -0,0 +1,260 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultMarkerSize is the length of conflict markers when the
+// conflict-marker-size attribute does not give another.
+const DefaultMarkerSize = 7
+
+// ConflictSegment is either a run of cleanly merged lines or a single
+// conflict region. Lines keep their trailing newlines so that joining the
+// segments reproduces the file byte for byte. HasBase tells whether the
+// region showed the base version, as the diff3 conflict style does. A side
+// that deleted the file has OursDeleted or TheirsDeleted set.
+type ConflictSegment struct {
+	Conflict      bool
+	Lines         []string
+	Ours          []string
+	Base          []string
+	Theirs        []string
+	HasBase       bool
+	OursDeleted   bool
+	TheirsDeleted bool
+}
+
+type ConflictFile struct {
+	Path       string
+	MarkerSize int
+	Segments   []ConflictSegment
+}
+
+// Conflicts returns the number of conflict regions in the file.
+func (f *ConflictFile) Conflicts() int {
+	count := 0
+	for _, segment := range f.Segments {
+		if segment.Conflict {
+			count++
+		}
+	}
+	return count
+}
+
+// ParseConflicts splits the worktree version of an unmerged path into
+// clean and conflicting segments by its conflict markers, as long as its
+// conflict-marker-size attribute sets them. The sides of each region are
+// taken from the markers rather than from the index stages, which only hold
+// whole files: splitting those into regions would mean merging them again
+// and losing the edits already made to the worktree file. When one side
+// deleted the path, the whole file is a single conflict between the stages.
+func (r *Repository) ParseConflicts(path string) (*ConflictFile, error) {
+	stages, err := r.conflictStages(path)
+	if err != nil {
+		return nil, err
+	}
+	size := r.conflictMarkerSize(path)
+	if !stages["2"] || !stages["3"] {
+		file, err := r.deletionConflict(path, stages)
+		if file != nil {
+			file.MarkerSize = size
+		}
+		return file, err
+	}
+
+	content, err := os.ReadFile(filepath.Join(r.workTree, path))
+	if err != nil {
+		return nil, err
+	}
+	segments, err := parseConflictMarkers(strings.SplitAfter(string(content), "\n"), size)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &ConflictFile{Path: path, MarkerSize: size, Segments: segments}, nil
+}
+
+// conflictMarkerSize returns the length of the conflict markers of path, from
+// its conflict-marker-size attribute.
+func (r *Repository) conflictMarkerSize(path string) int {
+	attributes, err := r.checkAttr([]string{path}, []string{"conflict-marker-size"})
+	if err != nil {
+		return DefaultMarkerSize
+	}
+	if size, err := strconv.Atoi(attributes[path]["conflict-marker-size"]); err == nil && size > 0 {
+		return size
+	}
+	return DefaultMarkerSize
+}
+
+// conflictStages returns the stages recorded in the index for an unmerged
+// path.
+func (r *Repository) conflictStages(path string) (map[string]bool, error) {
+	output, err := r.RunCommand("ls-files", "-u", "-z", "--", path)
+	if err != nil {
+		return nil, err
+	}
+
+	stages := make(map[string]bool)
+	for _, entry := range strings.Split(string(output), "\x00") {
+		info, name, ok := strings.Cut(entry, "\t")
+		fields := strings.Fields(info)
+		if ok && name == path && len(fields) == 3 {
+			stages[fields[2]] = true
+		}
+	}
+	return stages, nil
+}
+
+// deletionConflict sets the whole content of the stages of a path that one
+// side deleted against each other. The side that kept the file is read from
+// the worktree, where the merge left it, when it is still there.
+func (r *Repository) deletionConflict(path string, stages map[string]bool) (*ConflictFile, error) {
+	worktree, worktreeErr := os.ReadFile(filepath.Join(r.workTree, path))
+	read := func(stage string) ([]string, error) {
+		if !stages[stage] {
+			return nil, nil
+		}
+		content := worktree
+		if stage == "1" || worktreeErr != nil {
+			var err error
+			if content, err = r.RunCommand("show", ":"+stage+":"+path); err != nil {
+				return nil, err
+			}
+		}
+		var lines []string
+		for _, line := range strings.SplitAfter(string(content), "\n") {
+			if line != "" {
+				lines = append(lines, line)
+			}
+		}
+		return lines, nil
+	}
+
+	segment := ConflictSegment{
+		Conflict:      true,
+		HasBase:       stages["1"],
+		OursDeleted:   !stages["2"],
+		TheirsDeleted: !stages["3"],
+	}
+	var err error
+	if segment.Ours, err = read("2"); err != nil {
+		return nil, err
+	}
+	if segment.Base, err = read("1"); err != nil {
+		return nil, err
+	}
+	if segment.Theirs, err = read("3"); err != nil {
+		return nil, err
+	}
+	return &ConflictFile{Path: path, Segments: []ConflictSegment{segment}}, nil
+}
+
+// IsConflictMarker reports whether line starts, divides or ends a conflict
+// region with markers of the given size.
+func IsConflictMarker(line string, size int) bool {
+	return conflictMarker(line, size) != 0
+}
+
+// conflictMarker returns the character of the conflict marker line is, or 0.
+// Like git, it wants the marker character exactly size times, followed by
+// a space or the end of the line; the divider takes nothing after it.
+func conflictMarker(line string, size int) byte {
+	text := strings.TrimRight(line, "\r\n")
+	if len(text) < size || !strings.ContainsRune("<|=>", rune(text[0])) {
+		return 0
+	}
+	marker := text[0]
+	if text[:size] != strings.Repeat(string(marker), size) {
+		return 0
+	}
+	rest := text[size:]
+	if rest != "" && (marker == '=' || (rest[0] != ' ' && rest[0] != '\t')) {
+		return 0
+	}
+	return marker
+}
+
+// parseConflictMarkers splits lines at the conflict markers of merge and
+// diff3 style conflicts, whatever their labels.
+func parseConflictMarkers(lines []string, size int) ([]ConflictSegment, error) {
+	var segments []ConflictSegment
+	clean := ConflictSegment{}
+	var conflict *ConflictSegment
+	var side *[]string
+
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+
+		marker := conflictMarker(line, size)
+		switch {
+		case conflict == nil && marker == '<':
+			if len(clean.Lines) > 0 {
+				segments = append(segments, clean)
+				clean = ConflictSegment{}
+			}
+			conflict = &ConflictSegment{Conflict: true}
+			side = &conflict.Ours
+		case conflict != nil && marker == '|':
+			conflict.HasBase = true
+			side = &conflict.Base
+		case conflict != nil && marker == '=':
+			side = &conflict.Theirs
+		case conflict != nil && marker == '>':
+			segments = append(segments, *conflict)
+			conflict = nil
+		case conflict == nil && (marker == '|' || marker == '>'):
+			return nil, fmt.Errorf("conflict marker %q does not close a conflict", strings.TrimRight(line, "\r\n"))
+		case conflict != nil:
+			*side = append(*side, line)
+		default:
+			clean.Lines = append(clean.Lines, line)
+		}
+	}
+
+	if conflict != nil {
+		return nil, fmt.Errorf("conflict markers are not closed")
+	}
+	if len(clean.Lines) > 0 {
+		segments = append(segments, clean)
+	}
+
+	return segments, nil
+}
+
+// ResolveConflict writes the resolved content of an unmerged path to the
+// worktree and records it in the index, collapsing the conflict stages.
+func (r *Repository) ResolveConflict(path string, content []byte) error {
+	fullPath := filepath.Join(r.workTree, path)
+
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(fullPath); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	if err := os.WriteFile(fullPath, content, perm); err != nil {
+		return err
+	}
+
+	_, err := r.RunCommand("update-index", "--add", "--", path)
+	r.Backend().Invalidate(path)
+	return err
+}
+
+// RemoveConflict resolves an unmerged path by deleting it from the worktree
+// and the index.
+func (r *Repository) RemoveConflict(path string) error {
+	fullPath := filepath.Join(r.workTree, path)
+	if err := os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	_, err := r.RunCommand("update-index", "--remove", "--", path)
+	r.Backend().Invalidate(path)
+	return err
+}
@@ -0,0 +1,139 @@
+package git
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConflictMarkers(t *testing.T) {
+	merged := "1\n" +
+		"<<<<<<< ours\n" +
+		"two-ours\n" +
+		"||||||| base\n" +
+		"2\n" +
+		"=======\n" +
+		"two-theirs\n" +
+		">>>>>>> theirs\n" +
+		"3\n" +
+		"4\n"
+
+	segments, err := parseConflictMarkers(strings.SplitAfter(merged, "\n"), DefaultMarkerSize)
+	if err != nil {
+		t.Fatalf("parseConflictMarkers() failed: %v", err)
+	}
+	if len(segments) != 3 {
+		t.Fatalf("Expected 3 segments, got %d", len(segments))
+	}
+
+	if segments[0].Conflict || strings.Join(segments[0].Lines, "") != "1\n" {
+		t.Errorf("First segment should be clean line 1, got %+v", segments[0])
+	}
+
+	conflict := segments[1]
+	if !conflict.Conflict || !conflict.HasBase {
+		t.Fatalf("Second segment should be a conflict with a base, got %+v", conflict)
+	}
+	if strings.Join(conflict.Ours, "") != "two-ours\n" {
+		t.Errorf("Ours = %q, expected %q", conflict.Ours, "two-ours\n")
+	}
+	if strings.Join(conflict.Base, "") != "2\n" {
+		t.Errorf("Base = %q, expected %q", conflict.Base, "2\n")
+	}
+	if strings.Join(conflict.Theirs, "") != "two-theirs\n" {
+		t.Errorf("Theirs = %q, expected %q", conflict.Theirs, "two-theirs\n")
+	}
+
+	if segments[2].Conflict || strings.Join(segments[2].Lines, "") != "3\n4\n" {
+		t.Errorf("Last segment should be clean lines 3 and 4, got %+v", segments[2])
+	}
+
+	file := &ConflictFile{Segments: segments}
+	if file.Conflicts() != 1 {
+		t.Errorf("Expected 1 conflict, got %d", file.Conflicts())
+	}
+}
+
+func TestParseConflictMarkersNoConflicts(t *testing.T) {
+	segments, err := parseConflictMarkers(strings.SplitAfter("a\nb\n", "\n"), DefaultMarkerSize)
+	if err != nil {
+		t.Fatalf("parseConflictMarkers() failed: %v", err)
+	}
+	if len(segments) != 1 || segments[0].Conflict {
+		t.Fatalf("Expected a single clean segment, got %+v", segments)
+	}
+	if len(segments[0].Lines) != 2 {
+		t.Errorf("Expected 2 lines, got %d", len(segments[0].Lines))
+	}
+}
+
+func TestParseConflictMarkersMergeStyle(t *testing.T) {
+	merged := "<<<<<<< HEAD\nmine\n=======\nyours\n>>>>>>> topic\n"
+
+	segments, err := parseConflictMarkers(strings.SplitAfter(merged, "\n"), DefaultMarkerSize)
+	if err != nil {
+		t.Fatalf("parseConflictMarkers() failed: %v", err)
+	}
+	if len(segments) != 1 || !segments[0].Conflict || segments[0].HasBase {
+		t.Fatalf("Expected a single conflict without a base, got %+v", segments)
+	}
+	if strings.Join(segments[0].Ours, "") != "mine\n" || strings.Join(segments[0].Theirs, "") != "yours\n" {
+		t.Errorf("Unexpected sides %+v", segments[0])
+	}
+
+	if _, err := parseConflictMarkers(strings.SplitAfter("<<<<<<< HEAD\nmine\n=======\n", "\n"), DefaultMarkerSize); err == nil {
+		t.Error("Expected an error for unclosed conflict markers")
+	}
+	if _, err := parseConflictMarkers(strings.SplitAfter("mine\n=======\nyours\n>>>>>>> topic\n", "\n"), DefaultMarkerSize); err == nil {
+		t.Error("Expected an error for a conflict that was never opened")
+	}
+}
+
+func TestParseConflictMarkersCRLF(t *testing.T) {
+	merged := "a\r\n<<<<<<< HEAD\r\nmine\r\n=======\r\nyours\r\n>>>>>>> topic\r\n"
+
+	segments, err := parseConflictMarkers(strings.SplitAfter(merged, "\n"), DefaultMarkerSize)
+	if err != nil {
+		t.Fatalf("parseConflictMarkers() failed: %v", err)
+	}
+	if len(segments) != 2 || !segments[1].Conflict {
+		t.Fatalf("Expected a clean segment and a conflict, got %+v", segments)
+	}
+	if !reflect.DeepEqual(segments[1].Ours, []string{"mine\r\n"}) || !reflect.DeepEqual(segments[1].Theirs, []string{"yours\r\n"}) {
+		t.Errorf("Unexpected sides %+v", segments[1])
+	}
+}
+
+func TestConflictMarkerSize(t *testing.T) {
+	tests := []struct {
+		line     string
+		size     int
+		expected byte
+	}{
+		{"<<<<<<< HEAD\n", 7, '<'},
+		{"<<<<<<<\n", 7, '<'},
+		{"<<<<<<<< HEAD\n", 7, 0},
+		{"<<<<<<<<<< HEAD\n", 10, '<'},
+		{"<<<<<<< HEAD\n", 10, 0},
+		{"==========\r\n", 10, '='},
+		{"======= x\n", 7, 0},
+		{">>>>>>>\ttopic\n", 7, '>'},
+		{"a\n", 7, 0},
+	}
+
+	for _, test := range tests {
+		if result := conflictMarker(test.line, test.size); result != test.expected {
+			t.Errorf("conflictMarker(%q, %d) = %q, expected %q", test.line, test.size, result, test.expected)
+		}
+	}
+
+	// Shorter markers are content when the attribute asks for longer ones
+	merged := "<<<<<<<<<< ours\n=======\n==========\ntheirs\n>>>>>>>>>> theirs\n"
+	segments, err := parseConflictMarkers(strings.SplitAfter(merged, "\n"), 10)
+	if err != nil {
+		t.Fatalf("parseConflictMarkers() failed: %v", err)
+	}
+	if len(segments) != 1 || !reflect.DeepEqual(segments[0].Ours, []string{"=======\n"}) {
+		t.Errorf("Unexpected segments %+v", segments)
+	}
+}
@@ -0,0 +1,142 @@
+package git
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// ignoreRule is one pattern of a .gitignore style file.
+type ignoreRule struct {
+	base     string
+	re       *regexp.Regexp
+	negate   bool
+	dirOnly  bool
+	basename bool
+}
+
+// parseIgnoreFile parses the patterns of an exclude file whose patterns are
+// relative to the directory base ("" for the top of the worktree).
+func parseIgnoreFile(data []byte, base string) []ignoreRule {
+	var rules []ignoreRule
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		line = trimIgnoreSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := ignoreRule{base: base}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+
+		rule.basename = !strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+
+		re, err := regexp.Compile("^" + globToRegexp(line, true) + "$")
+		if err != nil {
+			continue
+		}
+		rule.re = re
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// trimIgnoreSpace drops trailing spaces unless they are escaped.
+func trimIgnoreSpace(line string) string {
+	end := len(line)
+	for end > 0 && line[end-1] == ' ' {
+		if end > 1 && line[end-2] == '\\' {
+			return line[:end-2] + " "
+		}
+		end--
+	}
+	return line[:end]
+}
+
+// globToRegexp translates a wildmatch pattern. With pathname, "*" and "?"
+// stop at slashes and "**" spans directories; without it they match slashes
+// too, as in pathspecs without glob magic.
+func globToRegexp(pattern string, pathname bool) string {
+	var re strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case !pathname && c == '*':
+			re.WriteString(".*")
+		case !pathname && c == '?':
+			re.WriteString(".")
+		case strings.HasPrefix(pattern[i:], "**/") && (i == 0 || pattern[i-1] == '/'):
+			re.WriteString("(?:.*/)?")
+			i += 2
+		case pattern[i:] == "**" && i > 0 && pattern[i-1] == '/':
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '[':
+			end := strings.Index(pattern[i+1:], "]")
+			if end == -1 {
+				re.WriteString(`\[`)
+				continue
+			}
+			// A "]" right after the opening bracket is part of the class
+			if end == 0 && len(pattern) > i+2 {
+				if next := strings.Index(pattern[i+2:], "]"); next != -1 {
+					end = next + 1
+				}
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return re.String()
+}
+
+// isIgnored applies the rules in order of increasing precedence; the last
+// rule that matches decides.
+func isIgnored(rules []ignoreRule, name string, isDir bool) bool {
+	ignored := false
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		rel := name
+		if rule.base != "" {
+			if !strings.HasPrefix(name, rule.base+"/") {
+				continue
+			}
+			rel = name[len(rule.base)+1:]
+		}
+		if rule.basename {
+			rel = path.Base(rel)
+		}
+		if rule.re.MatchString(rel) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
@@ -0,0 +1,74 @@
+package git
+
+import "testing"
+
+func TestIsIgnored(t *testing.T) {
+	rules := parseIgnoreFile([]byte(`# comment
+*.log
+!keep.log
+/build/
+docs/*.html
+**/tmp
+a/**/z
+\#hash
+trailing\ 
+cache/
+`), "")
+	rules = append(rules, parseIgnoreFile([]byte("*.o\n"), "sub")...)
+
+	tests := []struct {
+		path     string
+		isDir    bool
+		expected bool
+	}{
+		{"x.log", false, true},
+		{"deep/dir/x.log", false, true},
+		{"keep.log", false, false},
+		{"build", true, true},
+		{"sub/build", true, false},
+		{"build", false, false},
+		{"docs/a.html", false, true},
+		{"docs/deep/a.html", false, false},
+		{"tmp", true, true},
+		{"x/y/tmp", false, true},
+		{"a/z", false, true},
+		{"a/b/c/z", false, true},
+		{"#hash", false, true},
+		{"trailing ", false, true},
+		{"cache", true, true},
+		{"cache", false, false},
+		{"sub/x.o", false, true},
+		{"x.o", false, false},
+		{"comment", false, false},
+	}
+
+	for _, test := range tests {
+		if result := isIgnored(rules, test.path, test.isDir); result != test.expected {
+			t.Errorf("isIgnored(%q, %v) = %v, expected %v", test.path, test.isDir, result, test.expected)
+		}
+	}
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		pathname bool
+		expected string
+	}{
+		{"*.go", true, `[^/]*\.go`},
+		{"a?c", true, `a[^/]c`},
+		{"[!ab]x", true, `[^ab]x`},
+		{"**/x", true, `(?:.*/)?x`},
+		{"a/**", true, `a/.*`},
+		{"a/**/b", true, `a/(?:.*/)?b`},
+		{`\*`, true, `\*`},
+		{"*.go", false, `.*\.go`},
+		{"a/**/b?", false, `a/.*.*/b.`},
+	}
+
+	for _, test := range tests {
+		if result := globToRegexp(test.pattern, test.pathname); result != test.expected {
+			t.Errorf("globToRegexp(%q, %v) = %q, expected %q", test.pattern, test.pathname, result, test.expected)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package git
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+// indexEntry is one path of the index file.
+type indexEntry struct {
+	Path        string
+	Mode        uint32
+	Hash        string
+	Size        uint32
+	MtimeSec    uint32
+	MtimeNsec   uint32
+	Stage       int
+	IntentToAdd bool
+}
+
+const (
+	indexFlagExtended    = 0x4000
+	indexFlagStageMask   = 0x3000
+	indexFlagStageShift  = 12
+	indexFlagNameMask    = 0x0fff
+	indexExtIntentToAdd  = 0x2000
+	indexEntryHeaderSize = 62
+)
+
+// parseIndex reads the entries of an index file of version 2, 3 or 4. Split
+// and sparse indexes are refused, since their entries are incomplete.
+func parseIndex(data []byte) ([]indexEntry, error) {
+	if len(data) < 12 || string(data[:4]) != "DIRC" {
+		return nil, fmt.Errorf("not an index file")
+	}
+	version := binary.BigEndian.Uint32(data[4:8])
+	if version < 2 || version > 4 {
+		return nil, fmt.Errorf("unsupported index version %d", version)
+	}
+	count := binary.BigEndian.Uint32(data[8:12])
+
+	entries := make([]indexEntry, 0, count)
+	pos := 12
+	previous := ""
+	for i := uint32(0); i < count; i++ {
+		if pos+indexEntryHeaderSize > len(data) {
+			return nil, fmt.Errorf("index is truncated")
+		}
+		fields := data[pos : pos+indexEntryHeaderSize]
+		flags := binary.BigEndian.Uint16(fields[60:62])
+		entry := indexEntry{
+			MtimeSec:  binary.BigEndian.Uint32(fields[8:12]),
+			MtimeNsec: binary.BigEndian.Uint32(fields[12:16]),
+			Mode:      binary.BigEndian.Uint32(fields[24:28]),
+			Size:      binary.BigEndian.Uint32(fields[36:40]),
+			Hash:      hex.EncodeToString(fields[40:60]),
+			Stage:     int(flags&indexFlagStageMask) >> indexFlagStageShift,
+		}
+		start := pos
+		pos += indexEntryHeaderSize
+
+		if flags&indexFlagExtended != 0 {
+			if version < 3 || pos+2 > len(data) {
+				return nil, fmt.Errorf("bad extended flags in index")
+			}
+			entry.IntentToAdd = binary.BigEndian.Uint16(data[pos:pos+2])&indexExtIntentToAdd != 0
+			pos += 2
+		}
+
+		if version == 4 {
+			strip, n := readOffsetVarint(data[pos:])
+			if n == 0 || strip > len(previous) {
+				return nil, fmt.Errorf("bad path compression in index")
+			}
+			pos += n
+			end := bytes.IndexByte(data[pos:], 0)
+			if end == -1 {
+				return nil, fmt.Errorf("index is truncated")
+			}
+			entry.Path = previous[:len(previous)-strip] + string(data[pos:pos+end])
+			pos += end + 1
+		} else {
+			end := bytes.IndexByte(data[pos:], 0)
+			if end == -1 {
+				return nil, fmt.Errorf("index is truncated")
+			}
+			entry.Path = string(data[pos : pos+end])
+			// Entries are padded with NULs to a multiple of eight bytes
+			pos = start + (pos-start+end+8)&^7
+		}
+
+		if entry.Mode&0170000 == 0040000 {
+			return nil, fmt.Errorf("sparse indexes are not supported")
+		}
+		previous = entry.Path
+		entries = append(entries, entry)
+	}
+
+	for pos+8 <= len(data)-20 {
+		signature := string(data[pos : pos+4])
+		size := int(binary.BigEndian.Uint32(data[pos+4 : pos+8]))
+		if signature == "link" {
+			return nil, fmt.Errorf("split indexes are not supported")
+		}
+		pos += 8 + size
+	}
+
+	return entries, nil
+}
+
+// readOffsetVarint decodes the variable length integer used for delta base
+// offsets in packs and for path compression in index version 4. It returns
+// the value and the number of bytes read, or zero bytes on bad input.
+func readOffsetVarint(data []byte) (int, int) {
+	if len(data) == 0 {
+		return 0, 0
+	}
+	c := data[0]
+	value := int(c & 0x7f)
+	n := 1
+	for c&0x80 != 0 {
+		if n >= len(data) {
+			return 0, 0
+		}
+		c = data[n]
+		n++
+		value = ((value + 1) << 7) | int(c&0x7f)
+	}
+	return value, n
+}
@@ -0,0 +1,117 @@
+package git
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestParseIndex(t *testing.T) {
+	hash := blobHash([]byte("x\n"))
+	data := buildIndex([]indexEntry{
+		{Path: "a.txt", Mode: 0100644, Hash: hash, Size: 2, MtimeSec: 7, MtimeNsec: 9},
+		{Path: "dir/b", Mode: 0100755, Hash: hash, IntentToAdd: true},
+		{Path: "dir/c", Mode: 0100644, Hash: hash, Stage: 3},
+	})
+
+	entries, err := parseIndex(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+
+	expected := indexEntry{Path: "a.txt", Mode: 0100644, Hash: hash, Size: 2, MtimeSec: 7, MtimeNsec: 9}
+	if entries[0] != expected {
+		t.Errorf("entries[0] = %+v, expected %+v", entries[0], expected)
+	}
+	if !entries[1].IntentToAdd || entries[1].Path != "dir/b" || entries[1].Mode != 0100755 {
+		t.Errorf("entries[1] = %+v, expected intent-to-add dir/b", entries[1])
+	}
+	if entries[2].Stage != 3 {
+		t.Errorf("entries[2].Stage = %d, expected 3", entries[2].Stage)
+	}
+}
+
+func TestParseIndexVersion4(t *testing.T) {
+	var data []byte
+	data = append(data, "DIRC"...)
+	data = binary.BigEndian.AppendUint32(data, 4)
+	data = binary.BigEndian.AppendUint32(data, 2)
+	for _, entry := range []struct {
+		strip  byte
+		suffix string
+		length int
+	}{
+		{0, "dir/file1", 9},
+		{1, "2", 9},
+	} {
+		fields := make([]byte, 40)
+		binary.BigEndian.PutUint32(fields[24:], 0100644)
+		data = append(data, fields...)
+		data = append(data, make([]byte, 20)...)
+		data = binary.BigEndian.AppendUint16(data, uint16(entry.length))
+		data = append(data, entry.strip)
+		data = append(data, entry.suffix...)
+		data = append(data, 0)
+	}
+	data = append(data, make([]byte, 20)...)
+
+	entries, err := parseIndex(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Path != "dir/file1" || entries[1].Path != "dir/file2" {
+		t.Errorf("Expected dir/file1 and dir/file2, got %+v", entries)
+	}
+	if entries[0].Hash != nullHash {
+		t.Errorf("Hash = %s, expected %s", entries[0].Hash, nullHash)
+	}
+}
+
+func TestParseIndexErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"bad signature", []byte("DIRX\x00\x00\x00\x02\x00\x00\x00\x00")},
+		{"bad version", []byte("DIRC\x00\x00\x00\x05\x00\x00\x00\x00")},
+		{"truncated", []byte("DIRC\x00\x00\x00\x02\x00\x00\x00\x01")},
+	}
+
+	for _, test := range tests {
+		if _, err := parseIndex(test.data); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+
+	split := buildIndex(nil)
+	split = append(split[:len(split)-20], "link\x00\x00\x00\x00"...)
+	split = append(split, make([]byte, 20)...)
+	if _, err := parseIndex(split); err == nil {
+		t.Error("Expected split indexes to be refused")
+	}
+}
+
+func TestReadOffsetVarint(t *testing.T) {
+	tests := []struct {
+		input    []byte
+		expected int
+		n        int
+	}{
+		{[]byte{0x05}, 5, 1},
+		{[]byte{0x7f}, 127, 1},
+		{[]byte{0x80, 0x00}, 128, 2},
+		{[]byte{0x81, 0x01}, 257, 2},
+		{[]byte{0x80}, 0, 0},
+		{nil, 0, 0},
+	}
+
+	for _, test := range tests {
+		value, n := readOffsetVarint(test.input)
+		if value != test.expected || n != test.n {
+			t.Errorf("readOffsetVarint(%v) = %d, %d; expected %d, %d", test.input, value, n, test.expected, test.n)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrUnmergedIndex is returned by Record while the index has unmerged
+// entries, which cannot be written as a tree.
+var ErrUnmergedIndex = errors.New("the index has unmerged entries")
+
+// JournalEntry is the state of the index at one point of a session. Paths
+// added with intent-to-add are kept apart, since trees cannot hold them.
+type JournalEntry struct {
+	Tree        string
+	IntentToAdd []string
+	Description string
+	Time        time.Time
+}
+
+func (e JournalEntry) String() string {
+	return fmt.Sprintf("%s %s (%s)", e.Time.Format("15:04:05"), e.Description, shortHash(e.Tree))
+}
+
+// Journal records the index as a tree before each change made during a
+// session so that the index can be rolled back to any of those points.
+type Journal struct {
+	repo    *Repository
+	Entries []JournalEntry
+}
+
+func (r *Repository) NewJournal() *Journal {
+	return &Journal{repo: r}
+}
+
+// Record writes the current index as a tree and adds it to the journal. An
+// index with unmerged entries is not recorded and ErrUnmergedIndex returned.
+func (j *Journal) Record(description string) error {
+	unmerged, intentToAdd, err := j.repo.indexState()
+	if err != nil {
+		return err
+	}
+	if unmerged {
+		return ErrUnmergedIndex
+	}
+
+	tree, err := j.repo.WriteTree()
+	if err != nil {
+		return err
+	}
+
+	j.Entries = append(j.Entries, JournalEntry{
+		Tree:        tree,
+		IntentToAdd: intentToAdd,
+		Description: description,
+		Time:        time.Now(),
+	})
+	return nil
+}
+
+// Rollback restores the index recorded by entry ix. The index it replaces is
+// recorded first, so a rollback can itself be rolled back.
+func (j *Journal) Rollback(ix int) error {
+	if ix < 0 || ix >= len(j.Entries) {
+		return fmt.Errorf("no journal entry %d", ix+1)
+	}
+
+	entry := j.Entries[ix]
+	if err := j.Record("before rolling back to " + entry.Time.Format("15:04:05")); err != nil {
+		return fmt.Errorf("cannot roll back: %w", err)
+	}
+
+	if err := j.repo.ReadTree(entry.Tree); err != nil {
+		return err
+	}
+	return j.repo.addIntentToAdd(entry.IntentToAdd)
+}
+
+// indexState reports whether the index has unmerged entries and lists the
+// paths added with intent-to-add. An index that parseIndex cannot read is
+// asked about with "ls-files -u", which cannot tell intent-to-add entries.
+func (r *Repository) indexState() (bool, []string, error) {
+	data, err := os.ReadFile(r.RepoPath("index"))
+	if os.IsNotExist(err) {
+		return false, nil, nil
+	}
+	if err == nil {
+		if entries, err := parseIndex(data); err == nil {
+			unmerged := false
+			var intentToAdd []string
+			for _, entry := range entries {
+				unmerged = unmerged || entry.Stage != 0
+				if entry.IntentToAdd {
+					intentToAdd = append(intentToAdd, entry.Path)
+				}
+			}
+			return unmerged, intentToAdd, nil
+		}
+	}
+
+	output, err := r.RunCommand("ls-files", "-u", "-z")
+	if err != nil {
+		return false, nil, err
+	}
+	return len(output) > 0, nil, nil
+}
+
+// addIntentToAdd adds the paths that are still in the worktree to the index
+// with intent-to-add, as "git add -N" does.
+func (r *Repository) addIntentToAdd(paths []string) error {
+	args := []string{"add", "-N", "-f", "--"}
+	for _, path := range paths {
+		if _, err := os.Lstat(filepath.Join(r.workTree, path)); err == nil {
+			args = append(args, path)
+		}
+	}
+	if len(args) == 4 {
+		return nil
+	}
+	if _, err := r.RunCommand(args...); err != nil {
+		return err
+	}
+	r.Backend().Invalidate()
+	return nil
+}
+
+// WriteTree writes the index as a tree object and returns its name. It fails
+// while the index has unmerged entries.
+func (r *Repository) WriteTree() (string, error) {
+	output, err := r.RunCommand("write-tree")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// ReadTree replaces the index with the given tree, leaving the worktree alone.
+func (r *Repository) ReadTree(tree string) error {
+	if _, err := r.RunCommand("read-tree", tree); err != nil {
+		return err
+	}
+	r.Backend().Invalidate()
+	return r.UpdateIndex()
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 7 {
+		return hash[:7]
+	}
+	return hash
+}
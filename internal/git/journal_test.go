@@ -0,0 +1,110 @@
+package git
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJournalEntryString(t *testing.T) {
+	entry := JournalEntry{
+		Tree:        "4b825dc642cb6eb9a060e54bf8d69288fbee4904",
+		Description: "before stage of f",
+		Time:        time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC),
+	}
+
+	expected := "15:04:05 before stage of f (4b825dc)"
+	if entry.String() != expected {
+		t.Errorf("String() = %q, expected %q", entry.String(), expected)
+	}
+}
+
+func TestJournalRollbackOutOfRange(t *testing.T) {
+	journal := (&Repository{}).NewJournal()
+
+	if err := journal.Rollback(0); err == nil {
+		t.Error("Expected an error rolling back an empty journal")
+	}
+	if len(journal.Entries) != 0 {
+		t.Errorf("Expected no entries, got %d", len(journal.Entries))
+	}
+}
+
+func TestPatchModeUpdatesIndex(t *testing.T) {
+	tests := map[string]bool{
+		"stage":          true,
+		"reset_head":     true,
+		"checkout_index": false,
+		"worktree_head":  false,
+	}
+
+	for name, expected := range tests {
+		if result := PatchModes[name].UpdatesIndex(); result != expected {
+			t.Errorf("PatchModes[%q].UpdatesIndex() = %v, expected %v", name, result, expected)
+		}
+	}
+}
+
+func TestJournalIntentToAdd(t *testing.T) {
+	dir, git := newScratchRepository(t)
+	for name, content := range map[string]string{"f": "a\n", "new": "n\n"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("add", "f")
+	git("commit", "-q", "-m", "init")
+	git("add", "-N", "new")
+
+	repo, err := NewRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal := repo.NewJournal()
+	if err := journal.Record("before staging"); err != nil {
+		t.Fatalf("Record() failed: %v", err)
+	}
+	git("add", "new")
+
+	if err := journal.Rollback(0); err != nil {
+		t.Fatalf("Rollback() failed: %v", err)
+	}
+	if staged := git("diff", "--cached", "--name-only"); staged != "" {
+		t.Errorf("Expected nothing staged after the rollback, got %q", staged)
+	}
+	if changed := git("diff", "--name-status"); strings.TrimSpace(changed) != "A\tnew" {
+		t.Errorf("Expected new to be intent-to-add again, got %q", changed)
+	}
+}
+
+func TestJournalUnmergedIndex(t *testing.T) {
+	dir, git := newScratchRepository(t)
+	if err := os.WriteFile(filepath.Join(dir, "f"), []byte("a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git("add", "f")
+	git("commit", "-q", "-m", "init")
+	blob := strings.TrimSpace(git("rev-parse", "HEAD:f"))
+	cmd := exec.Command("git", "update-index", "--index-info")
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader("0 " + nullHash + "\tf\n100644 " + blob + " 2\tf\n100644 " + blob + " 3\tf\n")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("update-index failed: %v\n%s", err, output)
+	}
+
+	repo, err := NewRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal := repo.NewJournal()
+	if err := journal.Record("before staging"); !errors.Is(err, ErrUnmergedIndex) {
+		t.Errorf("Record() = %v, expected ErrUnmergedIndex", err)
+	}
+	if len(journal.Entries) != 0 {
+		t.Errorf("Expected no entries, got %d", len(journal.Entries))
+	}
+}
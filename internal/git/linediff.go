@@ -0,0 +1,345 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// diffOp is one line of an edit script: ' ' keeps line A of the old side,
+// which is line B of the new side, '-' removes line A and '+' adds line B.
+type diffOp struct {
+	Kind byte
+	A    int
+	B    int
+}
+
+// myersDiff computes a shortest edit script between two lists of lines with
+// the linear space variant of Myers' algorithm. Removals come before the
+// additions they are replaced with.
+func myersDiff(a, b []string) []diffOp {
+	ids := make(map[string]int)
+	intern := func(lines []string) []int {
+		result := make([]int, len(lines))
+		for i, line := range lines {
+			id, ok := ids[line]
+			if !ok {
+				id = len(ids)
+				ids[line] = id
+			}
+			result[i] = id
+		}
+		return result
+	}
+
+	d := &myersDiffer{
+		a:       intern(a),
+		b:       intern(b),
+		removed: make([]bool, len(a)),
+		added:   make([]bool, len(b)),
+	}
+	d.compare(0, len(a), 0, len(b))
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && d.removed[i]:
+			ops = append(ops, diffOp{Kind: '-', A: i, B: j})
+			i++
+		case j < len(b) && d.added[j]:
+			ops = append(ops, diffOp{Kind: '+', A: i, B: j})
+			j++
+		default:
+			ops = append(ops, diffOp{Kind: ' ', A: i, B: j})
+			i++
+			j++
+		}
+	}
+	return ops
+}
+
+type myersDiffer struct {
+	a, b    []int
+	removed []bool
+	added   []bool
+}
+
+func (d *myersDiffer) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		aLo++
+		bLo++
+	}
+	for aLo < aHi && bLo < bHi && d.a[aHi-1] == d.b[bHi-1] {
+		aHi--
+		bHi--
+	}
+
+	switch {
+	case aLo == aHi:
+		for j := bLo; j < bHi; j++ {
+			d.added[j] = true
+		}
+	case bLo == bHi:
+		for i := aLo; i < aHi; i++ {
+			d.removed[i] = true
+		}
+	default:
+		x, y, ok := d.middleSnake(aLo, aHi, bLo, bHi)
+		if !ok {
+			for i := aLo; i < aHi; i++ {
+				d.removed[i] = true
+			}
+			for j := bLo; j < bHi; j++ {
+				d.added[j] = true
+			}
+			return
+		}
+		d.compare(aLo, x, bLo, y)
+		d.compare(x, aHi, y, bHi)
+	}
+}
+
+// middleSnake searches from both ends at once for the point where the
+// forward and backward paths overlap, which splits the problem in two.
+func (d *myersDiffer) middleSnake(aLo, aHi, bLo, bHi int) (int, int, bool) {
+	n, m := aHi-aLo, bHi-bLo
+	maxD := (n + m + 1) / 2
+	offset := maxD
+	forward := make([]int, 2*maxD+2)
+	backward := make([]int, 2*maxD+2)
+	for i := range forward {
+		forward[i] = -1
+		backward[i] = -1
+	}
+	forward[offset+1] = 0
+	backward[offset+1] = 0
+
+	delta := n - m
+	front := delta%2 != 0
+	var k1start, k1end, k2start, k2end int
+
+	for step := 0; step < maxD; step++ {
+		for k1 := -step + k1start; k1 <= step-k1end; k1 += 2 {
+			k1Offset := offset + k1
+			var x1 int
+			if k1 == -step || (k1 != step && forward[k1Offset-1] < forward[k1Offset+1]) {
+				x1 = forward[k1Offset+1]
+			} else {
+				x1 = forward[k1Offset-1] + 1
+			}
+			y1 := x1 - k1
+			for x1 < n && y1 < m && d.a[aLo+x1] == d.b[bLo+y1] {
+				x1++
+				y1++
+			}
+			forward[k1Offset] = x1
+
+			switch {
+			case x1 > n:
+				k1end += 2
+			case y1 > m:
+				k1start += 2
+			case front:
+				k2Offset := offset + delta - k1
+				if k2Offset >= 0 && k2Offset < len(backward) && backward[k2Offset] != -1 {
+					if x1 >= n-backward[k2Offset] {
+						return aLo + x1, bLo + y1, true
+					}
+				}
+			}
+		}
+
+		for k2 := -step + k2start; k2 <= step-k2end; k2 += 2 {
+			k2Offset := offset + k2
+			var x2 int
+			if k2 == -step || (k2 != step && backward[k2Offset-1] < backward[k2Offset+1]) {
+				x2 = backward[k2Offset+1]
+			} else {
+				x2 = backward[k2Offset-1] + 1
+			}
+			y2 := x2 - k2
+			for x2 < n && y2 < m && d.a[aHi-x2-1] == d.b[bHi-y2-1] {
+				x2++
+				y2++
+			}
+			backward[k2Offset] = x2
+
+			switch {
+			case x2 > n:
+				k2end += 2
+			case y2 > m:
+				k2start += 2
+			case !front:
+				k1Offset := offset + delta - k2
+				if k1Offset >= 0 && k1Offset < len(forward) && forward[k1Offset] != -1 {
+					x1 := forward[k1Offset]
+					y1 := offset + x1 - k1Offset
+					if x1 >= n-x2 {
+						return aLo + x1, bLo + y1, true
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// splitContentLines splits file content into lines that keep their "\n",
+// so a last line without one differs from the same line with one.
+func splitContentLines(content []byte) []string {
+	var lines []string
+	for len(content) > 0 {
+		end := bytes.IndexByte(content, '\n')
+		if end == -1 {
+			lines = append(lines, string(content))
+			break
+		}
+		lines = append(lines, string(content[:end+1]))
+		content = content[end+1:]
+	}
+	return lines
+}
+
+// isBinaryContent uses git's heuristic: a NUL in the first 8000 bytes.
+func isBinaryContent(content []byte) bool {
+	if len(content) > 8000 {
+		content = content[:8000]
+	}
+	return bytes.IndexByte(content, 0) != -1
+}
+
+// unifiedHunks formats the differences between two versions of a file as
+// unified diff hunks with the given lines of context, as git does: counts
+// of one are left out, empty ranges start at the line before, and the hunk
+// header names the closest preceding line that looks like a function.
+// It also returns the number of added and removed lines.
+func unifiedHunks(oldContent, newContent []byte, context int) ([]string, int, int) {
+	a := splitContentLines(oldContent)
+	b := splitContentLines(newContent)
+	ops := myersDiff(a, b)
+
+	var lines []string
+	added, removed := 0, 0
+	for _, op := range ops {
+		switch op.Kind {
+		case '+':
+			added++
+		case '-':
+			removed++
+		}
+	}
+
+	for _, span := range hunkSpans(ops, context) {
+		first := ops[span[0]]
+		oldCnt, newCnt := 0, 0
+		for _, op := range ops[span[0]:span[1]] {
+			if op.Kind != '+' {
+				oldCnt++
+			}
+			if op.Kind != '-' {
+				newCnt++
+			}
+		}
+
+		header := fmt.Sprintf("@@ -%s +%s @@", hunkRange(first.A, oldCnt), hunkRange(first.B, newCnt))
+		if name := funcName(a, first.A); name != "" {
+			header += " " + name
+		}
+		lines = append(lines, header)
+
+		for _, op := range ops[span[0]:span[1]] {
+			line := ""
+			switch op.Kind {
+			case '+':
+				line = b[op.B]
+			default:
+				line = a[op.A]
+			}
+			lines = append(lines, string(op.Kind)+trimLineEnd(line))
+			if !strings.HasSuffix(line, "\n") {
+				lines = append(lines, `\ No newline at end of file`)
+			}
+		}
+	}
+	return lines, added, removed
+}
+
+// hunkSpans groups the changes of an edit script into hunks, returned as
+// ranges of ops. Changes that are at most twice the context apart share a
+// hunk.
+func hunkSpans(ops []diffOp, context int) [][2]int {
+	var spans [][2]int
+	i := 0
+	for i < len(ops) {
+		if ops[i].Kind == ' ' {
+			i++
+			continue
+		}
+
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for {
+			for end < len(ops) && ops[end].Kind != ' ' {
+				end++
+			}
+			next := end
+			for next < len(ops) && ops[next].Kind == ' ' {
+				next++
+			}
+			if next < len(ops) && next-end <= 2*context {
+				end = next
+				continue
+			}
+			i = next
+			break
+		}
+
+		if end += context; end > len(ops) {
+			end = len(ops)
+		}
+		spans = append(spans, [2]int{start, end})
+	}
+	return spans
+}
+
+// hunkRange formats one side of a hunk header from the 0-based line the
+// hunk starts at.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// funcName finds the function context of a hunk the way git's default
+// does: the closest line before the hunk that starts with a letter, "_" or
+// "$", cut to 80 bytes.
+func funcName(lines []string, start int) string {
+	for i := start - 1; i >= 0; i-- {
+		line := trimLineEnd(lines[i])
+		if line == "" {
+			continue
+		}
+		c := line[0]
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' || c == '$' {
+			if len(line) > 80 {
+				line = line[:80]
+			}
+			return strings.TrimRight(line, " \t\r\n\v\f")
+		}
+	}
+	return ""
+}
+
+// trimLineEnd drops the line ending the way lines read from git's output
+// lose it.
+func trimLineEnd(line string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+}
@@ -0,0 +1,144 @@
+package git
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMyersDiff(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		edits int
+	}{
+		{"abc", "abc", 0},
+		{"", "ab", 2},
+		{"ab", "", 2},
+		{"abcabba", "cbabac", 5},
+		{"axb", "ayb", 2},
+		{"abcdefgh", "xbcdyfgz", 6},
+	}
+
+	for _, test := range tests {
+		a := strings.Split(test.a, "")
+		b := strings.Split(test.b, "")
+		if test.a == "" {
+			a = nil
+		}
+		if test.b == "" {
+			b = nil
+		}
+
+		// The script must turn a into b with no more edits than needed
+		var result []string
+		edits := 0
+		for _, op := range myersDiff(a, b) {
+			switch op.Kind {
+			case ' ':
+				if a[op.A] != b[op.B] {
+					t.Errorf("myersDiff(%q, %q) keeps %q as %q", test.a, test.b, a[op.A], b[op.B])
+				}
+				result = append(result, a[op.A])
+			case '+':
+				result = append(result, b[op.B])
+				edits++
+			case '-':
+				edits++
+			}
+		}
+		if strings.Join(result, "") != test.b || edits != test.edits {
+			t.Errorf("myersDiff(%q, %q) gives %q with %d edits, expected %d edits", test.a, test.b, strings.Join(result, ""), edits, test.edits)
+		}
+	}
+}
+
+func TestUnifiedHunks(t *testing.T) {
+	old := "int main\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\n"
+	new := "int main\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nL\nm\n"
+
+	lines, added, removed := unifiedHunks([]byte(old), []byte(new), 3)
+	expected := []string{
+		"@@ -1,5 +1,5 @@",
+		" int main",
+		"-b",
+		"+B",
+		" c",
+		" d",
+		" e",
+		"@@ -9,5 +9,5 @@ h",
+		" i",
+		" j",
+		" k",
+		"-l",
+		"+L",
+		" m",
+	}
+	if !reflect.DeepEqual(lines, expected) || added != 2 || removed != 2 {
+		t.Errorf("unifiedHunks() = %q, +%d/-%d; expected %q, +2/-2", lines, added, removed, expected)
+	}
+
+	// Changes six lines apart share a hunk
+	lines, _, _ = unifiedHunks([]byte("1\n2\n3\n4\n5\n6\n7\n8\n"), []byte("X\n2\n3\n4\n5\n6\n7\nY\n"), 3)
+	if len(lines) == 0 || lines[0] != "@@ -1,8 +1,8 @@" || strings.Count(strings.Join(lines, "\n"), "@@ -") != 1 {
+		t.Errorf("Expected a single hunk, got %q", lines)
+	}
+}
+
+func TestUnifiedHunksNoNewline(t *testing.T) {
+	lines, _, _ := unifiedHunks([]byte("x"), []byte("y"), 3)
+	expected := []string{
+		"@@ -1 +1 @@",
+		"-x",
+		`\ No newline at end of file`,
+		"+y",
+		`\ No newline at end of file`,
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("unifiedHunks() = %q, expected %q", lines, expected)
+	}
+
+	lines, _, _ = unifiedHunks([]byte("a\nb"), []byte("a\nb\n"), 3)
+	expected = []string{
+		"@@ -1,2 +1,2 @@",
+		" a",
+		"-b",
+		`\ No newline at end of file`,
+		"+b",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("unifiedHunks() = %q, expected %q", lines, expected)
+	}
+}
+
+func TestHunkRange(t *testing.T) {
+	tests := []struct {
+		start, count int
+		expected     string
+	}{
+		{0, 0, "0,0"},
+		{3, 0, "3,0"},
+		{0, 1, "1"},
+		{4, 3, "5,3"},
+	}
+
+	for _, test := range tests {
+		if result := hunkRange(test.start, test.count); result != test.expected {
+			t.Errorf("hunkRange(%d, %d) = %q, expected %q", test.start, test.count, result, test.expected)
+		}
+	}
+}
+
+func TestFuncName(t *testing.T) {
+	lines := []string{"func main() {  \n", "\tbody\n", "\n", "  indented\n", "\tx\n"}
+
+	if name := funcName(lines, 4); name != "func main() {" {
+		t.Errorf("funcName() = %q, expected %q", name, "func main() {")
+	}
+	if name := funcName(lines, 0); name != "" {
+		t.Errorf("funcName() at the top = %q, expected none", name)
+	}
+	long := strings.Repeat("x", 100) + "\n"
+	if name := funcName([]string{long}, 1); len(name) != 80 {
+		t.Errorf("Expected the function name to be cut to 80 bytes, got %d", len(name))
+	}
+}
@@ -0,0 +1,886 @@
+package git
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// errNativeUnsupported marks requests the native backend leaves to git.
+var errNativeUnsupported = errors.New("not supported by the native backend")
+
+const nullHash = "0000000000000000000000000000000000000000"
+
+// symlinkFS is implemented by worktrees that can tell symlinks apart from
+// the files they point to. Without it, entries whose mode has
+// fs.ModeSymlink hold the link target as their content, as with
+// fstest.MapFS.
+type symlinkFS interface {
+	Lstat(name string) (fs.FileInfo, error)
+	ReadLink(name string) (string, error)
+}
+
+// osDirFS is a directory of the real file system.
+type osDirFS struct {
+	fs.FS
+	root string
+}
+
+func newOSDirFS(root string) osDirFS {
+	return osDirFS{FS: os.DirFS(root), root: root}
+}
+
+func (d osDirFS) Lstat(name string) (fs.FileInfo, error) {
+	return os.Lstat(filepath.Join(d.root, filepath.FromSlash(name)))
+}
+
+func (d osDirFS) ReadLink(name string) (string, error) {
+	return os.Readlink(filepath.Join(d.root, filepath.FromSlash(name)))
+}
+
+// nativeBackend reads the index, the object database and the worktree
+// itself and diffs in process. It does not detect renames or convert
+// content, and it leaves anything it cannot answer, such as revision
+// expressions, pathspec magic, binary patches, other diff algorithms or
+// worktree files that attributes convert, to the fallback backend.
+type nativeBackend struct {
+	fallback   Backend
+	gitDir     fs.FS
+	worktree   fs.FS
+	objects    *objectStore
+	excludes   []byte
+	attributes []byte           // The global attributes file
+	conversion *conversionRules // Read when first needed
+	index      []indexEntry
+	indexTime  time.Time
+	indexRead  bool
+	trees      map[string]map[string]treeEntry
+}
+
+// NewNativeBackend returns a backend that reads the repository without
+// running git, asking fallback for config and whatever it cannot do itself.
+func NewNativeBackend(r *Repository, fallback Backend) (Backend, error) {
+	if _, err := os.Stat(r.RepoPath("commondir")); err == nil {
+		return nil, fmt.Errorf("linked worktrees are not supported by the native backend")
+	}
+	if format, ok := fallback.Config("extensions.objectformat"); ok && format != "sha1" {
+		return nil, fmt.Errorf("%s repositories are not supported by the native backend", format)
+	}
+	if storage, ok := fallback.Config("extensions.refstorage"); ok && storage != "files" {
+		return nil, fmt.Errorf("%s ref storage is not supported by the native backend", storage)
+	}
+	if value, ok := fallback.Config("core.autocrlf"); ok {
+		if enabled, err := parseConfigBool(value); enabled || err != nil {
+			return nil, fmt.Errorf("core.autocrlf=%s is not supported by the native backend", value)
+		}
+	}
+
+	b := newNativeBackend(newOSDirFS(r.gitDir), newOSDirFS(r.workTree), fallback)
+	if excludesFile := globalConfigFile(fallback, "core.excludesfile", "ignore"); excludesFile != "" {
+		b.excludes, _ = os.ReadFile(excludesFile)
+	}
+	b.attributes = globalAttributes(fallback)
+	return b, nil
+}
+
+func newNativeBackend(gitDir, worktree fs.FS, fallback Backend) *nativeBackend {
+	return &nativeBackend{
+		fallback: fallback,
+		gitDir:   gitDir,
+		worktree: worktree,
+		objects:  &objectStore{gitDir: gitDir},
+		trees:    make(map[string]map[string]treeEntry),
+	}
+}
+
+func (b *nativeBackend) Config(key string) (string, bool) {
+	return b.fallback.Config(key)
+}
+
+func (b *nativeBackend) Color(key, defaultColor string) string {
+	return b.fallback.Color(key, defaultColor)
+}
+
+func (b *nativeBackend) Head() (string, bool) {
+	hash, err := b.resolveRef("HEAD")
+	if err != nil {
+		return "", false
+	}
+	return hash, true
+}
+
+func (b *nativeBackend) SetDiffScope(paths []string) {
+	b.indexRead = false
+	b.fallback.SetDiffScope(paths)
+}
+
+// Apply leaves patches to the fallback, which writes the index and the
+// worktree, and rereads the index afterwards.
+func (b *nativeBackend) Apply(args []string, patch []byte) error {
+	b.indexRead = false
+	return b.fallback.Apply(args, patch)
+}
+
+func (b *nativeBackend) Invalidate(paths ...string) {
+	b.indexRead = false
+	b.conversion = nil
+	b.fallback.Invalidate(paths...)
+}
+
+// Diff produces "diff-files -p" and "diff-index -p" output itself and
+// hands other requests to the fallback.
+func (b *nativeBackend) Diff(args []string, paths []string) ([]string, error) {
+	request, err := parseNativeDiffArgs(args)
+	if err == nil {
+		var lines []string
+		if lines, err = b.diff(request, paths); err == nil {
+			return lines, nil
+		}
+	}
+	return b.fallback.Diff(args, paths)
+}
+
+func (b *nativeBackend) Status(filter, reference string, paths []string) (map[string]*FileStatus, error) {
+	if statusMap, err := b.status(filter, reference, paths); err == nil {
+		return statusMap, nil
+	}
+	return b.fallback.Status(filter, reference, paths)
+}
+
+func (b *nativeBackend) Untracked() ([]string, error) {
+	if untracked, err := b.untracked(); err == nil {
+		return untracked, nil
+	}
+	return b.fallback.Untracked()
+}
+
+// nativeDiffRequest is a diff command the native backend understands.
+type nativeDiffRequest struct {
+	cached   bool
+	worktree bool
+	reverse  bool
+	color    bool
+	revision string
+}
+
+func parseNativeDiffArgs(args []string) (*nativeDiffRequest, error) {
+	if len(args) == 0 || (args[0] != "diff-files" && args[0] != "diff-index") {
+		return nil, errNativeUnsupported
+	}
+
+	request := &nativeDiffRequest{}
+	for _, arg := range args[1:] {
+		switch {
+		case arg == "-p" || arg == "--no-color":
+		case arg == "--cached":
+			request.cached = true
+		case arg == "-R":
+			request.reverse = true
+		case arg == "--color=always":
+			request.color = true
+		case arg == "--diff-algorithm=myers" || arg == "--diff-algorithm=default":
+		case !strings.HasPrefix(arg, "-") && request.revision == "":
+			request.revision = arg
+		default:
+			return nil, errNativeUnsupported
+		}
+	}
+
+	if args[0] == "diff-files" {
+		if request.cached || request.revision != "" {
+			return nil, errNativeUnsupported
+		}
+		request.worktree = true
+	} else if request.revision == "" {
+		return nil, errNativeUnsupported
+	}
+	return request, nil
+}
+
+// fileSide is one version of a file being compared.
+type fileSide struct {
+	Mode    uint32
+	Hash    string
+	Content []byte
+}
+
+// filePair is a changed path with its old and new versions; a nil side
+// means the path does not exist on that side.
+type filePair struct {
+	Path     string
+	Old      *fileSide
+	New      *fileSide
+	Unmerged bool
+}
+
+func (b *nativeBackend) diff(request *nativeDiffRequest, paths []string) ([]string, error) {
+	pairs, err := b.changes(request, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	var colors *diffColors
+	if request.color {
+		colors = b.diffColors()
+	}
+	quote := true
+	if value, ok := b.Config("core.quotepath"); ok {
+		quote, _ = parseConfigBool(value)
+	}
+
+	var lines []string
+	for _, pair := range pairs {
+		if pair.Unmerged {
+			continue
+		}
+		if request.reverse {
+			pair.Old, pair.New = pair.New, pair.Old
+		}
+		if err := b.loadContent(pair); err != nil {
+			return nil, err
+		}
+		lines = append(lines, formatFilePatch(pair, request.reverse, quote, colors)...)
+	}
+	return lines, nil
+}
+
+func (b *nativeBackend) status(filter, reference string, paths []string) (map[string]*FileStatus, error) {
+	statusMap := make(map[string]*FileStatus)
+	entry := func(path string) *FileStatus {
+		status := statusMap[path]
+		if status == nil {
+			status = &FileStatus{Index: "unchanged", File: "nothing"}
+			statusMap[path] = status
+		}
+		return status
+	}
+
+	var requests []*nativeDiffRequest
+	if filter != "file-only" {
+		requests = append(requests, &nativeDiffRequest{cached: true, revision: reference})
+	}
+	if filter != "index-only" {
+		requests = append(requests, &nativeDiffRequest{worktree: true})
+	}
+
+	for _, request := range requests {
+		pairs, err := b.changes(request, paths)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pair := range pairs {
+			status := entry(pair.Path)
+			if pair.Unmerged {
+				status.Unmerged = true
+				continue
+			}
+			if err := b.loadContent(pair); err != nil {
+				return nil, err
+			}
+
+			summary := "+0/-0"
+			switch {
+			case isBinaryPair(pair):
+				summary = "binary"
+				status.Binary = true
+			case pair.Old == nil || pair.New == nil || pair.Old.Hash != pair.New.Hash:
+				_, added, removed := unifiedHunks(sideContent(pair.Old), sideContent(pair.New), 3)
+				summary = fmt.Sprintf("+%d/-%d", added, removed)
+			}
+
+			addDel := ""
+			if pair.Old == nil {
+				addDel = "create"
+			} else if pair.New == nil {
+				addDel = "delete"
+			}
+
+			if request.worktree {
+				status.File, status.FileAddDel = summary, addDel
+			} else {
+				status.Index, status.IndexAddDel = summary, addDel
+			}
+		}
+	}
+	return statusMap, nil
+}
+
+// changes lists the paths that differ for a diff request, sorted by path.
+func (b *nativeBackend) changes(request *nativeDiffRequest, paths []string) ([]*filePair, error) {
+	spec, err := ParsePathspec(paths)
+	if err != nil || spec.HasAttr() {
+		// git reports bad pathspecs and looks up attributes
+		return nil, errNativeUnsupported
+	}
+
+	entries, err := b.loadIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	var pairs []*filePair
+	unmerged := make(map[string]bool)
+	staged := make(map[string]indexEntry)
+	for _, entry := range entries {
+		if !spec.Match(entry.Path) || entry.Mode == 0160000 {
+			continue
+		}
+		if entry.Stage != 0 {
+			if !unmerged[entry.Path] {
+				unmerged[entry.Path] = true
+				pairs = append(pairs, &filePair{Path: entry.Path, Unmerged: true})
+			}
+			continue
+		}
+		staged[entry.Path] = entry
+	}
+
+	if request.worktree {
+		for path, entry := range staged {
+			old := &fileSide{Mode: entry.Mode, Hash: entry.Hash}
+			if entry.IntentToAdd {
+				old = nil
+			}
+			current, err := b.worktreeSide(path, &entry)
+			if err != nil {
+				return nil, err
+			}
+			if current == nil && old == nil {
+				continue
+			}
+			if current != nil && old != nil && current.Hash == old.Hash && current.Mode == old.Mode {
+				continue
+			}
+			pairs = append(pairs, &filePair{Path: path, Old: old, New: current})
+		}
+		return sortPairs(pairs), nil
+	}
+
+	treeHash, err := b.resolveTree(request.revision)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := b.tree(treeHash)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for path := range unmerged {
+		seen[path] = true
+	}
+	compare := func(path string) error {
+		if seen[path] || !spec.Match(path) {
+			return nil
+		}
+		seen[path] = true
+
+		var old, current *fileSide
+		if entry, ok := tree[path]; ok && entry.Mode != 0160000 {
+			old = &fileSide{Mode: entry.Mode, Hash: entry.Hash}
+		}
+		if entry, ok := staged[path]; ok {
+			if request.cached {
+				current = &fileSide{Mode: entry.Mode, Hash: entry.Hash}
+			} else {
+				var err error
+				if current, err = b.worktreeSide(path, &entry); err != nil {
+					return err
+				}
+			}
+		}
+
+		if old == nil && current == nil {
+			return nil
+		}
+		if old != nil && current != nil && old.Hash == current.Hash && old.Mode == current.Mode {
+			return nil
+		}
+		pairs = append(pairs, &filePair{Path: path, Old: old, New: current})
+		return nil
+	}
+
+	for path := range tree {
+		if err := compare(path); err != nil {
+			return nil, err
+		}
+	}
+	for path := range staged {
+		if err := compare(path); err != nil {
+			return nil, err
+		}
+	}
+	return sortPairs(pairs), nil
+}
+
+func sortPairs(pairs []*filePair) []*filePair {
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Path < pairs[j].Path
+	})
+	return pairs
+}
+
+// worktreeSide reads the worktree version of an indexed path, or returns
+// nil when it is gone. Files whose stat data matches the index entry are
+// taken to be unchanged without reading them, unless they were modified
+// too close to when the index was written to be sure. Files that git would
+// convert on the way to the index are left to the fallback.
+func (b *nativeBackend) worktreeSide(name string, entry *indexEntry) (*fileSide, error) {
+	info, err := b.lstat(name)
+	if err != nil || info.IsDir() {
+		return nil, nil
+	}
+
+	mode := worktreeMode(info, entry.Mode, b.fileMode())
+	if !entry.IntentToAdd && mode == entry.Mode && b.statClean(info, entry) {
+		return &fileSide{Mode: mode, Hash: entry.Hash}, nil
+	}
+
+	var content []byte
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := b.readLink(name)
+		if err != nil {
+			return nil, err
+		}
+		content = []byte(target)
+	} else {
+		if b.conversion == nil {
+			b.conversion = newConversionRules(b.fallback, b.gitDir, b.worktree, b.attributes)
+		}
+		if b.conversion.converts(name) {
+			return nil, errNativeUnsupported
+		}
+		if content, err = fs.ReadFile(b.worktree, name); err != nil {
+			return nil, err
+		}
+	}
+
+	return &fileSide{Mode: mode, Hash: blobHash(content), Content: content}, nil
+}
+
+func (b *nativeBackend) statClean(info fs.FileInfo, entry *indexEntry) bool {
+	mtime := info.ModTime()
+	if uint32(info.Size()) != entry.Size ||
+		uint32(mtime.Unix()) != entry.MtimeSec || uint32(mtime.Nanosecond()) != entry.MtimeNsec {
+		return false
+	}
+	return mtime.Before(b.indexTime)
+}
+
+func (b *nativeBackend) fileMode() bool {
+	if value, ok := b.Config("core.filemode"); ok {
+		use, err := parseConfigBool(value)
+		return err != nil || use
+	}
+	return true
+}
+
+// worktreeMode is the index mode a worktree file would get. Without
+// core.fileMode the executable bit is taken from the index.
+func worktreeMode(info fs.FileInfo, indexMode uint32, fileMode bool) uint32 {
+	if info.Mode()&fs.ModeSymlink != 0 {
+		return 0120000
+	}
+	if !fileMode && indexMode&0170000 == 0100000 {
+		return indexMode
+	}
+	if info.Mode().Perm()&0111 != 0 {
+		return 0100755
+	}
+	return 0100644
+}
+
+func (b *nativeBackend) lstat(name string) (fs.FileInfo, error) {
+	if links, ok := b.worktree.(symlinkFS); ok {
+		return links.Lstat(name)
+	}
+	return fs.Stat(b.worktree, name)
+}
+
+func (b *nativeBackend) readLink(name string) (string, error) {
+	if links, ok := b.worktree.(symlinkFS); ok {
+		return links.ReadLink(name)
+	}
+	target, err := fs.ReadFile(b.worktree, name)
+	return string(target), err
+}
+
+func blobHash(content []byte) string {
+	sum := sha1.Sum(append([]byte(fmt.Sprintf("blob %d\x00", len(content))), content...))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadContent reads the blobs of a pair that did not come from the
+// worktree.
+func (b *nativeBackend) loadContent(pair *filePair) error {
+	for _, side := range []*fileSide{pair.Old, pair.New} {
+		if side == nil || side.Content != nil {
+			continue
+		}
+		if side.Mode == 0160000 {
+			side.Content = []byte{}
+			continue
+		}
+		content, err := b.objects.readType(side.Hash, "blob")
+		if err != nil {
+			return err
+		}
+		side.Content = content
+	}
+	return nil
+}
+
+func (b *nativeBackend) loadIndex() ([]indexEntry, error) {
+	if b.indexRead {
+		return b.index, nil
+	}
+
+	data, err := fs.ReadFile(b.gitDir, "index")
+	if errors.Is(err, fs.ErrNotExist) {
+		b.index, b.indexTime, b.indexRead = nil, time.Time{}, true
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries, err := parseIndex(data)
+	if err != nil {
+		return nil, err
+	}
+
+	b.indexTime = time.Time{}
+	if info, err := fs.Stat(b.gitDir, "index"); err == nil {
+		b.indexTime = info.ModTime()
+	}
+	b.index, b.indexRead = entries, true
+	return entries, nil
+}
+
+func (b *nativeBackend) tree(hash string) (map[string]treeEntry, error) {
+	if tree, ok := b.trees[hash]; ok {
+		return tree, nil
+	}
+	tree := make(map[string]treeEntry)
+	if err := b.objects.flattenTree(hash, "", tree); err != nil {
+		return nil, err
+	}
+	b.trees[hash] = tree
+	return tree, nil
+}
+
+// resolveTree finds the tree of a full object name, HEAD, or a ref name
+// looked up the way git does. Other revision syntax is left to git.
+func (b *nativeBackend) resolveTree(revision string) (string, error) {
+	hash := ""
+	if isFullHash(revision) {
+		hash = revision
+	} else {
+		candidates := []string{revision}
+		if revision != "HEAD" {
+			candidates = append(candidates, "refs/"+revision, "refs/tags/"+revision,
+				"refs/heads/"+revision, "refs/remotes/"+revision, "refs/remotes/"+revision+"/HEAD")
+		}
+		for _, name := range candidates {
+			if resolved, err := b.resolveRef(name); err == nil {
+				hash = resolved
+				break
+			}
+		}
+	}
+	if hash == "" {
+		return "", errNativeUnsupported
+	}
+	return b.objects.treeOf(hash)
+}
+
+// resolveRef follows a ref through symbolic refs, loose refs and
+// packed-refs to an object name.
+func (b *nativeBackend) resolveRef(name string) (string, error) {
+	for depth := 0; depth < 5; depth++ {
+		if strings.Contains(name, "..") || strings.ContainsAny(name, " ~^:?*[\\") {
+			return "", errNativeUnsupported
+		}
+
+		if data, err := fs.ReadFile(b.gitDir, name); err == nil {
+			value := strings.TrimSpace(string(data))
+			if target := strings.TrimPrefix(value, "ref: "); target != value {
+				name = target
+				continue
+			}
+			if !isFullHash(value) {
+				return "", fmt.Errorf("bad ref %s", name)
+			}
+			return value, nil
+		}
+
+		if hash, ok := b.packedRef(name); ok {
+			return hash, nil
+		}
+		return "", fmt.Errorf("ref %s not found", name)
+	}
+	return "", fmt.Errorf("too many levels of symbolic refs")
+}
+
+func (b *nativeBackend) packedRef(name string) (string, bool) {
+	data, err := fs.ReadFile(b.gitDir, "packed-refs")
+	if err != nil {
+		return "", false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "^") {
+			continue
+		}
+		if fields := strings.Fields(line); len(fields) == 2 && fields[1] == name {
+			return fields[0], true
+		}
+	}
+	return "", false
+}
+
+func isFullHash(name string) bool {
+	if len(name) != 40 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// untracked lists the files that are neither in the index nor ignored by
+// info/exclude, core.excludesFile or a .gitignore on the way to them.
+// Directories holding another repository are listed as "dir/".
+func (b *nativeBackend) untracked() ([]string, error) {
+	entries, err := b.loadIndex()
+	if err != nil {
+		return nil, err
+	}
+	tracked := make(map[string]bool)
+	for _, entry := range entries {
+		tracked[entry.Path] = true
+	}
+
+	rules := parseIgnoreFile(b.excludes, "")
+	if exclude, err := fs.ReadFile(b.gitDir, "info/exclude"); err == nil {
+		rules = append(rules, parseIgnoreFile(exclude, "")...)
+	}
+
+	var untracked []string
+	var walk func(dir string, rules []ignoreRule) error
+	walk = func(dir string, rules []ignoreRule) error {
+		fsDir := dir
+		if fsDir == "" {
+			fsDir = "."
+		}
+		if ignore, err := fs.ReadFile(b.worktree, path.Join(fsDir, ".gitignore")); err == nil {
+			rules = append(rules[:len(rules):len(rules)], parseIgnoreFile(ignore, dir)...)
+		}
+
+		dirEntries, err := fs.ReadDir(b.worktree, fsDir)
+		if err != nil {
+			return err
+		}
+		for _, dirEntry := range dirEntries {
+			name := dirEntry.Name()
+			if name == ".git" {
+				continue
+			}
+			full := name
+			if dir != "" {
+				full = dir + "/" + name
+			}
+
+			if dirEntry.IsDir() {
+				if tracked[full] || isIgnored(rules, full, true) {
+					continue
+				}
+				if _, err := fs.Stat(b.worktree, full+"/.git"); err == nil {
+					untracked = append(untracked, full+"/")
+					continue
+				}
+				if err := walk(full, rules); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if !tracked[full] && !isIgnored(rules, full, false) {
+				untracked = append(untracked, full)
+			}
+		}
+		return nil
+	}
+
+	if err := walk("", rules); err != nil {
+		return nil, err
+	}
+	sort.Strings(untracked)
+	return untracked, nil
+}
+
+func isBinaryPair(pair *filePair) bool {
+	return isBinaryContent(sideContent(pair.Old)) || isBinaryContent(sideContent(pair.New))
+}
+
+func sideContent(side *fileSide) []byte {
+	if side == nil {
+		return nil
+	}
+	return side.Content
+}
+
+// diffColors are the escape sequences of colored diff output.
+type diffColors struct {
+	meta, frag, fn, old, new, context string
+}
+
+func (b *nativeBackend) diffColors() *diffColors {
+	return &diffColors{
+		meta:    b.Color("color.diff.meta", "bold"),
+		frag:    b.Color("color.diff.frag", "cyan"),
+		fn:      b.Color("color.diff.func", ""),
+		old:     b.Color("color.diff.old", "red"),
+		new:     b.Color("color.diff.new", "green"),
+		context: b.Color("color.diff.context", ""),
+	}
+}
+
+const colorReset = "\x1b[m"
+
+// formatFilePatch renders one file of a "git diff" style patch. Reversed
+// patches swap the a/ and b/ prefixes along with the sides, as git does.
+func formatFilePatch(pair *filePair, reverse, quote bool, colors *diffColors) []string {
+	aPrefix, bPrefix := "a/", "b/"
+	if reverse {
+		aPrefix, bPrefix = bPrefix, aPrefix
+	}
+	aName, bName := quoteDiffPath(aPrefix+pair.Path, quote), quoteDiffPath(bPrefix+pair.Path, quote)
+	oldName, newName := aName, bName
+
+	var header []string
+	header = append(header, "diff --git "+aName+" "+bName)
+
+	oldHash, newHash := nullHash, nullHash
+	indexMode := ""
+	switch {
+	case pair.Old == nil:
+		header = append(header, fmt.Sprintf("new file mode %06o", pair.New.Mode))
+		newHash = pair.New.Hash
+		oldName = "/dev/null"
+	case pair.New == nil:
+		header = append(header, fmt.Sprintf("deleted file mode %06o", pair.Old.Mode))
+		oldHash = pair.Old.Hash
+		newName = "/dev/null"
+	default:
+		if pair.Old.Mode != pair.New.Mode {
+			header = append(header, fmt.Sprintf("old mode %06o", pair.Old.Mode),
+				fmt.Sprintf("new mode %06o", pair.New.Mode))
+		} else {
+			indexMode = fmt.Sprintf(" %06o", pair.New.Mode)
+		}
+		oldHash, newHash = pair.Old.Hash, pair.New.Hash
+	}
+	if oldHash != newHash {
+		header = append(header, "index "+shortHash(oldHash)+".."+shortHash(newHash)+indexMode)
+	}
+
+	var body []string
+	if oldHash != newHash {
+		if isBinaryPair(pair) {
+			header = append(header, "Binary files "+oldName+" and "+newName+" differ")
+		} else if body, _, _ = unifiedHunks(sideContent(pair.Old), sideContent(pair.New), 3); len(body) > 0 {
+			header = append(header, "--- "+oldName+nameTab(oldName), "+++ "+newName+nameTab(newName))
+		}
+	}
+
+	if colors == nil {
+		return append(header, body...)
+	}
+
+	lines := make([]string, 0, len(header)+len(body))
+	for _, line := range header {
+		lines = append(lines, colors.meta+line+colorReset)
+	}
+	for _, line := range body {
+		switch line[0] {
+		case '@':
+			end := strings.Index(line[2:], "@@") + 4
+			colored := colors.frag + line[:end] + colorReset
+			if end < len(line) {
+				colored += " " + colors.fn + line[end+1:] + colorReset
+			}
+			lines = append(lines, colored)
+		case '-':
+			lines = append(lines, colors.old+line+colorReset)
+		case '+':
+			lines = append(lines, colors.new+line+colorReset)
+		default:
+			lines = append(lines, colors.context+line+colorReset)
+		}
+	}
+	return lines
+}
+
+// nameTab ends the file names of "---" and "+++" lines that contain a
+// space with a tab, so patch tools can tell where they end.
+func nameTab(name string) string {
+	if strings.Contains(name, " ") {
+		return "\t"
+	}
+	return ""
+}
+
+// quoteDiffPath quotes a path the way git does in diff headers when it
+// holds control characters, quotes or backslashes, or, with quote set,
+// bytes outside ASCII.
+func quoteDiffPath(name string, quote bool) string {
+	needsQuote := false
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c < 0x20 || c == '"' || c == '\\' || c == 0x7f || (quote && c >= 0x80) {
+			needsQuote = true
+			break
+		}
+	}
+	if !needsQuote {
+		return name
+	}
+
+	var quoted strings.Builder
+	quoted.WriteByte('"')
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c == '"' || c == '\\':
+			quoted.WriteByte('\\')
+			quoted.WriteByte(c)
+		case c == '\a':
+			quoted.WriteString(`\a`)
+		case c == '\b':
+			quoted.WriteString(`\b`)
+		case c == '\t':
+			quoted.WriteString(`\t`)
+		case c == '\n':
+			quoted.WriteString(`\n`)
+		case c == '\v':
+			quoted.WriteString(`\v`)
+		case c == '\f':
+			quoted.WriteString(`\f`)
+		case c == '\r':
+			quoted.WriteString(`\r`)
+		case c < 0x20 || c == 0x7f || (quote && c >= 0x80):
+			quoted.WriteString(fmt.Sprintf("\\%03o", c))
+		default:
+			quoted.WriteByte(c)
+		}
+	}
+	quoted.WriteByte('"')
+	return quoted.String()
+}
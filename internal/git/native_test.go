@@ -0,0 +1,407 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// stubBackend is the fallback of the native backend in tests; it only
+// knows config values and fails everything else.
+type stubBackend struct {
+	config map[string]string
+}
+
+func (b *stubBackend) Config(key string) (string, bool) {
+	value, ok := b.config[key]
+	return value, ok
+}
+
+func (b *stubBackend) Color(key, defaultColor string) string { return "" }
+func (b *stubBackend) Head() (string, bool)                  { return "", false }
+func (b *stubBackend) SetDiffScope(paths []string)           {}
+func (b *stubBackend) Invalidate(paths ...string)            {}
+
+func (b *stubBackend) Diff(args []string, paths []string) ([]string, error) {
+	return nil, fmt.Errorf("fallback diff %v", args)
+}
+
+func (b *stubBackend) Status(filter, reference string, paths []string) (map[string]*FileStatus, error) {
+	return nil, fmt.Errorf("fallback status")
+}
+
+func (b *stubBackend) Untracked() ([]string, error) {
+	return nil, fmt.Errorf("fallback untracked")
+}
+
+func (b *stubBackend) Apply(args []string, patch []byte) error {
+	return fmt.Errorf("fallback apply")
+}
+
+// addObject stores a loose object and returns its name.
+func addObject(gitDir fstest.MapFS, objType string, content []byte) string {
+	data := append([]byte(fmt.Sprintf("%s %d\x00", objType, len(content))), content...)
+	sum := sha1.Sum(data)
+	hash := hex.EncodeToString(sum[:])
+
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	w.Write(data)
+	w.Close()
+	gitDir["objects/"+hash[:2]+"/"+hash[2:]] = &fstest.MapFile{Data: compressed.Bytes()}
+	return hash
+}
+
+// addTree stores the trees of a set of files and returns the top one.
+func addTree(gitDir fstest.MapFS, files map[string]treeEntry) string {
+	var names []string
+	subdirs := make(map[string]map[string]treeEntry)
+	for name, entry := range files {
+		if slash := strings.Index(name, "/"); slash != -1 {
+			dir := name[:slash]
+			if subdirs[dir] == nil {
+				subdirs[dir] = make(map[string]treeEntry)
+				names = append(names, dir)
+			}
+			subdirs[dir][name[slash+1:]] = entry
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var content []byte
+	for _, name := range names {
+		entry, ok := files[name]
+		if !ok {
+			entry = treeEntry{Mode: 040000, Hash: addTree(gitDir, subdirs[name])}
+		}
+		raw, _ := hex.DecodeString(entry.Hash)
+		content = append(content, fmt.Sprintf("%o %s\x00", entry.Mode, name)...)
+		content = append(content, raw...)
+	}
+	return addObject(gitDir, "tree", content)
+}
+
+// buildIndex encodes a version 2 index, or version 3 when an entry is
+// intent-to-add.
+func buildIndex(entries []indexEntry) []byte {
+	version := uint32(2)
+	for _, entry := range entries {
+		if entry.IntentToAdd {
+			version = 3
+		}
+	}
+
+	var data []byte
+	data = append(data, "DIRC"...)
+	data = binary.BigEndian.AppendUint32(data, version)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(entries)))
+	for _, entry := range entries {
+		start := len(data)
+		fields := make([]byte, 40)
+		binary.BigEndian.PutUint32(fields[8:], entry.MtimeSec)
+		binary.BigEndian.PutUint32(fields[12:], entry.MtimeNsec)
+		binary.BigEndian.PutUint32(fields[24:], entry.Mode)
+		binary.BigEndian.PutUint32(fields[36:], entry.Size)
+		data = append(data, fields...)
+		raw, _ := hex.DecodeString(entry.Hash)
+		data = append(data, raw...)
+
+		flags := uint16(len(entry.Path)) | uint16(entry.Stage)<<indexFlagStageShift
+		if entry.IntentToAdd {
+			flags |= indexFlagExtended
+		}
+		data = binary.BigEndian.AppendUint16(data, flags)
+		if entry.IntentToAdd {
+			data = binary.BigEndian.AppendUint16(data, indexExtIntentToAdd)
+		}
+		data = append(data, entry.Path...)
+		for pad := 8 - (len(data)-start)%8; pad > 0; pad-- {
+			data = append(data, 0)
+		}
+	}
+	sum := sha1.Sum(data)
+	return append(data, sum[:]...)
+}
+
+// newTestRepository builds an in-memory repository whose HEAD commit has
+// the files in head and whose index has the files in index.
+func newTestRepository(head map[string]string, index []indexEntry) (fstest.MapFS, fstest.MapFS) {
+	gitDir := fstest.MapFS{}
+	files := make(map[string]treeEntry)
+	for name, content := range head {
+		files[name] = treeEntry{Mode: 0100644, Hash: addObject(gitDir, "blob", []byte(content))}
+	}
+	tree := addTree(gitDir, files)
+	commit := addObject(gitDir, "commit", []byte("tree "+tree+"\nauthor A <a> 0 +0000\n\ninitial\n"))
+
+	gitDir["HEAD"] = &fstest.MapFile{Data: []byte("ref: refs/heads/main\n")}
+	gitDir["packed-refs"] = &fstest.MapFile{Data: []byte("# pack-refs with: peeled\n" + commit + " refs/heads/main\n")}
+	gitDir["index"] = &fstest.MapFile{Data: buildIndex(index)}
+	return gitDir, fstest.MapFS{}
+}
+
+func TestNativeDiffFiles(t *testing.T) {
+	gitDir := fstest.MapFS{}
+	oldF := addObject(gitDir, "blob", []byte("one\ntwo\nthree\n"))
+	oldGone := addObject(gitDir, "blob", []byte("bye\n"))
+	empty := addObject(gitDir, "blob", nil)
+	gitDir["index"] = &fstest.MapFile{Data: buildIndex([]indexEntry{
+		{Path: "dir/f", Mode: 0100644, Hash: oldF},
+		{Path: "gone", Mode: 0100644, Hash: oldGone},
+		{Path: "new", Mode: 0100644, Hash: empty, IntentToAdd: true},
+		{Path: "same", Mode: 0100644, Hash: addObject(gitDir, "blob", []byte("same\n"))},
+	})}
+
+	worktree := fstest.MapFS{
+		"dir/f": {Data: []byte("one\n2\nthree\n")},
+		"new":   {Data: []byte("n\n")},
+		"same":  {Data: []byte("same\n")},
+	}
+	b := newNativeBackend(gitDir, worktree, &stubBackend{})
+
+	lines, err := b.Diff([]string{"diff-files", "-p", "--no-color"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"diff --git a/dir/f b/dir/f",
+		"index " + shortHash(oldF) + ".." + shortHash(blobHash([]byte("one\n2\nthree\n"))) + " 100644",
+		"--- a/dir/f",
+		"+++ b/dir/f",
+		"@@ -1,3 +1,3 @@",
+		" one",
+		"-two",
+		"+2",
+		" three",
+		"diff --git a/gone b/gone",
+		"deleted file mode 100644",
+		"index " + shortHash(oldGone) + "..0000000",
+		"--- a/gone",
+		"+++ /dev/null",
+		"@@ -1 +0,0 @@",
+		"-bye",
+		"diff --git a/new b/new",
+		"new file mode 100644",
+		"index 0000000.." + shortHash(blobHash([]byte("n\n"))),
+		"--- /dev/null",
+		"+++ b/new",
+		"@@ -0,0 +1 @@",
+		"+n",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("diff-files -p =\n%s\nexpected\n%s", strings.Join(lines, "\n"), strings.Join(expected, "\n"))
+	}
+
+	lines, err = b.Diff([]string{"diff-files", "-p", "--no-color"}, []string{"dir"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) == 0 || lines[0] != "diff --git a/dir/f b/dir/f" || len(lines) != 9 {
+		t.Errorf("Expected only dir/f for the pathspec dir, got %q", lines)
+	}
+}
+
+func TestNativeDiffIndex(t *testing.T) {
+	gitDir, worktree := newTestRepository(map[string]string{"a": "a\n", "b": "b\n"}, nil)
+	staged := addObject(gitDir, "blob", []byte("A\n"))
+	gitDir["index"] = &fstest.MapFile{Data: buildIndex([]indexEntry{
+		{Path: "a", Mode: 0100755, Hash: staged},
+		{Path: "c", Mode: 0100644, Hash: addObject(gitDir, "blob", []byte("c\n"))},
+	})}
+	b := newNativeBackend(gitDir, worktree, &stubBackend{})
+
+	lines, err := b.Diff([]string{"diff-index", "-p", "--cached", "HEAD", "--no-color"}, []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"diff --git a/a b/a",
+		"old mode 100644",
+		"new mode 100755",
+		"index " + shortHash(blobHash([]byte("a\n"))) + ".." + shortHash(staged),
+		"--- a/a",
+		"+++ b/a",
+		"@@ -1 +1 @@",
+		"-a",
+		"+A",
+		"diff --git a/b b/b",
+		"deleted file mode 100644",
+		"index " + shortHash(blobHash([]byte("b\n"))) + "..0000000",
+		"--- a/b",
+		"+++ /dev/null",
+		"@@ -1 +0,0 @@",
+		"-b",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("diff-index -p --cached =\n%s\nexpected\n%s", strings.Join(lines, "\n"), strings.Join(expected, "\n"))
+	}
+
+	lines, err = b.Diff([]string{"diff-index", "-R", "-p", "--cached", "HEAD", "--no-color"}, []string{"c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) < 2 || lines[0] != "diff --git b/c a/c" || lines[1] != "deleted file mode 100644" {
+		t.Errorf("Expected a reversed addition of c, got %q", lines)
+	}
+}
+
+func TestNativeStatus(t *testing.T) {
+	gitDir, worktree := newTestRepository(map[string]string{"a": "a\n", "b": "b\n"}, nil)
+	gitDir["index"] = &fstest.MapFile{Data: buildIndex([]indexEntry{
+		{Path: "a", Mode: 0100644, Hash: addObject(gitDir, "blob", []byte("a\nA\n"))},
+		{Path: "b", Mode: 0100644, Hash: blobHash([]byte("b\n"))},
+		{Path: "bin", Mode: 0100644, Hash: addObject(gitDir, "blob", []byte("\x00\x01"))},
+		{Path: "u", Mode: 0100644, Hash: blobHash([]byte("b\n")), Stage: 2},
+	})}
+	worktree["a"] = &fstest.MapFile{Data: []byte("a\nA\n")}
+	worktree["b"] = &fstest.MapFile{Data: []byte("x\ny\n")}
+	worktree["bin"] = &fstest.MapFile{Data: []byte("\x00\x02")}
+	b := newNativeBackend(gitDir, worktree, &stubBackend{})
+
+	statusMap, err := b.Status("", emptyTreeHash, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]FileStatus{
+		"a":   {Index: "+2/-0", IndexAddDel: "create", File: "nothing"},
+		"b":   {Index: "+1/-0", IndexAddDel: "create", File: "+2/-1"},
+		"bin": {Index: "binary", IndexAddDel: "create", File: "binary", Binary: true},
+		"u":   {Index: "unchanged", File: "nothing", Unmerged: true},
+	}
+	if len(statusMap) != len(expected) {
+		t.Errorf("Expected %d paths, got %d", len(expected), len(statusMap))
+	}
+	for path, want := range expected {
+		if got := statusMap[path]; got == nil || *got != want {
+			t.Errorf("Status of %s = %+v, expected %+v", path, got, want)
+		}
+	}
+
+	statusMap, err = b.Status("index-only", "HEAD", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := statusMap["a"]; got == nil || got.Index != "+1/-0" || got.IndexAddDel != "" {
+		t.Errorf("Status of a against HEAD = %+v, expected +1/-0", got)
+	}
+	if got := statusMap["b"]; got != nil {
+		t.Errorf("Expected b to be unchanged against HEAD, got %+v", got)
+	}
+}
+
+func TestNativeHead(t *testing.T) {
+	gitDir, worktree := newTestRepository(map[string]string{"a": "a\n"}, nil)
+	b := newNativeBackend(gitDir, worktree, &stubBackend{})
+
+	head, ok := b.Head()
+	if !ok || !isFullHash(head) {
+		t.Fatalf("Head() = %q, %v", head, ok)
+	}
+	if tree, err := b.resolveTree("main"); err != nil || tree == head {
+		t.Errorf("resolveTree(main) = %q, %v", tree, err)
+	}
+
+	gitDir["HEAD"] = &fstest.MapFile{Data: []byte("ref: refs/heads/unborn\n")}
+	if _, ok := b.Head(); ok {
+		t.Error("Expected no HEAD on an unborn branch")
+	}
+}
+
+func TestNativeUntracked(t *testing.T) {
+	gitDir, worktree := newTestRepository(nil, []indexEntry{
+		{Path: "tracked.log", Mode: 0100644, Hash: blobHash(nil)},
+	})
+	gitDir["info/exclude"] = &fstest.MapFile{Data: []byte("*.tmp\n")}
+	for _, name := range []string{"tracked.log", "a.log", "keep.log", "x.tmp", "new.txt",
+		"build/out", "sub/deep/file", "sub/skip.o", "nested/.git/HEAD", ".git/config"} {
+		worktree[name] = &fstest.MapFile{}
+	}
+	worktree[".gitignore"] = &fstest.MapFile{Data: []byte("*.log\n!keep.log\n/build/\n")}
+	worktree["sub/.gitignore"] = &fstest.MapFile{Data: []byte("*.o\n")}
+	b := newNativeBackend(gitDir, worktree, &stubBackend{})
+
+	untracked, err := b.Untracked()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{".gitignore", "keep.log", "nested/", "new.txt", "sub/.gitignore", "sub/deep/file"}
+	if !reflect.DeepEqual(untracked, expected) {
+		t.Errorf("Untracked() = %q, expected %q", untracked, expected)
+	}
+}
+
+func TestNativeFallback(t *testing.T) {
+	gitDir, worktree := newTestRepository(nil, nil)
+	b := newNativeBackend(gitDir, worktree, &stubBackend{})
+
+	for _, args := range [][]string{
+		{"diff-files", "-p", "--binary", "--no-color"},
+		{"diff-index", "-p", "-M", "HEAD", "--no-color"},
+		{"diff-index", "-p", "HEAD~1", "--no-color"},
+		{"diff-files", "--diff-algorithm=histogram", "-p"},
+	} {
+		if _, err := b.Diff(args, nil); err == nil || !strings.HasPrefix(err.Error(), "fallback") {
+			t.Errorf("Expected %v to be left to the fallback, got %v", args, err)
+		}
+	}
+
+	if _, err := b.Diff([]string{"diff-files", "-p"}, []string{":(attr:text)"}); err == nil {
+		t.Error("Expected an attribute pathspec to be left to the fallback")
+	}
+}
+
+func TestNativeConvertedFallback(t *testing.T) {
+	gitDir := fstest.MapFS{}
+	content := addObject(gitDir, "blob", []byte("a\n"))
+	gitDir["index"] = &fstest.MapFile{Data: buildIndex([]indexEntry{
+		{Path: "plain", Mode: 0100644, Hash: content},
+		{Path: "sub/crlf.txt", Mode: 0100644, Hash: content},
+	})}
+	worktree := fstest.MapFS{
+		"plain":              {Data: []byte("b\n")},
+		"sub/crlf.txt":       {Data: []byte("a\r\n")},
+		"sub/.gitattributes": {Data: []byte("*.txt text eol=crlf\n")},
+	}
+	b := newNativeBackend(gitDir, worktree, &stubBackend{})
+
+	if _, err := b.Diff([]string{"diff-files", "-p"}, []string{"plain"}); err != nil {
+		t.Errorf("Expected an unconverted file to be diffed natively, got %v", err)
+	}
+	if _, err := b.Diff([]string{"diff-files", "-p"}, nil); err == nil || !strings.HasPrefix(err.Error(), "fallback") {
+		t.Errorf("Expected a converted file to be left to the fallback, got %v", err)
+	}
+}
+
+func TestQuoteDiffPath(t *testing.T) {
+	tests := []struct {
+		input    string
+		quote    bool
+		expected string
+	}{
+		{"a/plain name", true, "a/plain name"},
+		{"a/tab\there", true, `"a/tab\there"`},
+		{`a/quote"back\`, true, `"a/quote\"back\\"`},
+		{"a/ü", true, `"a/\303\274"`},
+		{"a/ü", false, "a/ü"},
+	}
+
+	for _, test := range tests {
+		if result := quoteDiffPath(test.input, test.quote); result != test.expected {
+			t.Errorf("quoteDiffPath(%q, %v) = %q, expected %q", test.input, test.quote, result, test.expected)
+		}
+		if result := unquotePath(quoteDiffPath(test.input, true)); result != test.input {
+			t.Errorf("unquotePath(quoteDiffPath(%q)) = %q", test.input, result)
+		}
+	}
+}
@@ -0,0 +1,448 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// emptyTreeHash is the tree with no entries, which git knows about without
+// it being stored.
+const emptyTreeHash = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+// emptyBlobHash is the blob of an empty file, which intent-to-add entries
+// point to.
+const emptyBlobHash = "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"
+
+// Pack object types
+const (
+	packCommit   = 1
+	packTree     = 2
+	packBlob     = 3
+	packTag      = 4
+	packOfsDelta = 6
+	packRefDelta = 7
+)
+
+var packTypeNames = map[int]string{
+	packCommit: "commit",
+	packTree:   "tree",
+	packBlob:   "blob",
+	packTag:    "tag",
+}
+
+// objectStore reads loose and packed objects from the git directory.
+type objectStore struct {
+	gitDir fs.FS
+	packs  []*packFile
+	loaded bool
+}
+
+type packFile struct {
+	name      string
+	fanout    [256]uint32
+	hashes    []byte
+	offsets   []byte
+	offsets64 []byte
+	data      io.ReaderAt
+	size      int64
+}
+
+// treeEntry is one file of a flattened tree.
+type treeEntry struct {
+	Mode uint32
+	Hash string
+}
+
+// read returns the type and content of an object.
+func (s *objectStore) read(hash string) (string, []byte, error) {
+	if len(hash) != 40 {
+		return "", nil, fmt.Errorf("bad object name %s", hash)
+	}
+
+	if compressed, err := fs.ReadFile(s.gitDir, path.Join("objects", hash[:2], hash[2:])); err == nil {
+		return parseLooseObject(compressed)
+	}
+
+	if err := s.loadPacks(); err != nil {
+		return "", nil, err
+	}
+	raw, err := hex.DecodeString(hash)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, pack := range s.packs {
+		if offset, ok := pack.find(raw); ok {
+			return s.readPacked(pack, offset, 0)
+		}
+	}
+	return "", nil, fmt.Errorf("object %s not found", hash)
+}
+
+// readType is read for an object that must have the given type.
+func (s *objectStore) readType(hash, objType string) ([]byte, error) {
+	actual, data, err := s.read(hash)
+	if err != nil {
+		return nil, err
+	}
+	if actual != objType {
+		return nil, fmt.Errorf("object %s is a %s, not a %s", hash, actual, objType)
+	}
+	return data, nil
+}
+
+func parseLooseObject(compressed []byte) (string, []byte, error) {
+	reader, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return "", nil, err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return "", nil, err
+	}
+
+	nul := bytes.IndexByte(data, 0)
+	if nul == -1 {
+		return "", nil, fmt.Errorf("bad loose object header")
+	}
+	header := strings.SplitN(string(data[:nul]), " ", 2)
+	if len(header) != 2 {
+		return "", nil, fmt.Errorf("bad loose object header")
+	}
+	size, err := strconv.Atoi(header[1])
+	if err != nil || size != len(data)-nul-1 {
+		return "", nil, fmt.Errorf("bad loose object size")
+	}
+	return header[0], data[nul+1:], nil
+}
+
+func (s *objectStore) loadPacks() error {
+	if s.loaded {
+		return nil
+	}
+	s.loaded = true
+
+	entries, err := fs.ReadDir(s.gitDir, "objects/pack")
+	if err != nil {
+		return nil
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".idx") {
+			continue
+		}
+		base := path.Join("objects/pack", strings.TrimSuffix(entry.Name(), ".idx"))
+		pack, err := openPack(s.gitDir, base)
+		if err != nil {
+			return err
+		}
+		s.packs = append(s.packs, pack)
+	}
+	return nil
+}
+
+// openPack reads a version 2 pack index and opens the pack beside it.
+func openPack(gitDir fs.FS, base string) (*packFile, error) {
+	idx, err := fs.ReadFile(gitDir, base+".idx")
+	if err != nil {
+		return nil, err
+	}
+	if len(idx) < 8+256*4 || !bytes.Equal(idx[:4], []byte{0xff, 't', 'O', 'c'}) ||
+		binary.BigEndian.Uint32(idx[4:8]) != 2 {
+		return nil, fmt.Errorf("%s.idx: unsupported pack index", base)
+	}
+
+	pack := &packFile{name: base + ".pack"}
+	for i := range pack.fanout {
+		pack.fanout[i] = binary.BigEndian.Uint32(idx[8+i*4:])
+	}
+	count := int(pack.fanout[255])
+	pos := 8 + 256*4
+	if len(idx) < pos+count*(20+4+4) {
+		return nil, fmt.Errorf("%s.idx: truncated pack index", base)
+	}
+	pack.hashes = idx[pos : pos+count*20]
+	pos += count * 20
+	pos += count * 4 // CRCs
+	pack.offsets = idx[pos : pos+count*4]
+	pos += count * 4
+	pack.offsets64 = idx[pos:]
+
+	file, err := gitDir.Open(pack.name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	pack.size = info.Size()
+	if readerAt, ok := file.(io.ReaderAt); ok {
+		pack.data = readerAt
+	} else {
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		pack.data = bytes.NewReader(data)
+	}
+	return pack, nil
+}
+
+// find looks up the offset of an object in the pack.
+func (p *packFile) find(hash []byte) (int64, bool) {
+	lo := 0
+	if hash[0] > 0 {
+		lo = int(p.fanout[hash[0]-1])
+	}
+	hi := int(p.fanout[hash[0]])
+
+	ix := lo + sort.Search(hi-lo, func(i int) bool {
+		return bytes.Compare(p.hashes[(lo+i)*20:(lo+i+1)*20], hash) >= 0
+	})
+	if ix >= hi || !bytes.Equal(p.hashes[ix*20:(ix+1)*20], hash) {
+		return 0, false
+	}
+
+	offset := binary.BigEndian.Uint32(p.offsets[ix*4:])
+	if offset&0x80000000 == 0 {
+		return int64(offset), true
+	}
+	large := int(offset&0x7fffffff) * 8
+	if large+8 > len(p.offsets64) {
+		return 0, false
+	}
+	return int64(binary.BigEndian.Uint64(p.offsets64[large:])), true
+}
+
+// maxDeltaDepth bounds delta chains, which git itself limits to 4095.
+const maxDeltaDepth = 10000
+
+func (s *objectStore) readPacked(pack *packFile, offset int64, depth int) (string, []byte, error) {
+	if depth > maxDeltaDepth {
+		return "", nil, fmt.Errorf("%s: delta chain too long", pack.name)
+	}
+
+	header := make([]byte, 32)
+	n, readErr := pack.data.ReadAt(header, offset)
+	if n == 0 {
+		return "", nil, fmt.Errorf("%s: cannot read object at %d: %v", pack.name, offset, readErr)
+	}
+	header = header[:n]
+
+	c := header[0]
+	objType := int(c>>4) & 7
+	size := int(c & 0x0f)
+	pos, shift := 1, 4
+	for c&0x80 != 0 {
+		if pos >= len(header) {
+			return "", nil, fmt.Errorf("%s: bad object header at %d", pack.name, offset)
+		}
+		c = header[pos]
+		pos++
+		size |= int(c&0x7f) << shift
+		shift += 7
+	}
+
+	var baseType string
+	var base []byte
+	var err error
+	switch objType {
+	case packOfsDelta:
+		distance, n := readOffsetVarint(header[pos:])
+		if n == 0 || int64(distance) > offset {
+			return "", nil, fmt.Errorf("%s: bad delta base at %d", pack.name, offset)
+		}
+		pos += n
+		baseType, base, err = s.readPacked(pack, offset-int64(distance), depth+1)
+	case packRefDelta:
+		if pos+20 > len(header) {
+			return "", nil, fmt.Errorf("%s: bad delta base at %d", pack.name, offset)
+		}
+		baseHash := hex.EncodeToString(header[pos : pos+20])
+		pos += 20
+		baseType, base, err = s.read(baseHash)
+	default:
+		if packTypeNames[objType] == "" {
+			return "", nil, fmt.Errorf("%s: unknown object type %d at %d", pack.name, objType, offset)
+		}
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	reader, err := zlib.NewReader(io.NewSectionReader(pack.data, offset+int64(pos), pack.size-offset-int64(pos)))
+	if err != nil {
+		return "", nil, err
+	}
+	defer reader.Close()
+	data := make([]byte, size)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return "", nil, fmt.Errorf("%s: cannot inflate object at %d: %v", pack.name, offset, err)
+	}
+
+	if objType != packOfsDelta && objType != packRefDelta {
+		return packTypeNames[objType], data, nil
+	}
+	result, err := applyDelta(base, data)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: object at %d: %v", pack.name, offset, err)
+	}
+	return baseType, result, nil
+}
+
+// applyDelta rebuilds an object from its base and a pack delta.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	baseSize, n := readSizeVarint(delta)
+	if n == 0 || baseSize != len(base) {
+		return nil, fmt.Errorf("delta base size mismatch")
+	}
+	delta = delta[n:]
+	resultSize, n := readSizeVarint(delta)
+	if n == 0 {
+		return nil, fmt.Errorf("bad delta header")
+	}
+	delta = delta[n:]
+
+	result := make([]byte, 0, resultSize)
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+
+		if op&0x80 == 0 {
+			if op == 0 || int(op) > len(delta) {
+				return nil, fmt.Errorf("bad delta insert")
+			}
+			result = append(result, delta[:op]...)
+			delta = delta[op:]
+			continue
+		}
+
+		var offset, size int
+		for i := uint(0); i < 7; i++ {
+			if op&(1<<i) == 0 {
+				continue
+			}
+			if len(delta) == 0 {
+				return nil, fmt.Errorf("truncated delta copy")
+			}
+			if i < 4 {
+				offset |= int(delta[0]) << (8 * i)
+			} else {
+				size |= int(delta[0]) << (8 * (i - 4))
+			}
+			delta = delta[1:]
+		}
+		if size == 0 {
+			size = 0x10000
+		}
+		if offset+size > len(base) {
+			return nil, fmt.Errorf("delta copy out of range")
+		}
+		result = append(result, base[offset:offset+size]...)
+	}
+
+	if len(result) != resultSize {
+		return nil, fmt.Errorf("delta result size mismatch")
+	}
+	return result, nil
+}
+
+// readSizeVarint decodes the little-endian base-128 sizes of a delta header.
+func readSizeVarint(data []byte) (int, int) {
+	value, shift := 0, uint(0)
+	for i, c := range data {
+		value |= int(c&0x7f) << shift
+		shift += 7
+		if c&0x80 == 0 {
+			return value, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// treeOf returns the tree of a commit, tag or tree.
+func (s *objectStore) treeOf(hash string) (string, error) {
+	for depth := 0; depth < 10; depth++ {
+		if hash == emptyTreeHash {
+			return hash, nil
+		}
+		objType, data, err := s.read(hash)
+		if err != nil {
+			return "", err
+		}
+
+		switch objType {
+		case "tree":
+			return hash, nil
+		case "commit":
+			return headerField(data, "tree")
+		case "tag":
+			if hash, err = headerField(data, "object"); err != nil {
+				return "", err
+			}
+		default:
+			return "", fmt.Errorf("%s is a %s, not a tree", hash, objType)
+		}
+	}
+	return "", fmt.Errorf("too many tags around %s", hash)
+}
+
+// headerField returns a header of a commit or tag object.
+func headerField(data []byte, name string) (string, error) {
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, name+" ") {
+			return strings.TrimPrefix(line, name+" "), nil
+		}
+	}
+	return "", fmt.Errorf("object has no %s", name)
+}
+
+// flattenTree lists every file below a tree by its full path.
+func (s *objectStore) flattenTree(hash, prefix string, files map[string]treeEntry) error {
+	if hash == emptyTreeHash {
+		return nil
+	}
+	data, err := s.readType(hash, "tree")
+	if err != nil {
+		return err
+	}
+
+	for len(data) > 0 {
+		space := bytes.IndexByte(data, ' ')
+		nul := bytes.IndexByte(data, 0)
+		if space == -1 || nul < space || nul+21 > len(data) {
+			return fmt.Errorf("bad tree %s", hash)
+		}
+		mode, err := strconv.ParseUint(string(data[:space]), 8, 32)
+		if err != nil {
+			return fmt.Errorf("bad tree %s", hash)
+		}
+		name := prefix + string(data[space+1:nul])
+		entryHash := hex.EncodeToString(data[nul+1 : nul+21])
+		data = data[nul+21:]
+
+		if mode&0170000 == 0040000 {
+			if err := s.flattenTree(entryHash, name+"/", files); err != nil {
+				return err
+			}
+			continue
+		}
+		files[name] = treeEntry{Mode: uint32(mode), Hash: entryHash}
+	}
+	return nil
+}
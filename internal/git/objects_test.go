@@ -0,0 +1,160 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"testing"
+	"testing/fstest"
+)
+
+func TestApplyDelta(t *testing.T) {
+	base := []byte("hello world")
+	// sizes 11 -> 12, copy "hello " (offset 0, size 6), insert "there!"
+	delta := []byte{11, 12, 0x90, 6, 6, 't', 'h', 'e', 'r', 'e', '!'}
+
+	result, err := applyDelta(base, delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result) != "hello there!" {
+		t.Errorf("applyDelta() = %q, expected %q", result, "hello there!")
+	}
+
+	if _, err := applyDelta([]byte("short"), delta); err == nil {
+		t.Error("Expected a base size mismatch")
+	}
+	if _, err := applyDelta(base, []byte{11, 12, 0x91, 8, 6}); err == nil {
+		t.Error("Expected a copy out of range")
+	}
+}
+
+func TestReadLooseObjects(t *testing.T) {
+	gitDir := fstest.MapFS{}
+	blob := addObject(gitDir, "blob", []byte("content\n"))
+	tree := addTree(gitDir, map[string]treeEntry{
+		"top":       {Mode: 0100644, Hash: blob},
+		"dir/inner": {Mode: 0100755, Hash: blob},
+	})
+	commit := addObject(gitDir, "commit", []byte("tree "+tree+"\n\nmessage\n"))
+	tag := addObject(gitDir, "tag", []byte("object "+commit+"\ntype commit\n\ntag\n"))
+	store := &objectStore{gitDir: gitDir}
+
+	objType, data, err := store.read(blob)
+	if err != nil || objType != "blob" || string(data) != "content\n" {
+		t.Errorf("read(blob) = %q, %q, %v", objType, data, err)
+	}
+
+	for _, name := range []string{tree, commit, tag} {
+		if resolved, err := store.treeOf(name); err != nil || resolved != tree {
+			t.Errorf("treeOf(%s) = %s, %v; expected %s", name, resolved, err, tree)
+		}
+	}
+	if resolved, err := store.treeOf(emptyTreeHash); err != nil || resolved != emptyTreeHash {
+		t.Errorf("treeOf(empty tree) = %s, %v", resolved, err)
+	}
+
+	files := make(map[string]treeEntry)
+	if err := store.flattenTree(tree, "", files); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]treeEntry{
+		"top":       {Mode: 0100644, Hash: blob},
+		"dir/inner": {Mode: 0100755, Hash: blob},
+	}
+	if fmt.Sprint(files) != fmt.Sprint(expected) {
+		t.Errorf("flattenTree() = %v, expected %v", files, expected)
+	}
+
+	if _, _, err := store.read(nullHash); err == nil {
+		t.Error("Expected an error for a missing object")
+	}
+}
+
+// buildPack writes a pack with a blob and an offset delta against it,
+// together with its version 2 index.
+func buildPack(gitDir fstest.MapFS, base, delta, result []byte) (string, string) {
+	objectHash := func(content []byte) string {
+		sum := sha1.Sum(append([]byte(fmt.Sprintf("blob %d\x00", len(content))), content...))
+		return hex.EncodeToString(sum[:])
+	}
+	compress := func(data []byte) []byte {
+		var buf bytes.Buffer
+		w := zlib.NewWriter(&buf)
+		w.Write(data)
+		w.Close()
+		return buf.Bytes()
+	}
+
+	var pack []byte
+	pack = append(pack, "PACK"...)
+	pack = binary.BigEndian.AppendUint32(pack, 2)
+	pack = binary.BigEndian.AppendUint32(pack, 2)
+
+	baseOffset := len(pack)
+	pack = append(pack, byte(packBlob<<4|len(base)))
+	pack = append(pack, compress(base)...)
+
+	deltaOffset := len(pack)
+	pack = append(pack, byte(packOfsDelta<<4|len(delta)))
+	pack = append(pack, byte(deltaOffset-baseOffset))
+	pack = append(pack, compress(delta)...)
+
+	type object struct {
+		hash   string
+		offset int
+	}
+	objects := []object{{objectHash(base), baseOffset}, {objectHash(result), deltaOffset}}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].hash < objects[j].hash })
+
+	var idx []byte
+	idx = append(idx, 0xff, 't', 'O', 'c')
+	idx = binary.BigEndian.AppendUint32(idx, 2)
+	for i := 0; i < 256; i++ {
+		count := 0
+		for _, obj := range objects {
+			first, _ := hex.DecodeString(obj.hash[:2])
+			if int(first[0]) <= i {
+				count++
+			}
+		}
+		idx = binary.BigEndian.AppendUint32(idx, uint32(count))
+	}
+	for _, obj := range objects {
+		raw, _ := hex.DecodeString(obj.hash)
+		idx = append(idx, raw...)
+	}
+	idx = append(idx, make([]byte, 4*len(objects))...)
+	for _, obj := range objects {
+		idx = binary.BigEndian.AppendUint32(idx, uint32(obj.offset))
+	}
+
+	gitDir["objects/pack/pack-test.pack"] = &fstest.MapFile{Data: pack}
+	gitDir["objects/pack/pack-test.idx"] = &fstest.MapFile{Data: idx}
+	return objectHash(base), objectHash(result)
+}
+
+func TestReadPackedObjects(t *testing.T) {
+	gitDir := fstest.MapFS{}
+	baseHash, resultHash := buildPack(gitDir, []byte("hello world"),
+		[]byte{11, 12, 0x90, 6, 6, 't', 'h', 'e', 'r', 'e', '!'}, []byte("hello there!"))
+	store := &objectStore{gitDir: gitDir}
+
+	objType, data, err := store.read(baseHash)
+	if err != nil || objType != "blob" || string(data) != "hello world" {
+		t.Errorf("read(base) = %q, %q, %v", objType, data, err)
+	}
+
+	objType, data, err = store.read(resultHash)
+	if err != nil || objType != "blob" || string(data) != "hello there!" {
+		t.Errorf("read(delta) = %q, %q, %v", objType, data, err)
+	}
+
+	if _, _, err := store.read(emptyTreeHash); err == nil {
+		t.Error("Expected an error for an object in neither the pack nor loose")
+	}
+}
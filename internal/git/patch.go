@@ -2,6 +2,8 @@ package git
 
 import (
 	"fmt"
+	"os"
+	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
@@ -91,6 +93,17 @@ var PatchModes = map[string]PatchMode{
 	},
 }
 
+// UpdatesIndex reports whether applying a patch in this mode changes the
+// index.
+func (m PatchMode) UpdatesIndex() bool {
+	for _, arg := range m.ApplyCmd {
+		if arg == "--cached" || arg == "--index" {
+			return true
+		}
+	}
+	return false
+}
+
 type HunkType string
 
 const (
@@ -99,8 +112,15 @@ const (
 	HunkTypeMode     HunkType = "mode"
 	HunkTypeDeletion HunkType = "deletion"
 	HunkTypeAddition HunkType = "addition"
+	HunkTypeBinary   HunkType = "binary"
+	HunkTypeRename   HunkType = "rename"
+	HunkTypeCopy     HunkType = "copy"
 )
 
+var modeLineRe = regexp.MustCompile(`^(old|new) mode [0-7]+$`)
+
+var renameLineRe = regexp.MustCompile(`^((dis)?similarity index \d+%|(rename|copy) (from|to) .*)$`)
+
 type Hunk struct {
 	Text     []string
 	Display  []string
@@ -115,61 +135,209 @@ type Hunk struct {
 }
 
 func (r *Repository) ParseDiff(path string, mode PatchMode, revision string) ([]Hunk, error) {
-	var diffCmd []string
-	diffCmd = append(diffCmd, mode.DiffCmd...)
+	return r.ParseFileDiff(FileStatus{Path: path}, mode, revision)
+}
 
-	if diffAlgo, err := r.GetConfig("diff.algorithm"); err == nil && diffAlgo != "" {
-		diffCmd = append([]string{diffCmd[0], "--diff-algorithm=" + diffAlgo}, diffCmd[1:]...)
+// ParseFileDiff is like ParseDiff, but diffs a renamed or copied file against
+// its source so the rename can be selected together with the content.
+func (r *Repository) ParseFileDiff(file FileStatus, mode PatchMode, revision string) ([]Hunk, error) {
+	paths := []string{file.Path}
+	var extraArgs []string
+	if file.OldPath != "" {
+		paths = []string{file.OldPath, file.Path}
+		if file.Copy {
+			extraArgs = append(extraArgs, "-C", "--find-copies-harder")
+		} else {
+			extraArgs = append(extraArgs, "-M")
+		}
 	}
 
-	if revision != "" {
-		reference := revision
-		if r.IsInitialCommit() && revision == "HEAD" {
-			emptyTree, err := r.GetEmptyTree()
+	diffCmd, err := r.diffArgs(mode, revision, append(extraArgs, "--no-color")...)
+	if err != nil {
+		return nil, err
+	}
+
+	diffLines, err := r.Backend().Diff(diffCmd, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	// Binary changes can only be applied from a full-index binary diff
+	if isBinaryDiff(diffLines) {
+		extraArgs = append(extraArgs, "--binary")
+		diffCmd, err = r.diffArgs(mode, revision, append(extraArgs, "--no-color")...)
+		if err != nil {
+			return nil, err
+		}
+		diffLines, err = r.Backend().Diff(diffCmd, paths)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var coloredLines []string
+	colored, filtered := r.GetColorBool("color.diff"), false
+	if colored {
+		colorCmd, err := r.diffArgs(mode, revision, append(extraArgs, "--color=always")...)
+		if err != nil {
+			return nil, err
+		}
+		coloredLines, _ = r.Backend().Diff(colorCmd, paths)
+
+		if filter, err := r.GetConfig("interactive.difffilter"); err == nil && filter != "" && len(coloredLines) > 0 {
+			coloredLines, err = runDiffFilter(filter, r.workTree, coloredLines)
 			if err != nil {
 				return nil, err
 			}
-			reference = emptyTree
+			filtered = true
 		}
-		diffCmd = append(diffCmd, reference)
 	}
 
-	diffCmd = append(diffCmd, "--no-color", "--", path)
+	if len(coloredLines) == 0 {
+		coloredLines = diffLines
+	}
 
-	diffLines, err := r.RunCommandLines(diffCmd...)
+	// With both paths in the pathspec the source may show up with its own
+	// changes as well; only the section for the file itself is wanted.
+	if len(paths) > 1 {
+		start, end := fileDiffSection(diffLines, file.Path)
+		diffLines = diffLines[start:end]
+		if end <= len(coloredLines) {
+			coloredLines = coloredLines[start:end]
+		} else {
+			coloredLines = diffLines
+		}
+	}
+
+	hunks, err := r.parseHunks(diffLines, coloredLines)
 	if err != nil {
 		return nil, err
 	}
 
-	var coloredLines []string
-	if r.GetColorBool("color.diff") {
-		colorCmd := append([]string{}, mode.DiffCmd...)
+	// Only a file added with intent-to-add is new in the diff of the index
+	// against the worktree
+	if mode.DiffCmd[0] == "diff-files" && !mode.IsReverse {
+		hunks = intentToAddHunks(hunks)
+	}
 
-		if diffAlgo, err := r.GetConfig("diff.algorithm"); err == nil && diffAlgo != "" {
-			colorCmd = append([]string{colorCmd[0], "--diff-algorithm=" + diffAlgo}, colorCmd[1:]...)
+	// The display of a filter cannot be recolored line by line
+	if colored && !filtered {
+		if wordRe := r.wordDiffRe(); wordRe != nil {
+			highlightWords(hunks, wordRe, r.wordColors())
 		}
+	}
+	return hunks, nil
+}
 
-		if revision != "" {
-			reference := revision
-			if r.IsInitialCommit() && revision == "HEAD" {
-				emptyTree, err := r.GetEmptyTree()
-				if err != nil {
-					return nil, err
-				}
-				reference = emptyTree
-			}
-			colorCmd = append(colorCmd, reference)
+// runDiffFilter pipes the colored diff lines through the interactive.diffFilter
+// command. The display is matched to the diff line by line, so the filter
+// has to keep the number of lines.
+func runDiffFilter(filter, dir string, lines []string) ([]string, error) {
+	cmd := exec.Command("sh", "-c", filter)
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(strings.Join(lines, "\n") + "\n")
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("could not run interactive.diffFilter '%s': %v", filter, err)
+	}
+
+	filtered := strings.Split(strings.TrimSuffix(string(output), "\n"), "\n")
+	if len(filtered) != len(lines) {
+		return nil, fmt.Errorf("mismatched output from interactive.diffFilter")
+	}
+	return filtered, nil
+}
+
+// intentToAddHunks turns the addition of a file added with intent-to-add
+// back into content hunks below a header that creates the file, so that
+// part of the new file can be staged. Binary and empty files stay whole.
+func intentToAddHunks(hunks []Hunk) []Hunk {
+	last := len(hunks) - 1
+	if last < 1 || hunks[last].Type != HunkTypeAddition || len(hunks[last].Text) < 2 || !strings.HasPrefix(hunks[last].Text[1], "@@ ") {
+		return hunks
+	}
+	addition := hunks[last]
+
+	header := Hunk{Type: HunkTypeHeader}
+	header.Text = append([]string{hunks[0].Text[0], addition.Text[0]}, hunks[0].Text[1:]...)
+	header.Display = append([]string{hunks[0].Display[0], addition.Display[0]}, hunks[0].Display[1:]...)
+
+	var content []Hunk
+	for i, line := range addition.Text[1:] {
+		if strings.HasPrefix(line, "@@ ") {
+			content = append(content, Hunk{Type: HunkTypeHunk})
+		}
+		hunk := &content[len(content)-1]
+		hunk.Text = append(hunk.Text, line)
+		hunk.Display = append(hunk.Display, addition.Display[i+1])
+	}
+	for i := range content {
+		parseHunkRanges(&content[i])
+	}
+
+	result := append([]Hunk{header}, hunks[1:last]...)
+	return append(result, content...)
+}
+
+// fileDiffSection returns the line range of the diff section whose
+// destination is path.
+func fileDiffSection(diffLines []string, path string) (int, int) {
+	start, end := 0, len(diffLines)
+	found := false
+
+	for i, line := range diffLines {
+		if !strings.HasPrefix(line, "diff --git ") {
+			continue
+		}
+		if found {
+			end = i
+			break
 		}
+		start = i
+		found = strings.HasSuffix(line, " b/"+path)
+	}
 
-		colorCmd = append(colorCmd, "--color=always", "--", path)
-		coloredLines, _ = r.RunCommandLines(colorCmd...)
+	if !found {
+		return 0, len(diffLines)
 	}
+	return start, end
+}
 
-	if len(coloredLines) == 0 {
-		coloredLines = diffLines
+// diffArgs builds the diff command of a patch mode, without the pathspec.
+func (r *Repository) diffArgs(mode PatchMode, revision string, options ...string) ([]string, error) {
+	var diffCmd []string
+	diffCmd = append(diffCmd, mode.DiffCmd...)
+
+	if diffAlgo, err := r.GetConfig("diff.algorithm"); err == nil && diffAlgo != "" {
+		diffCmd = append([]string{diffCmd[0], "--diff-algorithm=" + diffAlgo}, diffCmd[1:]...)
 	}
 
-	return r.parseHunks(diffLines, coloredLines)
+	if revision != "" {
+		reference := revision
+		if r.IsInitialCommit() && revision == "HEAD" {
+			emptyTree, err := r.GetEmptyTree()
+			if err != nil {
+				return nil, err
+			}
+			reference = emptyTree
+		}
+		diffCmd = append(diffCmd, reference)
+	}
+
+	return append(diffCmd, options...), nil
+}
+
+func isBinaryDiff(diffLines []string) bool {
+	for _, line := range diffLines {
+		if strings.HasPrefix(line, "@@ ") {
+			return false
+		}
+		if strings.HasPrefix(line, "Binary files ") || line == "GIT binary patch" {
+			return true
+		}
+	}
+	return false
 }
 
 func (r *Repository) parseHunks(diffLines, coloredLines []string) ([]Hunk, error) {
@@ -213,15 +381,120 @@ func (r *Repository) parseHunks(diffLines, coloredLines []string) ([]Hunk, error
 		}
 	}
 
-	return hunks, nil
+	if len(hunks) == 0 || hunks[0].Type != HunkTypeHeader {
+		return hunks, nil
+	}
+
+	return splitDiffHeader(hunks), nil
+}
+
+// splitDiffHeader moves renames, copies, mode changes, file creation and file
+// deletion out of the diff header into hunks of their own so they can be
+// selected like any other hunk. A deletion or addition always covers the
+// whole file, so the content hunks are folded into it. Binary patches become
+// a single binary hunk that is accepted or rejected as a whole; only its
+// text holds the patch, its display is the one line git diff shows without
+// --binary.
+func splitDiffHeader(hunks []Hunk) []Hunk {
+	header := Hunk{Type: HunkTypeHeader, Text: []string{}, Display: []string{}}
+	rename := Hunk{Type: HunkTypeRename, Text: []string{}, Display: []string{}}
+	mode := Hunk{Type: HunkTypeMode, Text: []string{}, Display: []string{}}
+	binary := Hunk{Type: HunkTypeBinary, Text: []string{}, Display: []string{}}
+	var wholeFile *Hunk
+
+	src := hunks[0]
+	oldPath, newPath := "a/"+diffSectionPath(src.Text[0]), "b/"+diffSectionPath(src.Text[0])
+	for i, line := range src.Text {
+		displayLine := line
+		if i < len(src.Display) {
+			displayLine = src.Display[i]
+		}
+
+		switch {
+		case len(binary.Text) > 0 || line == "GIT binary patch" || strings.HasPrefix(line, "Binary files "):
+			// Everything from the binary marker on is the binary patch itself
+			binary.Text = append(binary.Text, line)
+			if len(binary.Display) == 0 {
+				if line == "GIT binary patch" {
+					displayLine = fmt.Sprintf("Binary files %s and %s differ", oldPath, newPath)
+				}
+				binary.Display = append(binary.Display, displayLine)
+			}
+		case renameLineRe.MatchString(line):
+			if strings.HasPrefix(line, "copy ") {
+				rename.Type = HunkTypeCopy
+			}
+			if from, ok := strings.CutPrefix(line, "rename from "); ok {
+				oldPath = "a/" + unquotePath(from)
+			} else if from, ok := strings.CutPrefix(line, "copy from "); ok {
+				oldPath = "a/" + unquotePath(from)
+			}
+			rename.Text = append(rename.Text, line)
+			rename.Display = append(rename.Display, displayLine)
+		case modeLineRe.MatchString(line):
+			mode.Text = append(mode.Text, line)
+			mode.Display = append(mode.Display, displayLine)
+		case strings.HasPrefix(line, "deleted file mode "):
+			wholeFile = &Hunk{Type: HunkTypeDeletion, Text: []string{line}, Display: []string{displayLine}}
+			newPath = "/dev/null"
+		case strings.HasPrefix(line, "new file mode "):
+			wholeFile = &Hunk{Type: HunkTypeAddition, Text: []string{line}, Display: []string{displayLine}}
+			oldPath = "/dev/null"
+		default:
+			header.Text = append(header.Text, line)
+			header.Display = append(header.Display, displayLine)
+		}
+	}
+
+	result := []Hunk{header}
+	if len(rename.Text) > 0 {
+		result = append(result, rename)
+	}
+	if len(mode.Text) > 0 {
+		result = append(result, mode)
+	}
+
+	if wholeFile == nil {
+		if len(binary.Text) > 0 {
+			result = append(result, binary)
+		}
+		return append(result, hunks[1:]...)
+	}
+
+	if len(binary.Text) > 0 {
+		wholeFile.Text = append(wholeFile.Text, binary.Text...)
+		wholeFile.Display = append(wholeFile.Display, binary.Display...)
+	}
+
+	for i, hunk := range hunks[1:] {
+		if i == 0 {
+			wholeFile.OldLine, wholeFile.OldCnt = hunk.OldLine, hunk.OldCnt
+			wholeFile.NewLine, wholeFile.NewCnt = hunk.NewLine, hunk.NewCnt
+		}
+		wholeFile.Text = append(wholeFile.Text, hunk.Text...)
+		wholeFile.Display = append(wholeFile.Display, hunk.Display...)
+	}
+
+	return append(result, *wholeFile)
+}
+
+// ParseHunkHeader sets the line ranges of a hunk from its "@@" line.
+func (r *Repository) ParseHunkHeader(hunk *Hunk) error {
+	return r.parseHunkHeader(hunk)
 }
 
 func (r *Repository) parseHunkHeader(hunk *Hunk) error {
+	return parseHunkRanges(hunk)
+}
+
+var hunkHeaderRe = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// parseHunkRanges sets the line ranges of a hunk from its "@@" line.
+func parseHunkRanges(hunk *Hunk) error {
 	if len(hunk.Text) == 0 {
 		return fmt.Errorf("empty hunk")
 	}
 
-	hunkHeaderRe := regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
 	matches := hunkHeaderRe.FindStringSubmatch(hunk.Text[0])
 	if len(matches) < 4 {
 		return fmt.Errorf("invalid hunk header: %s", hunk.Text[0])
@@ -247,156 +520,369 @@ func (r *Repository) parseHunkHeader(hunk *Hunk) error {
 	return nil
 }
 
-func (r *Repository) ApplyPatch(patch []byte, mode PatchMode) error {
-	cmd := append(mode.ApplyCmd, "--allow-overlap")
-	return r.RunCommandWithStdin(patch, cmd...)
+// ApplyPatch applies a patch made of hunks from ParseDiff to the index or
+// the worktree, as mode says, without running git apply, and returns the
+// hunks that had to be applied away from where their headers placed them.
+// A patch that does not apply is reported with an *ApplyError; only when
+// interactive.applyFallback is set is it handed to git apply instead.
+func (r *Repository) ApplyPatch(patch []byte, mode PatchMode) ([]HunkOffset, error) {
+	defer r.Backend().Invalidate(patchPaths(patch)...)
+	offsets, err := r.applyBuiltin(patch, mode, false)
+	if err != nil {
+		if !r.GetConfigBool("interactive.applyfallback") {
+			return nil, err
+		}
+		cmd := append(mode.ApplyCmd, "--allow-overlap")
+		return nil, r.Backend().Apply(cmd, patch)
+	}
+	return offsets, nil
 }
 
-func (r *Repository) CheckPatch(patch []byte, mode PatchMode) error {
-	cmd := append(mode.CheckCmd, "--allow-overlap")
-	return r.RunCommandWithStdin(patch, cmd...)
+// patchPaths returns the paths named by the "diff --git" lines of a patch.
+func patchPaths(patch []byte) []string {
+	var paths []string
+	for _, line := range strings.Split(string(patch), "\n") {
+		if strings.HasPrefix(line, "diff --git ") {
+			paths = append(paths, diffSectionPath(line))
+		}
+	}
+	return paths
 }
 
-func (r *Repository) HunkSplittable(hunk *Hunk) bool {
-	if hunk.Type != HunkTypeHunk {
-		return false
+// CheckPatch reports whether ApplyPatch would apply patch.
+func (r *Repository) CheckPatch(patch []byte, mode PatchMode) error {
+	if _, err := r.applyBuiltin(patch, mode, true); err != nil {
+		if !r.GetConfigBool("interactive.applyfallback") {
+			return err
+		}
+		cmd := append(mode.CheckCmd, "--allow-overlap")
+		return r.Backend().Apply(cmd, patch)
 	}
-
-	splits := r.splitHunkInternal(hunk)
-	return len(splits) > 1
+	return nil
 }
 
-func (r *Repository) SplitHunk(hunk *Hunk) []Hunk {
-	return r.splitHunkInternal(hunk)
+// HunkSplittable reports whether context lines separate the changes of hunk.
+func (r *Repository) HunkSplittable(hunk *Hunk) bool {
+	return hunk.Type == HunkTypeHunk && len(r.SplitHunk(hunk)) > 1
 }
 
-func (r *Repository) splitHunkInternal(hunk *Hunk) []Hunk {
+// SplitHunk splits hunk into all of the smallest hunks it is made of, as
+// upstream split_hunk does: each run of changes becomes a piece with the
+// context around it, so the context between two runs ends one piece and
+// starts the next. The new side of each piece is numbered as if the pieces
+// before it were applied.
+func (r *Repository) SplitHunk(hunk *Hunk) []Hunk {
 	if hunk.Type != HunkTypeHunk {
 		return []Hunk{*hunk}
 	}
 
-	// Find the first context line after changes that has more changes after it
-	var splitPoint int = -1
-	addDel := false
+	var pieces []Hunk
+	piece := Hunk{Type: HunkTypeHunk, Dirty: hunk.Dirty, OldLine: hunk.OldLine, NewLine: hunk.NewLine}
+	changed := false
+	trailing := -1 // Where the context after the changes of piece starts
+	postContext := 0
 
-	for i := 1; i < len(hunk.Text); i++ {
+	add := func(i int) {
 		line := hunk.Text[i]
-		if strings.HasPrefix(line, "\\") {
-			continue
+		display := line
+		if i < len(hunk.Display) {
+			display = hunk.Display[i]
 		}
+		piece.Text = append(piece.Text, line)
+		piece.Display = append(piece.Display, display)
+
+		switch {
+		case strings.HasPrefix(line, "\\"):
+		case strings.HasPrefix(line, "-"):
+			piece.OldCnt++
+		case strings.HasPrefix(line, "+"):
+			piece.NewCnt++
+		default:
+			piece.OldCnt++
+			piece.NewCnt++
+		}
+	}
 
-		if strings.HasPrefix(line, " ") {
-			// Context line
-			if addDel {
-				// Check if there are more changes after this context line
-				hasMoreChanges := false
-				for j := i + 1; j < len(hunk.Text); j++ {
-					nextLine := hunk.Text[j]
-					if strings.HasPrefix(nextLine, "+") || strings.HasPrefix(nextLine, "-") {
-						hasMoreChanges = true
-						break
-					}
+	for i := 1; i < len(hunk.Text); i++ {
+		line := hunk.Text[i]
+		switch {
+		case strings.HasPrefix(line, "\\"):
+		case strings.HasPrefix(line, "-"), strings.HasPrefix(line, "+"):
+			if trailing >= 0 {
+				// The context since the last change leads into the next piece
+				pieces = append(pieces, piece)
+				piece = Hunk{
+					Type:    HunkTypeHunk,
+					Dirty:   hunk.Dirty,
+					OldLine: piece.OldLine + piece.OldCnt - postContext,
+					NewLine: piece.NewLine + piece.NewCnt - postContext,
 				}
-				if hasMoreChanges {
-					splitPoint = i
-					break
+				for j := trailing; j < i; j++ {
+					add(j)
 				}
+				trailing, postContext = -1, 0
+			}
+			changed = true
+		default:
+			if changed && trailing < 0 {
+				trailing = i
+			}
+			if trailing >= 0 {
+				postContext++
 			}
-		} else if strings.HasPrefix(line, "+") || strings.HasPrefix(line, "-") {
-			addDel = true
 		}
+		add(i)
 	}
+	pieces = append(pieces, piece)
 
-	if splitPoint == -1 {
+	if len(pieces) == 1 {
 		return []Hunk{*hunk}
 	}
+	for i := range pieces {
+		r.updateHunkHeader(&pieces[i])
+		r.colorHunkHeader(hunk, &pieces[i])
+	}
+	return pieces
+}
 
-	// Create exactly 2 splits like Perl version
-	var splits []Hunk
+func (r *Repository) updateHunkHeader(hunk *Hunk) {
+	header := formatHunkHeader(hunk)
 
-	// Split 1: from start to splitPoint (inclusive)
-	split1 := Hunk{
-		Type:    HunkTypeHunk,
-		Text:    []string{},
-		Display: []string{},
-		OldLine: hunk.OldLine,
-		NewLine: hunk.NewLine,
-		OldCnt:  0,
-		NewCnt:  0,
+	// Insert header at the beginning instead of replacing first line
+	hunk.Text = append([]string{header}, hunk.Text...)
+	hunk.Display = append([]string{header}, hunk.Display...)
+}
+
+// formatHunkHeader returns the "@@" line for the ranges of hunk.
+func formatHunkHeader(hunk *Hunk) string {
+	header := fmt.Sprintf("@@ -%d", hunk.OldLine)
+	if hunk.OldCnt != 1 {
+		header += fmt.Sprintf(",%d", hunk.OldCnt)
+	}
+	header += fmt.Sprintf(" +%d", hunk.NewLine)
+	if hunk.NewCnt != 1 {
+		header += fmt.Sprintf(",%d", hunk.NewCnt)
+	}
+	return header + " @@"
+}
+
+// RenumberHunk returns hunk with the side that applying it produces moved by
+// delta lines: the new side, or the old side when the patch is applied in
+// reverse. The numbering of a piece of a split hunk assumes the pieces
+// before it are applied, so the lines those left out would have added or
+// removed are made up for here.
+func RenumberHunk(hunk Hunk, delta int, reverse bool) Hunk {
+	ranges := ""
+	if len(hunk.Text) > 0 {
+		ranges = hunkHeaderRe.FindString(hunk.Text[0])
+	}
+	if delta == 0 || hunk.Type != HunkTypeHunk || ranges == "" {
+		return hunk
+	}
+
+	if reverse {
+		hunk.OldLine += delta
+	} else {
+		hunk.NewLine += delta
+	}
+	header := formatHunkHeader(&hunk)
+
+	hunk.Text = append([]string{header + hunk.Text[0][len(ranges):]}, hunk.Text[1:]...)
+	if len(hunk.Display) > 0 {
+		hunk.Display = append([]string{strings.Replace(hunk.Display[0], ranges, header, 1)}, hunk.Display[1:]...)
+	}
+	return hunk
+}
+
+// RecountHunk sets the line counts of an edited hunk from its body, as git
+// apply --recount does, and rewrites its header to match. Where the hunk
+// starts is taken from its header when that parses.
+func RecountHunk(hunk *Hunk) {
+	ranges := hunkHeaderRe.FindString(hunk.Text[0])
+	if ranges != "" {
+		start := Hunk{Text: hunk.Text[:1]}
+		if parseHunkRanges(&start) == nil {
+			hunk.OldLine, hunk.NewLine = start.OldLine, start.NewLine
+		}
+	}
+
+	hunk.OldCnt, hunk.NewCnt = 0, 0
+	for _, line := range hunk.Text[1:] {
+		switch {
+		case strings.HasPrefix(line, "\\"):
+		case strings.HasPrefix(line, "-"):
+			hunk.OldCnt++
+		case strings.HasPrefix(line, "+"):
+			hunk.NewCnt++
+		default:
+			hunk.OldCnt++
+			hunk.NewCnt++
+		}
 	}
 
-	for i := 1; i <= splitPoint; i++ {
+	header := formatHunkHeader(hunk)
+	if ranges == "" {
+		hunk.Text[0] = header
+	} else {
+		hunk.Text[0] = header + hunk.Text[0][len(ranges):]
+	}
+	if len(hunk.Display) > 0 {
+		if ranges != "" && strings.Contains(hunk.Display[0], ranges) {
+			hunk.Display[0] = strings.Replace(hunk.Display[0], ranges, header, 1)
+		} else {
+			hunk.Display[0] = hunk.Text[0]
+		}
+	}
+}
+
+// MergeHunks joins next onto prev when next starts within the context that
+// ends prev, as upstream merge_hunk does for the pieces of a split hunk that
+// are both selected. The lines of prev from where next starts are dropped
+// in favor of those of next, so the result has no overlap and counts of its
+// own. Edited hunks are never merged, as their numbers may be off.
+func MergeHunks(prev, next Hunk) (Hunk, bool) {
+	if prev.Type != HunkTypeHunk || next.Type != HunkTypeHunk || prev.Dirty || next.Dirty {
+		return prev, false
+	}
+	prevRanges := hunkHeaderRe.FindString(prev.Text[0])
+	if prevRanges == "" || !hunkHeaderRe.MatchString(next.Text[0]) {
+		return prev, false
+	}
+	if next.OldLine > lastOldContext(&prev) {
+		return prev, false
+	}
+
+	merged := Hunk{Type: HunkTypeHunk, Use: prev.Use, OldLine: prev.OldLine, NewLine: prev.NewLine}
+	add := func(hunk *Hunk, i int) {
 		line := hunk.Text[i]
-		displayLine := line
+		display := line
 		if i < len(hunk.Display) {
-			displayLine = hunk.Display[i]
+			display = hunk.Display[i]
 		}
+		merged.Text = append(merged.Text, line)
+		merged.Display = append(merged.Display, display)
+
+		switch {
+		case strings.HasPrefix(line, "\\"):
+		case strings.HasPrefix(line, "+"):
+			merged.NewCnt++
+		case strings.HasPrefix(line, "-"):
+			merged.OldCnt++
+		default:
+			merged.OldCnt++
+			merged.NewCnt++
+		}
+	}
 
-		split1.Text = append(split1.Text, line)
-		split1.Display = append(split1.Display, displayLine)
+	line := prev.OldLine
+	for i := 1; i < len(prev.Text); i++ {
+		text := prev.Text[i]
+		if !strings.HasPrefix(text, "+") && !strings.HasPrefix(text, "\\") {
+			if line >= next.OldLine {
+				break
+			}
+			line++
+		}
+		add(&prev, i)
+	}
+	for i := 1; i < len(next.Text); i++ {
+		add(&next, i)
+	}
 
-		if strings.HasPrefix(line, " ") {
-			split1.OldCnt++
-			split1.NewCnt++
-		} else if strings.HasPrefix(line, "-") {
-			split1.OldCnt++
-		} else if strings.HasPrefix(line, "+") {
-			split1.NewCnt++
+	header := formatHunkHeader(&merged)
+	merged.Text = append([]string{header + prev.Text[0][len(prevRanges):]}, merged.Text...)
+	display := header
+	if len(prev.Display) > 0 {
+		display = strings.Replace(prev.Display[0], prevRanges, header, 1)
+	}
+	merged.Display = append([]string{display}, merged.Display...)
+	return merged, true
+}
+
+// lastOldContext returns the old line number at which the context that ends
+// hunk starts.
+func lastOldContext(hunk *Hunk) int {
+	last := hunk.OldLine + hunk.OldCnt
+	for i := len(hunk.Text) - 1; i > 0; i-- {
+		if !strings.HasPrefix(hunk.Text[i], " ") && hunk.Text[i] != "" {
+			break
 		}
+		last--
+	}
+	return last
+}
+
+// colorHunkHeader colors the header updateHunkHeader made for hunk, a piece
+// of original, like the one git diff printed when original is shown in
+// color.
+func (r *Repository) colorHunkHeader(original, hunk *Hunk) {
+	if len(original.Display) == 0 || original.Display[0] == original.Text[0] {
+		return
 	}
+	hunk.Display[0] = r.GetColor("color.diff.frag", "cyan") + hunk.Text[0] + r.GetColor("", "reset")
+}
 
-	r.updateHunkHeader(&split1)
-	splits = append(splits, split1)
+// SelectHunkLines builds a hunk that applies only the changed lines marked in
+// selected, which is indexed like hunk.Text. When the patch is applied forward
+// an unselected "-" line is kept as context and an unselected "+" line is
+// dropped; a reverse patch swaps the two.
+func (r *Repository) SelectHunkLines(hunk *Hunk, selected []bool, reverse bool) Hunk {
+	keep, drop := "-", "+"
+	if reverse {
+		keep, drop = "+", "-"
+	}
 
-	// Split 2: from splitPoint to end (splitPoint line is included in both)
-	split2 := Hunk{
-		Type:    HunkTypeHunk,
+	result := Hunk{
+		Type:    hunk.Type,
 		Text:    []string{},
 		Display: []string{},
-		OldLine: hunk.OldLine + split1.OldCnt - 1, // -1 for overlapping context line
-		NewLine: hunk.NewLine + split1.NewCnt - 1,
-		OldCnt:  0,
-		NewCnt:  0,
+		OldLine: hunk.OldLine,
+		NewLine: hunk.NewLine,
+		Dirty:   true,
 	}
 
-	for i := splitPoint; i < len(hunk.Text); i++ {
+	lastKept := true
+	for i := 1; i < len(hunk.Text); i++ {
 		line := hunk.Text[i]
 		displayLine := line
 		if i < len(hunk.Display) {
 			displayLine = hunk.Display[i]
 		}
 
-		split2.Text = append(split2.Text, line)
-		split2.Display = append(split2.Display, displayLine)
+		// "\ No newline at end of file" belongs to the line before it
+		if strings.HasPrefix(line, "\\") {
+			if lastKept {
+				result.Text = append(result.Text, line)
+				result.Display = append(result.Display, displayLine)
+			}
+			continue
+		}
+
+		isSelected := i < len(selected) && selected[i]
+		if !isSelected && strings.HasPrefix(line, drop) {
+			lastKept = false
+			continue
+		}
+		if !isSelected && strings.HasPrefix(line, keep) {
+			line = " " + line[1:]
+			displayLine = line
+		}
+		lastKept = true
+
+		result.Text = append(result.Text, line)
+		result.Display = append(result.Display, displayLine)
 
 		if strings.HasPrefix(line, " ") {
-			split2.OldCnt++
-			split2.NewCnt++
+			result.OldCnt++
+			result.NewCnt++
 		} else if strings.HasPrefix(line, "-") {
-			split2.OldCnt++
+			result.OldCnt++
 		} else if strings.HasPrefix(line, "+") {
-			split2.NewCnt++
+			result.NewCnt++
 		}
 	}
 
-	r.updateHunkHeader(&split2)
-	splits = append(splits, split2)
-
-	return splits
-}
-
-func (r *Repository) updateHunkHeader(hunk *Hunk) {
-	header := fmt.Sprintf("@@ -%d", hunk.OldLine)
-	if hunk.OldCnt != 1 {
-		header += fmt.Sprintf(",%d", hunk.OldCnt)
-	}
-	header += fmt.Sprintf(" +%d", hunk.NewLine)
-	if hunk.NewCnt != 1 {
-		header += fmt.Sprintf(",%d", hunk.NewCnt)
-	}
-	header += " @@"
-
-	// Insert header at the beginning instead of replacing first line
-	hunk.Text = append([]string{header}, hunk.Text...)
-	hunk.Display = append([]string{header}, hunk.Display...)
+	r.updateHunkHeader(&result)
+	r.colorHunkHeader(hunk, &result)
+	return result
 }
@@ -1,6 +1,7 @@
 package git
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -552,3 +553,630 @@ func TestSplitHunkNonSplittable(t *testing.T) {
 		})
 	}
 }
+
+func TestParseHunksModeChange(t *testing.T) {
+	repo := &Repository{}
+	diffLines := []string{
+		"diff --git a/script.sh b/script.sh",
+		"old mode 100644",
+		"new mode 100755",
+		"index 1234567..abcdefg",
+		"--- a/script.sh",
+		"+++ b/script.sh",
+		"@@ -1,2 +1,3 @@",
+		" line 1",
+		"+line 1.5",
+		" line 2",
+	}
+
+	hunks, err := repo.parseHunks(diffLines, diffLines)
+	if err != nil {
+		t.Fatalf("Failed to parse hunks: %v", err)
+	}
+
+	if len(hunks) != 3 {
+		t.Fatalf("Expected 3 hunks (header + mode + hunk), got %d", len(hunks))
+	}
+
+	if hunks[1].Type != HunkTypeMode {
+		t.Errorf("Second hunk should be mode, got %s", hunks[1].Type)
+	}
+
+	expectedMode := []string{"old mode 100644", "new mode 100755"}
+	if strings.Join(hunks[1].Text, "\n") != strings.Join(expectedMode, "\n") {
+		t.Errorf("Mode hunk text = %q, expected %q", hunks[1].Text, expectedMode)
+	}
+
+	for _, line := range hunks[0].Text {
+		if strings.Contains(line, " mode ") {
+			t.Errorf("Header should not contain mode lines, got %q", line)
+		}
+	}
+
+	if hunks[2].Type != HunkTypeHunk || hunks[2].OldLine != 1 || hunks[2].NewCnt != 3 {
+		t.Errorf("Third hunk should be a parsed content hunk, got %+v", hunks[2])
+	}
+}
+
+func TestParseHunksWholeFile(t *testing.T) {
+	repo := &Repository{}
+
+	tests := []struct {
+		name         string
+		diffLines    []string
+		expectedType HunkType
+	}{
+		{
+			name: "deleted file",
+			diffLines: []string{
+				"diff --git a/gone.txt b/gone.txt",
+				"deleted file mode 100644",
+				"index 1234567..0000000",
+				"--- a/gone.txt",
+				"+++ /dev/null",
+				"@@ -1,2 +0,0 @@",
+				"-line 1",
+				"-line 2",
+			},
+			expectedType: HunkTypeDeletion,
+		},
+		{
+			name: "new file",
+			diffLines: []string{
+				"diff --git a/new.txt b/new.txt",
+				"new file mode 100644",
+				"index 0000000..1234567",
+				"--- /dev/null",
+				"+++ b/new.txt",
+				"@@ -0,0 +1,2 @@",
+				"+line 1",
+				"+line 2",
+			},
+			expectedType: HunkTypeAddition,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hunks, err := repo.parseHunks(tt.diffLines, tt.diffLines)
+			if err != nil {
+				t.Fatalf("Failed to parse hunks: %v", err)
+			}
+
+			if len(hunks) != 2 {
+				t.Fatalf("Expected 2 hunks (header + %s), got %d", tt.expectedType, len(hunks))
+			}
+
+			if hunks[1].Type != tt.expectedType {
+				t.Errorf("Second hunk should be %s, got %s", tt.expectedType, hunks[1].Type)
+			}
+
+			if hunks[1].Text[0] != tt.diffLines[1] {
+				t.Errorf("Expected %q as first line, got %q", tt.diffLines[1], hunks[1].Text[0])
+			}
+
+			if len(hunks[1].Text) != 4 || len(hunks[1].Display) != 4 {
+				t.Errorf("Expected 4 text and display lines, got %d and %d", len(hunks[1].Text), len(hunks[1].Display))
+			}
+
+			if len(hunks[0].Text) != 4 {
+				t.Errorf("Expected 4 header lines, got %d", len(hunks[0].Text))
+			}
+		})
+	}
+}
+
+func TestIntentToAddHunks(t *testing.T) {
+	repo := &Repository{}
+	diffLines := []string{
+		"diff --git a/new.txt b/new.txt",
+		"new file mode 100644",
+		"index 0000000..1234567",
+		"--- /dev/null",
+		"+++ b/new.txt",
+		"@@ -0,0 +1,2 @@",
+		"+line 1",
+		"+line 2",
+	}
+
+	hunks, err := repo.parseHunks(diffLines, diffLines)
+	if err != nil {
+		t.Fatalf("Failed to parse hunks: %v", err)
+	}
+	hunks = intentToAddHunks(hunks)
+
+	if len(hunks) != 2 {
+		t.Fatalf("Expected 2 hunks (header + content), got %d", len(hunks))
+	}
+
+	expectedHeader := []string{diffLines[0], diffLines[1], diffLines[2], diffLines[3], diffLines[4]}
+	if strings.Join(hunks[0].Text, "\n") != strings.Join(expectedHeader, "\n") {
+		t.Errorf("Expected header %q, got %q", expectedHeader, hunks[0].Text)
+	}
+	if len(hunks[0].Display) != len(expectedHeader) {
+		t.Errorf("Expected %d header display lines, got %d", len(expectedHeader), len(hunks[0].Display))
+	}
+
+	content := hunks[1]
+	if content.Type != HunkTypeHunk {
+		t.Errorf("Content should be a regular hunk, got %s", content.Type)
+	}
+	if content.OldLine != 0 || content.OldCnt != 0 || content.NewLine != 1 || content.NewCnt != 2 {
+		t.Errorf("Expected ranges -0,0 +1,2, got -%d,%d +%d,%d", content.OldLine, content.OldCnt, content.NewLine, content.NewCnt)
+	}
+	if len(content.Text) != 3 || len(content.Display) != 3 {
+		t.Errorf("Expected 3 text and display lines, got %d and %d", len(content.Text), len(content.Display))
+	}
+
+	// Binary and empty files have nothing to pick from
+	empty := []string{
+		"diff --git a/empty.txt b/empty.txt",
+		"new file mode 100644",
+		"index 0000000..e69de29",
+	}
+	hunks, err = repo.parseHunks(empty, empty)
+	if err != nil {
+		t.Fatalf("Failed to parse hunks: %v", err)
+	}
+	if unfolded := intentToAddHunks(hunks); len(unfolded) != len(hunks) || unfolded[len(unfolded)-1].Type != hunks[len(hunks)-1].Type {
+		t.Errorf("Empty addition should be left alone, got %v", unfolded)
+	}
+}
+
+func TestParseHunksBinary(t *testing.T) {
+	repo := &Repository{}
+	diffLines := []string{
+		"diff --git a/image.png b/image.png",
+		"index 88768efdf77ec78c9a995f94881793be6a41752b..f68ed8037341be54a4bad1485a8deb1be7188467 100644",
+		"GIT binary patch",
+		"literal 6",
+		"NcmZQzO3KVL0ssTy0d4>Q",
+		"",
+		"literal 5",
+		"McmZQzOv=my00M6TI{*Lx",
+		"",
+	}
+
+	hunks, err := repo.parseHunks(diffLines, diffLines)
+	if err != nil {
+		t.Fatalf("Failed to parse hunks: %v", err)
+	}
+
+	if len(hunks) != 2 {
+		t.Fatalf("Expected 2 hunks (header + binary), got %d", len(hunks))
+	}
+
+	if hunks[1].Type != HunkTypeBinary {
+		t.Errorf("Second hunk should be binary, got %s", hunks[1].Type)
+	}
+
+	if len(hunks[0].Text) != 2 {
+		t.Errorf("Expected 2 header lines, got %d", len(hunks[0].Text))
+	}
+
+	if len(hunks[1].Text) != 7 || hunks[1].Text[0] != "GIT binary patch" {
+		t.Errorf("Binary hunk should hold the whole binary patch, got %q", hunks[1].Text)
+	}
+	if expected := []string{"Binary files a/image.png and b/image.png differ"}; !reflect.DeepEqual(hunks[1].Display, expected) {
+		t.Errorf("Binary hunk should display %q, got %q", expected, hunks[1].Display)
+	}
+
+	// A new file has no old side
+	added := append([]string{diffLines[0], "new file mode 100644"}, diffLines[1:]...)
+	hunks, err = repo.parseHunks(added, added)
+	if err != nil {
+		t.Fatalf("Failed to parse hunks: %v", err)
+	}
+	last := hunks[len(hunks)-1]
+	if expected := []string{"new file mode 100644", "Binary files /dev/null and b/image.png differ"}; !reflect.DeepEqual(last.Display, expected) {
+		t.Errorf("Binary addition should display %q, got %q", expected, last.Display)
+	}
+}
+
+func TestIsBinaryDiff(t *testing.T) {
+	tests := []struct {
+		name      string
+		diffLines []string
+		expected  bool
+	}{
+		{
+			name: "binary files differ",
+			diffLines: []string{
+				"diff --git a/image.png b/image.png",
+				"index 88768ef..f68ed80 100644",
+				"Binary files a/image.png and b/image.png differ",
+			},
+			expected: true,
+		},
+		{
+			name: "text diff",
+			diffLines: []string{
+				"diff --git a/test.txt b/test.txt",
+				"--- a/test.txt",
+				"+++ b/test.txt",
+				"@@ -1 +1 @@",
+				"-Binary files are fun",
+				"+Binary files a and b differ",
+			},
+			expected: false,
+		},
+		{
+			name:      "empty diff",
+			diffLines: []string{},
+			expected:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := isBinaryDiff(tt.diffLines); result != tt.expected {
+				t.Errorf("isBinaryDiff() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseHunksRename(t *testing.T) {
+	repo := &Repository{}
+	diffLines := []string{
+		"diff --git a/f b/d/f2",
+		"similarity index 90%",
+		"rename from f",
+		"rename to d/f2",
+		"index 92dfa21..7690fc8 100644",
+		"--- a/f",
+		"+++ b/d/f2",
+		"@@ -8,3 +8,4 @@",
+		" h",
+		" i",
+		" j",
+		"+Z",
+	}
+
+	hunks, err := repo.parseHunks(diffLines, diffLines)
+	if err != nil {
+		t.Fatalf("Failed to parse hunks: %v", err)
+	}
+
+	if len(hunks) != 3 {
+		t.Fatalf("Expected 3 hunks (header + rename + hunk), got %d", len(hunks))
+	}
+
+	if hunks[1].Type != HunkTypeRename {
+		t.Errorf("Second hunk should be rename, got %s", hunks[1].Type)
+	}
+
+	if len(hunks[1].Text) != 3 {
+		t.Errorf("Expected 3 rename lines, got %q", hunks[1].Text)
+	}
+
+	if len(hunks[0].Text) != 4 {
+		t.Errorf("Expected 4 header lines, got %q", hunks[0].Text)
+	}
+}
+
+func TestFileDiffSection(t *testing.T) {
+	diffLines := []string{
+		"diff --git a/base.txt b/base.txt",
+		"@@ -1 +1 @@",
+		"-a",
+		"+b",
+		"diff --git a/base.txt b/copy.txt",
+		"similarity index 100%",
+		"copy from base.txt",
+		"copy to copy.txt",
+	}
+
+	start, end := fileDiffSection(diffLines, "copy.txt")
+	if start != 4 || end != 8 {
+		t.Errorf("fileDiffSection(copy.txt) = (%d, %d), expected (4, 8)", start, end)
+	}
+
+	start, end = fileDiffSection(diffLines, "base.txt")
+	if start != 0 || end != 4 {
+		t.Errorf("fileDiffSection(base.txt) = (%d, %d), expected (0, 4)", start, end)
+	}
+}
+
+func TestSelectHunkLines(t *testing.T) {
+	repo := &Repository{}
+	hunk := &Hunk{
+		Type:    HunkTypeHunk,
+		OldLine: 4,
+		NewLine: 4,
+		Text: []string{
+			"@@ -4,3 +4,3 @@",
+			" context",
+			"-old line 1",
+			"-old line 2",
+			"+new line 1",
+			"+new line 2",
+		},
+	}
+	selected := []bool{false, false, true, false, true, false}
+
+	tests := []struct {
+		name     string
+		reverse  bool
+		expected []string
+	}{
+		{
+			name:    "forward",
+			reverse: false,
+			expected: []string{
+				"@@ -4,3 +4,3 @@",
+				" context",
+				"-old line 1",
+				" old line 2",
+				"+new line 1",
+			},
+		},
+		{
+			name:    "reverse",
+			reverse: true,
+			expected: []string{
+				"@@ -4,3 +4,3 @@",
+				" context",
+				"-old line 1",
+				"+new line 1",
+				" new line 2",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := repo.SelectHunkLines(hunk, selected, tt.reverse)
+			if strings.Join(result.Text, "\n") != strings.Join(tt.expected, "\n") {
+				t.Errorf("Expected:\n%s\ngot:\n%s", strings.Join(tt.expected, "\n"), strings.Join(result.Text, "\n"))
+			}
+			if !result.Dirty {
+				t.Error("Expected selected hunk to be marked dirty")
+			}
+		})
+	}
+}
+
+func TestSelectHunkLinesNoNewline(t *testing.T) {
+	repo := &Repository{}
+	hunk := &Hunk{
+		Type:    HunkTypeHunk,
+		OldLine: 1,
+		NewLine: 1,
+		Text: []string{
+			"@@ -1 +1,2 @@",
+			"-last",
+			"\\ No newline at end of file",
+			"+last",
+			"+added",
+			"\\ No newline at end of file",
+		},
+	}
+
+	result := repo.SelectHunkLines(hunk, []bool{false, true, false, true, false, false}, false)
+	expected := []string{
+		"@@ -1 +1 @@",
+		"-last",
+		"\\ No newline at end of file",
+		"+last",
+	}
+	if strings.Join(result.Text, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(result.Text, "\n"))
+	}
+}
+
+func TestRunDiffFilter(t *testing.T) {
+	lines := []string{"diff --git a/f b/f", "@@ -1 +1 @@", "-old", "+new"}
+
+	filtered, err := runDiffFilter("sed 's/^/> /'", "", lines)
+	if err != nil {
+		t.Fatalf("runDiffFilter failed: %v", err)
+	}
+	if len(filtered) != len(lines) || filtered[3] != "> +new" {
+		t.Errorf("Unexpected filter output %q", filtered)
+	}
+
+	if _, err := runDiffFilter("sed 1d", "", lines); err == nil || err.Error() != "mismatched output from interactive.diffFilter" {
+		t.Errorf("Expected a mismatch error, got %v", err)
+	}
+
+	if _, err := runDiffFilter("exit 3", "", lines); err == nil {
+		t.Error("Expected a failing filter to be reported")
+	}
+}
+
+// colorBackend colors like git with the default colors of the diff.
+type colorBackend struct {
+	stubBackend
+}
+
+func (b *colorBackend) Color(key, defaultColor string) string {
+	return "<" + defaultColor + ">"
+}
+
+func TestSplitHunkDisplay(t *testing.T) {
+	repo := &Repository{}
+	repo.SetBackend(&colorBackend{})
+
+	hunk := &Hunk{
+		Type: HunkTypeHunk,
+		Text: []string{"@@ -1,5 +1,5 @@ func", "-a", "+A", " b", "-c", "+C"},
+		Display: []string{
+			"<cyan>@@ -1,5 +1,5 @@<reset> func",
+			"<red>-a<reset>", "<green>+A<reset>", " b", "<red>-c<reset>", "<green>+C<reset>",
+		},
+	}
+	if err := repo.parseHunkHeader(hunk); err != nil {
+		t.Fatal(err)
+	}
+
+	splits := repo.SplitHunk(hunk)
+	if len(splits) != 2 {
+		t.Fatalf("Expected 2 splits, got %d", len(splits))
+	}
+
+	expected := [][]string{
+		{"<cyan>@@ -1,2 +1,2 @@<reset>", "<red>-a<reset>", "<green>+A<reset>", " b"},
+		{"<cyan>@@ -2,2 +2,2 @@<reset>", " b", "<red>-c<reset>", "<green>+C<reset>"},
+	}
+	for i, split := range splits {
+		if len(split.Display) != len(split.Text) {
+			t.Errorf("Split %d has %d display lines for %d lines", i, len(split.Display), len(split.Text))
+		}
+		if strings.Join(split.Display, "|") != strings.Join(expected[i], "|") {
+			t.Errorf("Split %d displays %q, expected %q", i, split.Display, expected[i])
+		}
+	}
+}
+
+func TestSplitHunkAllPieces(t *testing.T) {
+	repo := &Repository{}
+	hunk := &Hunk{
+		Type: HunkTypeHunk,
+		Text: []string{
+			"@@ -1,9 +1,9 @@",
+			" a",
+			"-b",
+			"+B",
+			"+B2",
+			" c",
+			" d",
+			"-e",
+			" f",
+			"-g",
+			"+G",
+			" h",
+		},
+	}
+	hunk.Display = hunk.Text
+	if err := repo.parseHunkHeader(hunk); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]string{
+		{"@@ -1,4 +1,5 @@", " a", "-b", "+B", "+B2", " c", " d"},
+		{"@@ -3,4 +4,3 @@", " c", " d", "-e", " f"},
+		{"@@ -6,3 +6,3 @@", " f", "-g", "+G", " h"},
+	}
+
+	splits := repo.SplitHunk(hunk)
+	if len(splits) != len(expected) {
+		t.Fatalf("Expected %d pieces, got %d", len(expected), len(splits))
+	}
+	for i, split := range splits {
+		if strings.Join(split.Text, "|") != strings.Join(expected[i], "|") {
+			t.Errorf("Piece %d = %q, expected %q", i, split.Text, expected[i])
+		}
+		if strings.Join(split.Display, "|") != strings.Join(split.Text, "|") {
+			t.Errorf("Piece %d displays %q for %q", i, split.Display, split.Text)
+		}
+		if repo.HunkSplittable(&split) {
+			t.Errorf("Piece %d should not split further", i)
+		}
+	}
+}
+
+func TestRenumberHunk(t *testing.T) {
+	hunk := Hunk{
+		Type:    HunkTypeHunk,
+		Text:    []string{"@@ -5,2 +7,3 @@ func f()", " x", "+y"},
+		Display: []string{"<cyan>@@ -5,2 +7,3 @@<reset> func f()", " x", "<green>+y<reset>"},
+	}
+	if err := parseHunkRanges(&hunk); err != nil {
+		t.Fatal(err)
+	}
+
+	forward := RenumberHunk(hunk, -2, false)
+	if forward.Text[0] != "@@ -5,2 +5,3 @@ func f()" || forward.Display[0] != "<cyan>@@ -5,2 +5,3 @@<reset> func f()" {
+		t.Errorf("Forward renumbering gave %q / %q", forward.Text[0], forward.Display[0])
+	}
+
+	reverse := RenumberHunk(hunk, 3, true)
+	if reverse.Text[0] != "@@ -8,2 +7,3 @@ func f()" || reverse.OldLine != 8 {
+		t.Errorf("Reverse renumbering gave %q", reverse.Text[0])
+	}
+
+	if hunk.Text[0] != "@@ -5,2 +7,3 @@ func f()" {
+		t.Errorf("RenumberHunk changed its argument: %q", hunk.Text[0])
+	}
+}
+
+func TestMergeHunks(t *testing.T) {
+	repo := &Repository{}
+	hunk := &Hunk{
+		Type: HunkTypeHunk,
+		Text: []string{
+			"@@ -1,9 +1,9 @@ func f()",
+			" a",
+			"-b",
+			"+B",
+			"+B2",
+			" c",
+			" d",
+			"-e",
+			" f",
+			"-g",
+			"+G",
+			" h",
+		},
+	}
+	hunk.Display = hunk.Text
+	if err := repo.parseHunkHeader(hunk); err != nil {
+		t.Fatal(err)
+	}
+	pieces := repo.SplitHunk(hunk)
+	if len(pieces) != 3 {
+		t.Fatalf("Expected 3 pieces, got %d", len(pieces))
+	}
+
+	// All pieces merge back into the hunk they were split from
+	merged := pieces[0]
+	for _, piece := range pieces[1:] {
+		var ok bool
+		merged, ok = MergeHunks(merged, piece)
+		if !ok {
+			t.Fatalf("Expected %q to merge", piece.Text[0])
+		}
+	}
+	expected := append([]string{"@@ -1,8 +1,8 @@"}, hunk.Text[1:]...)
+	if strings.Join(merged.Text, "|") != strings.Join(expected, "|") {
+		t.Errorf("Merged %q, expected %q", merged.Text, expected)
+	}
+	if strings.Join(merged.Display, "|") != strings.Join(merged.Text, "|") {
+		t.Errorf("Merged display %q does not follow %q", merged.Display, merged.Text)
+	}
+
+	merged, ok := MergeHunks(pieces[1], pieces[2])
+	if !ok {
+		t.Fatal("Expected the last two pieces to merge")
+	}
+	expected = []string{"@@ -3,6 +4,5 @@", " c", " d", "-e", " f", "-g", "+G", " h"}
+	if strings.Join(merged.Text, "|") != strings.Join(expected, "|") {
+		t.Errorf("Merged %q, expected %q", merged.Text, expected)
+	}
+
+	if _, ok := MergeHunks(pieces[0], pieces[2]); ok {
+		t.Error("Pieces that do not overlap should not merge")
+	}
+
+	dirty := pieces[1]
+	dirty.Dirty = true
+	if _, ok := MergeHunks(pieces[0], dirty); ok {
+		t.Error("Edited hunks should not merge")
+	}
+}
+
+func TestRecountHunk(t *testing.T) {
+	hunk := &Hunk{
+		Type: HunkTypeHunk,
+		Text: []string{"@@ -7,1 +7,1 @@ end", " x", "-y", `\ No newline at end of file`, "+y", "+z", `\ No newline at end of file`},
+	}
+	RecountHunk(hunk)
+
+	if hunk.Text[0] != "@@ -7,2 +7,3 @@ end" {
+		t.Errorf("Recounted header %q, expected %q", hunk.Text[0], "@@ -7,2 +7,3 @@ end")
+	}
+	if hunk.OldLine != 7 || hunk.OldCnt != 2 || hunk.NewLine != 7 || hunk.NewCnt != 3 {
+		t.Errorf("Recounted ranges -%d,%d +%d,%d", hunk.OldLine, hunk.OldCnt, hunk.NewLine, hunk.NewCnt)
+	}
+}
@@ -0,0 +1,568 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// pathspecMagic is a set of the magic words a pathspec item carries.
+type pathspecMagic int
+
+const (
+	magicTop pathspecMagic = 1 << iota
+	magicLiteral
+	magicGlob
+	magicICase
+	magicExclude
+	magicAttr
+)
+
+var pathspecMagicNames = map[string]pathspecMagic{
+	"top":     magicTop,
+	"literal": magicLiteral,
+	"glob":    magicGlob,
+	"icase":   magicICase,
+	"exclude": magicExclude,
+}
+
+// shortMagicChars are the characters git reserves for short magic after a
+// leading colon, of which only "/" and "!" (or "^") mean anything.
+const shortMagicChars = "!\"#%&',-/;<=>@_`~"
+
+// attrState is what an "attr:" requirement asks of an attribute.
+type attrState int
+
+const (
+	attrSet         attrState = iota // name
+	attrUnset                        // -name
+	attrUnspecified                  // !name
+	attrValue                        // name=value
+)
+
+type attrRequirement struct {
+	name  string
+	state attrState
+	value string
+}
+
+// pathspecItem is one parsed pathspec. match is relative to the top of the
+// worktree; its first prefix bytes came from "prefix:" magic and are always
+// compared case-sensitively, and its first nowildcard bytes contain no
+// wildcards.
+type pathspecItem struct {
+	original   string
+	match      string
+	prefix     int
+	nowildcard int
+	magic      pathspecMagic
+	attrs      []attrRequirement
+	wildcard   *regexp.Regexp // match after the prefix, when it has wildcards
+}
+
+// Pathspec selects paths the way git pathspecs do for commands run at the
+// top of the worktree, as "git ls-files" does. A path matches if it matches
+// an item without exclude magic and none with it; an empty Pathspec matches
+// every path.
+type Pathspec struct {
+	items []pathspecItem
+
+	// lookup returns the attributes names of each of paths, as reported by
+	// "git check-attr": "set", "unset", "unspecified" or a value
+	lookup     func(paths, names []string) (map[string]map[string]string, error)
+	attributes map[string]map[string]string
+}
+
+// ParsePathspec parses pathspecs relative to the top of the worktree,
+// including the magic git understands and the GIT_*_PATHSPECS settings of
+// the environment. Attribute magic never matches unless the Pathspec came
+// from Repository.ParsePathspec.
+func ParsePathspec(specs []string) (*Pathspec, error) {
+	global, noglob, err := globalPathspecMagic()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Pathspec{}
+	excludes := 0
+	for _, spec := range specs {
+		item, err := parsePathspecItem(spec, global, noglob)
+		if err != nil {
+			return nil, err
+		}
+		if item.magic&magicExclude != 0 {
+			excludes++
+		}
+		p.items = append(p.items, item)
+	}
+
+	// Excluding paths from nothing excludes them from everything
+	if excludes > 0 && excludes == len(p.items) {
+		p.items = append(p.items, pathspecItem{original: "."})
+	}
+	return p, nil
+}
+
+// globalPathspecMagic reads GIT_LITERAL_PATHSPECS, GIT_GLOB_PATHSPECS and
+// GIT_ICASE_PATHSPECS, and whether GIT_NOGLOB_PATHSPECS makes items literal
+// unless they ask for glob magic.
+func globalPathspecMagic() (pathspecMagic, bool, error) {
+	env := func(name string) bool {
+		use, err := parseConfigBool(os.Getenv(name))
+		return err == nil && use
+	}
+
+	var magic pathspecMagic
+	if env("GIT_LITERAL_PATHSPECS") {
+		magic |= magicLiteral
+	}
+	if env("GIT_GLOB_PATHSPECS") {
+		magic |= magicGlob
+	}
+	if env("GIT_ICASE_PATHSPECS") {
+		magic |= magicICase
+	}
+	noglob := env("GIT_NOGLOB_PATHSPECS")
+
+	if magic&magicGlob != 0 && noglob {
+		return 0, false, fmt.Errorf("global 'glob' and 'noglob' pathspec settings are incompatible")
+	}
+	if magic&magicLiteral != 0 && (magic&^magicLiteral != 0 || noglob) {
+		return 0, false, fmt.Errorf("global 'literal' pathspec setting is incompatible with all other global pathspec settings")
+	}
+	return magic, noglob, nil
+}
+
+func parsePathspecItem(spec string, global pathspecMagic, noglob bool) (pathspecItem, error) {
+	item := pathspecItem{original: spec, prefix: -1}
+	if spec == "" {
+		return item, fmt.Errorf("empty string is not a valid pathspec. please use . instead if you meant to match all paths")
+	}
+
+	pattern := spec
+	if global&magicLiteral != 0 {
+		item.magic = magicLiteral
+	} else if strings.HasPrefix(spec, ":(") {
+		var err error
+		if pattern, err = item.parseLongMagic(spec); err != nil {
+			return item, err
+		}
+	} else if strings.HasPrefix(spec, ":") {
+		var err error
+		if pattern, err = item.parseShortMagic(spec); err != nil {
+			return item, err
+		}
+	}
+
+	if global&magicGlob != 0 && item.magic&magicLiteral == 0 {
+		item.magic |= magicGlob
+	}
+	if noglob && item.magic&magicGlob == 0 {
+		item.magic |= magicLiteral
+	}
+	item.magic |= global & magicICase
+	if item.magic&magicLiteral != 0 && item.magic&magicGlob != 0 {
+		return item, fmt.Errorf("%s: 'literal' and 'glob' are incompatible", spec)
+	}
+
+	switch {
+	case item.prefix >= 0:
+		// git hands out prefix magic on paths it has already normalized
+		if item.prefix > len(pattern) {
+			item.prefix = len(pattern)
+		}
+		item.match = pattern
+	case item.magic&magicTop != 0:
+		item.prefix = 0
+		item.match = pattern
+	default:
+		item.prefix = 0
+		match, ok := normalizePathspecPath(pattern)
+		if !ok {
+			return item, fmt.Errorf("%s: '%s' is outside repository", spec, pattern)
+		}
+		item.match = match
+	}
+
+	item.nowildcard = len(item.match)
+	if item.magic&magicLiteral == 0 {
+		if i := strings.IndexAny(item.match, `*?[\`); i != -1 {
+			item.nowildcard = i
+		}
+	}
+	if item.nowildcard < item.prefix {
+		item.nowildcard = item.prefix
+	}
+
+	if item.nowildcard < len(item.match) {
+		flags := ""
+		if item.magic&magicICase != 0 {
+			flags = "(?i)"
+		}
+		// The literal part is compiled too: "**" depends on what precedes it
+		re, err := regexp.Compile(flags + "^" + globToRegexp(item.match[item.prefix:], item.magic&magicGlob != 0) + "$")
+		if err != nil {
+			return item, fmt.Errorf("%s: %v", spec, err)
+		}
+		item.wildcard = re
+	}
+	return item, nil
+}
+
+// parseLongMagic parses the ":(word,...)" form and returns the pattern
+// that follows it.
+func (item *pathspecItem) parseLongMagic(spec string) (string, error) {
+	pos := 2
+	for pos < len(spec) && spec[pos] != ')' {
+		n := indexUnescaped(spec[pos:], ",)")
+		word := spec[pos : pos+n]
+		pos += n
+		if pos < len(spec) && spec[pos] == ',' {
+			pos++
+		}
+
+		switch {
+		case word == "":
+		case strings.HasPrefix(word, "prefix:"):
+			prefix, err := strconv.Atoi(word[len("prefix:"):])
+			if err != nil || prefix < 0 {
+				return "", fmt.Errorf("invalid parameter for pathspec magic 'prefix'")
+			}
+			item.prefix = prefix
+		case strings.HasPrefix(word, "attr:"):
+			if item.magic&magicAttr != 0 {
+				return "", fmt.Errorf("Only one 'attr:' specification is allowed.")
+			}
+			attrs, err := parseAttrRequirements(word[len("attr:"):])
+			if err != nil {
+				return "", err
+			}
+			item.attrs = attrs
+			item.magic |= magicAttr
+		default:
+			magic, ok := pathspecMagicNames[word]
+			if !ok {
+				return "", fmt.Errorf("Invalid pathspec magic '%s' in '%s'", word, spec)
+			}
+			item.magic |= magic
+		}
+	}
+	if pos >= len(spec) {
+		return "", fmt.Errorf("Missing ')' at the end of pathspec magic in '%s'", spec)
+	}
+	return spec[pos+1:], nil
+}
+
+// parseShortMagic parses the ":/!" form, which ends at the first character
+// that is not magic or at a second colon.
+func (item *pathspecItem) parseShortMagic(spec string) (string, error) {
+	pos := 1
+	for ; pos < len(spec) && spec[pos] != ':'; pos++ {
+		switch c := spec[pos]; {
+		case c == '/':
+			item.magic |= magicTop
+		case c == '!' || c == '^':
+			item.magic |= magicExclude
+		case strings.IndexByte(shortMagicChars, c) != -1:
+			return "", fmt.Errorf("Unimplemented pathspec magic '%c' in '%s'", c, spec)
+		default:
+			return spec[pos:], nil
+		}
+	}
+	if pos < len(spec) {
+		pos++
+	}
+	return spec[pos:], nil
+}
+
+// indexUnescaped returns the index of the first of chars in s that is not
+// escaped with a backslash, or len(s).
+func indexUnescaped(s, chars string) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+		if strings.IndexByte(chars, s[i]) != -1 {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// parseAttrRequirements parses the space-separated body of "attr:" magic.
+func parseAttrRequirements(body string) ([]attrRequirement, error) {
+	if body == "" {
+		return nil, fmt.Errorf("attr spec must not be empty")
+	}
+
+	var attrs []attrRequirement
+	for _, word := range strings.Split(body, " ") {
+		if word == "" {
+			continue
+		}
+		attr := attrRequirement{state: attrSet}
+		switch word[0] {
+		case '-':
+			attr.state = attrUnset
+			word = word[1:]
+		case '!':
+			attr.state = attrUnspecified
+			word = word[1:]
+		}
+		if eq := strings.IndexByte(word, '='); eq != -1 {
+			value, err := unescapeAttrValue(word[eq+1:])
+			if err != nil {
+				return nil, err
+			}
+			attr.state, attr.value = attrValue, value
+			word = word[:eq]
+		}
+		if !validAttrName(word) {
+			return nil, fmt.Errorf("invalid attribute name %s", word)
+		}
+		attr.name = word
+		attrs = append(attrs, attr)
+	}
+	return attrs, nil
+}
+
+func unescapeAttrValue(value string) (string, error) {
+	var out strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' {
+			i++
+			if i == len(value) {
+				return "", fmt.Errorf("Escape character '\\' not allowed as last character in attr value")
+			}
+		}
+		out.WriteByte(value[i])
+	}
+	return out.String(), nil
+}
+
+func validAttrName(name string) bool {
+	if name == "" || name[0] == '-' {
+		return false
+	}
+	for _, c := range name {
+		if c != '-' && c != '.' && c != '_' && !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizePathspecPath resolves "." and ".." components and repeated
+// slashes, keeping a trailing slash. It fails for paths above the top.
+func normalizePathspecPath(pattern string) (string, bool) {
+	var parts []string
+	for _, part := range strings.Split(pattern, "/") {
+		switch part {
+		case "", ".":
+		case "..":
+			if len(parts) == 0 {
+				return "", false
+			}
+			parts = parts[:len(parts)-1]
+		default:
+			parts = append(parts, part)
+		}
+	}
+	match := strings.Join(parts, "/")
+	if match != "" && strings.HasSuffix(pattern, "/") {
+		match += "/"
+	}
+	return match, true
+}
+
+// Match reports whether the pathspec selects the file at path, relative to
+// the top of the worktree.
+func (p *Pathspec) Match(path string) bool {
+	if p == nil || len(p.items) == 0 {
+		return true
+	}
+
+	matched := false
+	for i := range p.items {
+		if p.items[i].magic&magicExclude == 0 && p.matchItem(&p.items[i], path) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return false
+	}
+	for i := range p.items {
+		if p.items[i].magic&magicExclude != 0 && p.matchItem(&p.items[i], path) {
+			return false
+		}
+	}
+	return true
+}
+
+// Filter returns the paths the pathspec selects, looking up the attributes
+// of all of them at once when it has attribute magic.
+func (p *Pathspec) Filter(paths []string) ([]string, error) {
+	if names := p.attrNames(); len(names) > 0 && p.lookup != nil && len(paths) > 0 {
+		attributes, err := p.lookup(paths, names)
+		if err != nil {
+			return nil, err
+		}
+		p.attributes = attributes
+	}
+
+	var matched []string
+	for _, path := range paths {
+		if p.Match(path) {
+			matched = append(matched, path)
+		}
+	}
+	return matched, nil
+}
+
+// HasAttr reports whether any item needs attributes to match.
+func (p *Pathspec) HasAttr() bool {
+	return len(p.attrNames()) > 0
+}
+
+func (p *Pathspec) attrNames() []string {
+	if p == nil {
+		return nil
+	}
+	var names []string
+	seen := make(map[string]bool)
+	for _, item := range p.items {
+		for _, attr := range item.attrs {
+			if !seen[attr.name] {
+				seen[attr.name] = true
+				names = append(names, attr.name)
+			}
+		}
+	}
+	return names
+}
+
+func (p *Pathspec) matchItem(item *pathspecItem, name string) bool {
+	if len(item.attrs) > 0 && !p.matchAttrs(item, name) {
+		return false
+	}
+
+	// The prefix names the directory git was run from; it is never folded
+	if len(name) < item.prefix || name[:item.prefix] != item.match[:item.prefix] {
+		return false
+	}
+	match, rest := item.match[item.prefix:], name[item.prefix:]
+	if match == "" {
+		return true
+	}
+
+	equal := func(a, b string) bool {
+		if item.magic&magicICase != 0 {
+			return strings.EqualFold(a, b)
+		}
+		return a == b
+	}
+
+	// A literal match names the file or a directory above it
+	if len(match) <= len(rest) && equal(match, rest[:len(match)]) {
+		if len(match) == len(rest) || match[len(match)-1] == '/' || rest[len(match)] == '/' {
+			return true
+		}
+	}
+
+	if item.wildcard == nil {
+		return false
+	}
+	literal := item.nowildcard - item.prefix
+	if len(rest) < literal || !equal(match[:literal], rest[:literal]) {
+		return false
+	}
+	return item.wildcard.MatchString(rest)
+}
+
+func (p *Pathspec) matchAttrs(item *pathspecItem, name string) bool {
+	values, ok := p.attributes[name]
+	if !ok {
+		if p.lookup == nil {
+			return false
+		}
+		attributes, err := p.lookup([]string{name}, p.attrNames())
+		if err != nil {
+			return false
+		}
+		if p.attributes == nil {
+			p.attributes = make(map[string]map[string]string)
+		}
+		values = attributes[name]
+		p.attributes[name] = values
+	}
+
+	for _, attr := range item.attrs {
+		value := values[attr.name]
+		switch attr.state {
+		case attrSet:
+			if value != "set" {
+				return false
+			}
+		case attrUnset:
+			if value != "unset" {
+				return false
+			}
+		case attrUnspecified:
+			if value != "unspecified" && value != "" {
+				return false
+			}
+		case attrValue:
+			if value != attr.value || value == "set" || value == "unset" || value == "unspecified" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ParsePathspec parses pathspecs like the function of the same name, and
+// looks up the attributes that attribute magic asks for with "git
+// check-attr".
+func (r *Repository) ParsePathspec(specs []string) (*Pathspec, error) {
+	p, err := ParsePathspec(specs)
+	if err != nil {
+		return nil, err
+	}
+	p.lookup = r.checkAttr
+	return p, nil
+}
+
+// checkAttr runs "git check-attr" for the attributes names of paths.
+func (r *Repository) checkAttr(paths, names []string) (map[string]map[string]string, error) {
+	var stdin bytes.Buffer
+	for _, path := range paths {
+		stdin.WriteString(path)
+		stdin.WriteByte(0)
+	}
+	args := append([]string{"check-attr", "-z", "--stdin"}, names...)
+	output, err := r.RunCommandWithEnv(nil, stdin.Bytes(), args...)
+	if err != nil {
+		return nil, err
+	}
+	return parseCheckAttr(string(output)), nil
+}
+
+// parseCheckAttr parses the "path NUL attribute NUL info NUL" records of
+// "git check-attr -z".
+func parseCheckAttr(output string) map[string]map[string]string {
+	attributes := make(map[string]map[string]string)
+	fields := strings.Split(output, "\x00")
+	for i := 0; i+2 < len(fields); i += 3 {
+		path, name, info := fields[i], fields[i+1], fields[i+2]
+		if attributes[path] == nil {
+			attributes[path] = make(map[string]string)
+		}
+		attributes[path][name] = info
+	}
+	return attributes
+}
@@ -0,0 +1,166 @@
+package git
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPathspecMatch(t *testing.T) {
+	files := []string{
+		"Makefile",
+		"README.md",
+		"docs/api.txt",
+		"docs/guide.md",
+		"main.go",
+		"src/main.go",
+		"src/sub/Deep.GO",
+		"src/sub/deep.go",
+		"star*file",
+		"starXfile",
+		"x/y.c",
+		"x/y/z/w.c",
+	}
+
+	tests := []struct {
+		specs    []string
+		expected []string
+	}{
+		{nil, files},
+		{[]string{"."}, files},
+		{[]string{"src"}, []string{"src/main.go", "src/sub/Deep.GO", "src/sub/deep.go"}},
+		{[]string{"src/"}, []string{"src/main.go", "src/sub/Deep.GO", "src/sub/deep.go"}},
+		{[]string{"./src/../docs//"}, []string{"docs/api.txt", "docs/guide.md"}},
+		{[]string{"x/y"}, []string{"x/y/z/w.c"}},
+		{[]string{"x/y*"}, []string{"x/y.c", "x/y/z/w.c"}},
+		{[]string{"*.go"}, []string{"main.go", "src/main.go", "src/sub/deep.go"}},
+		{[]string{":(glob)*.go"}, []string{"main.go"}},
+		{[]string{":(glob)**/*.go"}, []string{"main.go", "src/main.go", "src/sub/deep.go"}},
+		{[]string{":(glob)src/**"}, []string{"src/main.go", "src/sub/Deep.GO", "src/sub/deep.go"}},
+		{[]string{":(glob)x/**/w.c"}, []string{"x/y/z/w.c"}},
+		{[]string{":(icase)*.go"}, []string{"main.go", "src/main.go", "src/sub/Deep.GO", "src/sub/deep.go"}},
+		{[]string{":(icase)readme.MD"}, []string{"README.md"}},
+		{[]string{":(exclude)src", "*.go"}, []string{"main.go"}},
+		{[]string{":!docs", ":^src", ":!x"}, []string{"Makefile", "README.md", "main.go", "star*file", "starXfile"}},
+		{[]string{":/docs/api.txt"}, []string{"docs/api.txt"}},
+		{[]string{":(top)docs/guide.md"}, []string{"docs/guide.md"}},
+		{[]string{"star*file"}, []string{"star*file", "starXfile"}},
+		{[]string{":(literal)star*file"}, []string{"star*file"}},
+		{[]string{`star\*file`}, []string{"star*file"}},
+		{[]string{"[Mm]a*"}, []string{"Makefile", "main.go"}},
+		{[]string{":(prefix:4)src/sub"}, []string{"src/sub/Deep.GO", "src/sub/deep.go"}},
+		{[]string{":(icase,prefix:4)src/SUB"}, []string{"src/sub/Deep.GO", "src/sub/deep.go"}},
+		{[]string{":(icase,prefix:4)SRC/sub"}, nil},
+	}
+
+	for _, test := range tests {
+		p, err := ParsePathspec(test.specs)
+		if err != nil {
+			t.Errorf("ParsePathspec(%q) failed: %v", test.specs, err)
+			continue
+		}
+		matched, err := p.Filter(files)
+		if err != nil {
+			t.Errorf("Filter for %q failed: %v", test.specs, err)
+			continue
+		}
+		if strings.Join(matched, "|") != strings.Join(test.expected, "|") {
+			t.Errorf("Pathspec %q matched %q, expected %q", test.specs, matched, test.expected)
+		}
+	}
+}
+
+func TestPathspecAttributes(t *testing.T) {
+	attributes := map[string]map[string]string{
+		"a.go":  {"diff": "golang", "text": "set"},
+		"b.md":  {"diff": "unspecified", "text": "set"},
+		"c.bin": {"diff": "unset", "text": "unset"},
+	}
+	lookups := 0
+	lookup := func(paths, names []string) (map[string]map[string]string, error) {
+		lookups++
+		result := make(map[string]map[string]string)
+		for _, path := range paths {
+			result[path] = attributes[path]
+		}
+		return result, nil
+	}
+	files := []string{"a.go", "b.md", "c.bin"}
+
+	tests := []struct {
+		spec     string
+		expected []string
+	}{
+		{":(attr:text)", []string{"a.go", "b.md"}},
+		{":(attr:-text)", []string{"c.bin"}},
+		{":(attr:!diff)", []string{"b.md"}},
+		{":(attr:diff=golang)", []string{"a.go"}},
+		{":(attr:text -diff)", nil},
+		{":(attr:text !diff)*.md", []string{"b.md"}},
+	}
+
+	for _, test := range tests {
+		p, err := ParsePathspec([]string{test.spec})
+		if err != nil {
+			t.Errorf("ParsePathspec(%q) failed: %v", test.spec, err)
+			continue
+		}
+		if !p.HasAttr() {
+			t.Errorf("Pathspec %q should need attributes", test.spec)
+		}
+		p.lookup = lookup
+		lookups = 0
+		matched, err := p.Filter(files)
+		if err != nil {
+			t.Errorf("Filter for %q failed: %v", test.spec, err)
+			continue
+		}
+		if strings.Join(matched, "|") != strings.Join(test.expected, "|") {
+			t.Errorf("Pathspec %q matched %q, expected %q", test.spec, matched, test.expected)
+		}
+		if lookups != 1 {
+			t.Errorf("Expected one lookup for %q, got %d", test.spec, lookups)
+		}
+	}
+}
+
+func TestParsePathspecErrors(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected string
+	}{
+		{"", "empty string is not a valid pathspec. please use . instead if you meant to match all paths"},
+		{":(foo)x", "Invalid pathspec magic 'foo' in ':(foo)x'"},
+		{":(glob", "Missing ')' at the end of pathspec magic in ':(glob'"},
+		{":(literal,glob)x", ":(literal,glob)x: 'literal' and 'glob' are incompatible"},
+		{":(attr:a,attr:b)x", "Only one 'attr:' specification is allowed."},
+		{":(attr:)x", "attr spec must not be empty"},
+		{":(attr:-)x", "invalid attribute name "},
+		{`:(attr:a=b\ c)x`, `Escape character '\' not allowed as last character in attr value`},
+		{":(prefix:x)a", "invalid parameter for pathspec magic 'prefix'"},
+		{":%x", "Unimplemented pathspec magic '%' in ':%x'"},
+		{"../x", "../x: '../x' is outside repository"},
+	}
+
+	for _, test := range tests {
+		_, err := ParsePathspec([]string{test.spec})
+		if err == nil {
+			t.Errorf("ParsePathspec(%q) should fail", test.spec)
+			continue
+		}
+		if err.Error() != test.expected {
+			t.Errorf("ParsePathspec(%q) failed with %q, expected %q", test.spec, err, test.expected)
+		}
+	}
+}
+
+func TestParseCheckAttr(t *testing.T) {
+	output := "a.go\x00diff\x00golang\x00a.go\x00text\x00set\x00b b\x00diff\x00unspecified\x00"
+	attributes := parseCheckAttr(output)
+
+	if attributes["a.go"]["diff"] != "golang" || attributes["a.go"]["text"] != "set" {
+		t.Errorf("Unexpected attributes for a.go: %v", attributes["a.go"])
+	}
+	if attributes["b b"]["diff"] != "unspecified" {
+		t.Errorf("Unexpected attributes for b b: %v", attributes["b b"])
+	}
+}
@@ -11,38 +11,67 @@ import (
 )
 
 type Repository struct {
-	gitDir   string
-	workTree string
+	gitDir    string
+	workTree  string
+	backend   Backend
+	emptyTree string
+	wordDiff  bool // Highlight changed words, as interactive.wordDiff does
 }
 
 func NewRepository(path string) (*Repository, error) {
-	cmd := exec.Command("git", "rev-parse", "--git-dir")
+	cmd := exec.Command("git", "rev-parse", "--git-dir", "--show-toplevel")
 	cmd.Dir = path
 	output, err := cmd.Output()
 	if err != nil {
 		return nil, fmt.Errorf("not a git repository")
 	}
 
-	gitDir := strings.TrimSpace(string(output))
-	if !filepath.IsAbs(gitDir) {
-		gitDir = filepath.Join(path, gitDir)
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) != 2 {
+		return nil, fmt.Errorf("could not determine work tree")
 	}
 
-	workTreeCmd := exec.Command("git", "rev-parse", "--show-toplevel")
-	workTreeCmd.Dir = path
-	workTreeOutput, err := workTreeCmd.Output()
-	if err != nil {
-		return nil, fmt.Errorf("could not determine work tree: %v", err)
+	gitDir := lines[0]
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(path, gitDir)
 	}
 
-	workTree := strings.TrimSpace(string(workTreeOutput))
-
 	return &Repository{
 		gitDir:   gitDir,
-		workTree: workTree,
+		workTree: lines[1],
 	}, nil
 }
 
+// Backend returns the backend that answers config, HEAD, status and diff
+// queries: the native one when interactive.backend is "native" and the
+// repository allows it, otherwise the caching one, unless SetBackend chose
+// another.
+func (r *Repository) Backend() Backend {
+	if r.backend == nil {
+		r.backend = NewCachingBackend(r)
+		if name, _ := r.backend.Config("interactive.backend"); name == "native" {
+			if native, err := NewNativeBackend(r, r.backend); err == nil {
+				r.backend = native
+			} else {
+				fmt.Fprintf(os.Stderr, "warning: %v; using git\n", err)
+			}
+		}
+	}
+	return r.backend
+}
+
+// SetBackend replaces the backend that answers config, HEAD and diff
+// queries.
+func (r *Repository) SetBackend(backend Backend) {
+	r.backend = backend
+}
+
+// Invalidate tells the backend that paths, or everything when none are
+// given, may have changed behind its back.
+func (r *Repository) Invalidate(paths ...string) {
+	r.Backend().Invalidate(paths...)
+}
+
 func (r *Repository) GitDir() string {
 	return r.gitDir
 }
@@ -65,52 +94,151 @@ func (r *Repository) RunCommandWithStdin(stdin []byte, args ...string) error {
 	cmd := exec.Command("git", args...)
 	cmd.Dir = r.workTree
 	cmd.Stdin = bytes.NewReader(stdin)
-	return cmd.Run()
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git command failed: %v\nCommand: git %v\nOutput: %s", err, args, string(output))
+	}
+	return nil
 }
 
-func (r *Repository) GetConfig(key string) (string, error) {
-	output, err := r.RunCommand("config", key)
+func (r *Repository) RunCommandWithEnv(env []string, stdin []byte, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.workTree
+	cmd.Env = append(os.Environ(), env...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	output, err := cmd.CombinedOutput()
 	if err != nil {
-		return "", err
+		return output, fmt.Errorf("git command failed: %v\nCommand: git %v\nOutput: %s", err, args, string(output))
 	}
-	return strings.TrimSpace(string(output)), nil
+	return output, nil
 }
 
-func (r *Repository) GetConfigBool(key string) bool {
-	output, err := r.RunCommand("config", "--bool", key)
+// commandOutput runs a git command with stdin as its input and returns its
+// standard output alone, so warnings cannot end up in content it prints.
+func (r *Repository) commandOutput(stdin []byte, args ...string) ([]byte, error) {
+	return r.commandOutputWithEnv(nil, stdin, args...)
+}
+
+// commandOutputWithEnv is commandOutput with env added to the environment.
+func (r *Repository) commandOutputWithEnv(env []string, stdin []byte, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.workTree
+	if env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
 	if err != nil {
+		return nil, fmt.Errorf("git command failed: %v\nCommand: git %v\nOutput: %s", err, args, stderr.String())
+	}
+	return output, nil
+}
+
+func (r *Repository) GetConfig(key string) (string, error) {
+	value, ok := r.Backend().Config(key)
+	if !ok {
+		return "", fmt.Errorf("config %s is not set", key)
+	}
+	return value, nil
+}
+
+func (r *Repository) GetConfigBool(key string) bool {
+	value, ok := r.Backend().Config(key)
+	if !ok {
 		return false
 	}
-	return strings.TrimSpace(string(output)) == "true"
+	use, err := parseConfigBool(value)
+	return err == nil && use
 }
 
-func (r *Repository) GetColor(key, defaultColor string) string {
-	output, err := r.RunCommand("config", "--get-color", key, defaultColor)
-	if err != nil {
+// RenameDetectionArg returns the diff option selected by diff.renames: -M
+// for renames (the default), -C to also find copies, or "" when disabled.
+func (r *Repository) RenameDetectionArg() string {
+	value, err := r.GetConfig("diff.renames")
+	if err != nil || value == "" {
+		return "-M"
+	}
+
+	switch strings.ToLower(value) {
+	case "copies", "copy":
+		return "-C"
+	case "false", "no", "off", "0":
 		return ""
 	}
-	return string(output)
+	return "-M"
 }
 
+func (r *Repository) GetColor(key, defaultColor string) string {
+	return r.Backend().Color(key, defaultColor)
+}
+
+// GetColorBool reports whether output to a terminal should be colored, from
+// key or else color.ui.
 func (r *Repository) GetColorBool(key string) bool {
-	output, err := r.RunCommand("config", "--get-colorbool", key, "true")
-	if err != nil {
-		return false
+	if value, ok := r.Backend().Config(key); ok {
+		return parseColorBool(value)
+	}
+	if value, ok := r.Backend().Config("color.ui"); ok {
+		return parseColorBool(value)
 	}
-	return strings.TrimSpace(string(output)) == "true"
+	return parseColorBool("auto")
+}
+
+// Pager returns the command that output of the git command named command
+// should be paged through, or "" when it should not be paged. pager.<command>
+// can turn paging off or name its own pager; otherwise $GIT_PAGER,
+// core.pager and $PAGER are tried in turn before falling back to less.
+func (r *Repository) Pager(command string) string {
+	var pager string
+	if command != "" {
+		if value, ok := r.Backend().Config("pager." + command); ok {
+			if use, err := parseConfigBool(value); err == nil {
+				if !use {
+					return ""
+				}
+			} else {
+				pager = value
+			}
+		}
+	}
+
+	if env, ok := os.LookupEnv("GIT_PAGER"); ok {
+		pager = env
+	} else if pager == "" {
+		if value, ok := r.Backend().Config("core.pager"); ok {
+			pager = value
+		} else if env, ok := os.LookupEnv("PAGER"); ok {
+			pager = env
+		} else {
+			pager = "less"
+		}
+	}
+
+	if pager == "cat" {
+		return ""
+	}
+	return pager
 }
 
 func (r *Repository) IsInitialCommit() bool {
-	_, err := r.RunCommand("rev-parse", "HEAD")
-	return err != nil
+	_, ok := r.Backend().Head()
+	return !ok
 }
 
 func (r *Repository) GetEmptyTree() (string, error) {
+	if r.emptyTree != "" {
+		return r.emptyTree, nil
+	}
 	output, err := r.RunCommand("hash-object", "-t", "tree", "/dev/null")
 	if err != nil {
 		return "", err
 	}
-	return strings.TrimSpace(string(output)), nil
+	r.emptyTree = strings.TrimSpace(string(output))
+	return r.emptyTree, nil
 }
 
 func (r *Repository) UpdateIndex() error {
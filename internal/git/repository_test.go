@@ -2,9 +2,39 @@ package git
 
 import (
 	"os"
+	"os/exec"
 	"testing"
 )
 
+// newScratchRepository creates an empty repository cut off from the user's
+// configuration, and returns its directory and a function running git in it.
+func newScratchRepository(t *testing.T) (string, func(args ...string) string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_AUTHOR_NAME", "Test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "Test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, output)
+		}
+		return string(output)
+	}
+	git("init", "-q")
+	return dir, git
+}
+
 func TestNewRepository(t *testing.T) {
 	wd, err := os.Getwd()
 	if err != nil {
@@ -55,3 +85,43 @@ func TestIsInitialCommit(t *testing.T) {
 
 	repo.IsInitialCommit()
 }
+
+func TestPager(t *testing.T) {
+	tests := []struct {
+		name     string
+		command  string
+		config   map[string]string
+		env      map[string]string
+		expected string
+	}{
+		{name: "default", expected: "less"},
+		{name: "PAGER", env: map[string]string{"PAGER": "more"}, expected: "more"},
+		{name: "core.pager", config: map[string]string{"core.pager": "most"}, env: map[string]string{"PAGER": "more"}, expected: "most"},
+		{name: "GIT_PAGER", config: map[string]string{"core.pager": "most"}, env: map[string]string{"GIT_PAGER": "lv"}, expected: "lv"},
+		{name: "pager.diff command", command: "diff", config: map[string]string{"core.pager": "most", "pager.diff": "delta"}, expected: "delta"},
+		{name: "pager.diff off", command: "diff", config: map[string]string{"pager.diff": "false"}, env: map[string]string{"GIT_PAGER": "lv"}, expected: ""},
+		{name: "pager.diff on", command: "diff", config: map[string]string{"pager.diff": "true", "core.pager": "most"}, expected: "most"},
+		{name: "other command", command: "log", config: map[string]string{"pager.diff": "false"}, expected: "less"},
+		{name: "cat", env: map[string]string{"GIT_PAGER": "cat"}, expected: ""},
+		{name: "empty", config: map[string]string{"core.pager": ""}, expected: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, name := range []string{"GIT_PAGER", "PAGER"} {
+				t.Setenv(name, "")
+				if value, ok := test.env[name]; ok {
+					os.Setenv(name, value)
+				} else {
+					os.Unsetenv(name)
+				}
+			}
+
+			repo := &Repository{}
+			repo.SetBackend(&stubBackend{config: test.config})
+			if pager := repo.Pager(test.command); pager != test.expected {
+				t.Errorf("Pager(%q) = %q, expected %q", test.command, pager, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// CreateStash records the given patch (relative to HEAD) as a new stash entry
+// and removes the stashed changes from the worktree and, where they apply,
+// from the index. The stash commit layout matches `git stash`: the worktree
+// commit has HEAD and the index commit as parents.
+func (r *Repository) CreateStash(patch []byte, message string) error {
+	if r.IsInitialCommit() {
+		return fmt.Errorf("you do not have the initial commit yet")
+	}
+
+	headRev, _ := r.Backend().Head()
+
+	branch := "(no branch)"
+	if output, err := r.RunCommand("symbolic-ref", "--short", "-q", "HEAD"); err == nil {
+		branch = strings.TrimSpace(string(output))
+	}
+
+	subject, err := r.RunCommand("log", "--no-show-signature", "-1", "--format=%h %s", headRev)
+	if err != nil {
+		return err
+	}
+	headSubject := strings.TrimSpace(string(subject))
+
+	indexTree, err := r.RunCommand("write-tree")
+	if err != nil {
+		return err
+	}
+	indexCommit, err := r.RunCommand("commit-tree", "-p", headRev,
+		"-m", stashMessage("index on", branch, headSubject),
+		strings.TrimSpace(string(indexTree)))
+	if err != nil {
+		return err
+	}
+
+	worktreeTree, err := r.stashWorktreeTree(patch)
+	if err != nil {
+		return err
+	}
+
+	if message == "" {
+		message = stashMessage("WIP on", branch, headSubject)
+	} else {
+		message = stashMessage("On", branch, message)
+	}
+
+	stashCommit, err := r.RunCommand("commit-tree", "-p", headRev,
+		"-p", strings.TrimSpace(string(indexCommit)),
+		"-m", message, worktreeTree)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.RunCommand("update-ref", "--create-reflog", "-m", message,
+		"refs/stash", strings.TrimSpace(string(stashCommit))); err != nil {
+		return err
+	}
+
+	defer r.Backend().Invalidate()
+	if err := r.RunCommandWithStdin(patch, "apply", "-R", "--allow-overlap"); err != nil {
+		return fmt.Errorf("cannot remove stashed changes from worktree: %v", err)
+	}
+
+	// Staged copies of the stashed hunks are dropped as well, one hunk at a
+	// time; hunks that were never staged do not apply to the index and are
+	// skipped without holding back the others.
+	for _, hunk := range splitPatchHunks(patch) {
+		if err := r.RunCommandWithStdin(hunk, "apply", "-R", "--cached", "--check", "--allow-overlap"); err != nil {
+			continue
+		}
+		if err := r.RunCommandWithStdin(hunk, "apply", "-R", "--cached", "--allow-overlap"); err != nil {
+			return fmt.Errorf("cannot remove stashed changes from index: %v", err)
+		}
+	}
+
+	r.UpdateIndex()
+	return nil
+}
+
+// stashWorktreeTree builds the tree of HEAD plus the selected hunks using a
+// temporary index so the real index is left alone.
+func (r *Repository) stashWorktreeTree(patch []byte) (string, error) {
+	tmpIndex := r.RepoPath(fmt.Sprintf("addp-stash-index-%d", os.Getpid()))
+	defer os.Remove(tmpIndex)
+
+	env := []string{"GIT_INDEX_FILE=" + tmpIndex}
+
+	if _, err := r.RunCommandWithEnv(env, nil, "read-tree", "HEAD"); err != nil {
+		return "", err
+	}
+	if _, err := r.RunCommandWithEnv(env, patch, "apply", "--cached", "--allow-overlap"); err != nil {
+		return "", err
+	}
+	tree, err := r.RunCommandWithEnv(env, nil, "write-tree")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(tree)), nil
+}
+
+// splitPatchHunks splits patch into patches of one hunk each, every one with
+// the header of its file. A file section without hunks, such as a mode
+// change or a binary patch, stays whole.
+func splitPatchHunks(patch []byte) [][]byte {
+	var patches [][]byte
+	var header, hunk []string
+	flush := func() {
+		if len(hunk) > 0 {
+			patches = append(patches, []byte(strings.Join(append(header[:len(header):len(header)], hunk...), "\n")+"\n"))
+		} else if len(header) > 0 {
+			patches = append(patches, []byte(strings.Join(header, "\n")+"\n"))
+		}
+		hunk = nil
+	}
+
+	lines := strings.Split(string(patch), "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			flush()
+			header = []string{line}
+		case strings.HasPrefix(line, "@@ "):
+			if len(hunk) > 0 {
+				flush()
+			}
+			hunk = []string{line}
+		case hunk != nil:
+			hunk = append(hunk, line)
+		default:
+			header = append(header, line)
+		}
+	}
+	flush()
+	return patches
+}
+
+func stashMessage(prefix, branch, subject string) string {
+	return fmt.Sprintf("%s %s: %s", prefix, branch, subject)
+}
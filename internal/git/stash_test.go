@@ -0,0 +1,101 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStashMessage(t *testing.T) {
+	tests := []struct {
+		prefix   string
+		branch   string
+		subject  string
+		expected string
+	}{
+		{"WIP on", "master", "87a3bf8 init", "WIP on master: 87a3bf8 init"},
+		{"index on", "feature/x", "abc1234 fix bug", "index on feature/x: abc1234 fix bug"},
+		{"On", "(no branch)", "my message", "On (no branch): my message"},
+	}
+
+	for _, test := range tests {
+		result := stashMessage(test.prefix, test.branch, test.subject)
+		if result != test.expected {
+			t.Errorf("stashMessage(%q, %q, %q) = %q, expected %q",
+				test.prefix, test.branch, test.subject, result, test.expected)
+		}
+	}
+}
+
+func TestSplitPatchHunks(t *testing.T) {
+	patch := `diff --git a/f b/f
+index 1111111..2222222 100644
+--- a/f
++++ b/f
+@@ -1,2 +1,2 @@
+-a
++A
+ b
+@@ -9,2 +9,2 @@
+ i
+-j
++J
+diff --git a/x b/x
+old mode 100644
+new mode 100755
+`
+	expected := []string{
+		"diff --git a/f b/f\nindex 1111111..2222222 100644\n--- a/f\n+++ b/f\n@@ -1,2 +1,2 @@\n-a\n+A\n b\n",
+		"diff --git a/f b/f\nindex 1111111..2222222 100644\n--- a/f\n+++ b/f\n@@ -9,2 +9,2 @@\n i\n-j\n+J\n",
+		"diff --git a/x b/x\nold mode 100644\nnew mode 100755\n",
+	}
+
+	patches := splitPatchHunks([]byte(patch))
+	if len(patches) != len(expected) {
+		t.Fatalf("Expected %d patches, got %d: %q", len(expected), len(patches), patches)
+	}
+	for i, p := range patches {
+		if string(p) != expected[i] {
+			t.Errorf("patches[%d] = %q, expected %q", i, p, expected[i])
+		}
+	}
+}
+
+func TestCreateStashStagedAndUnstaged(t *testing.T) {
+	dir, git := newScratchRepository(t)
+	write := func(content string) {
+		if err := os.WriteFile(filepath.Join(dir, "f"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n")
+	git("add", "f")
+	git("commit", "-q", "-m", "init")
+
+	// The first change is staged, the second only in the worktree
+	write("A\nb\nc\nd\ne\nf\ng\nh\ni\nj\n")
+	git("add", "f")
+	write("A\nb\nc\nd\ne\nf\ng\nh\ni\nJ\n")
+
+	repo, err := NewRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patch := git("diff", "HEAD", "--", "f")
+	if err := repo.CreateStash([]byte(patch), "both"); err != nil {
+		t.Fatalf("CreateStash() failed: %v", err)
+	}
+
+	if shown := git("stash", "show", "-p"); !strings.Contains(shown, "+A") || !strings.Contains(shown, "+J") {
+		t.Errorf("Expected the stash to hold both changes, got:\n%s", shown)
+	}
+	if staged := git("diff", "--cached"); staged != "" {
+		t.Errorf("Expected the staged change to leave the index, got:\n%s", staged)
+	}
+	if changed := git("diff"); changed != "" {
+		t.Errorf("Expected the worktree to match HEAD, got:\n%s", changed)
+	}
+}
@@ -2,6 +2,7 @@ package git
 
 import (
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -14,6 +15,18 @@ type FileStatus struct {
 	IndexAddDel string
 	FileAddDel  string
 	Unmerged    bool
+	OldPath     string // Source path when the file was renamed or copied
+	Similarity  int    // Similarity percentage of a rename or copy
+	Copy        bool   // OldPath was copied rather than renamed
+}
+
+// DisplayPath returns the path as shown in file listings, including the
+// source of a rename or copy.
+func (f FileStatus) DisplayPath() string {
+	if f.OldPath != "" {
+		return f.OldPath + " -> " + f.Path
+	}
+	return f.Path
 }
 
 func (r *Repository) ListModified(filter string) ([]FileStatus, error) {
@@ -26,7 +39,14 @@ func (r *Repository) ListModifiedWithRevision(filter, revision string) ([]FileSt
 
 func (r *Repository) ListModifiedWithRevisionAndPaths(filter, revision string, paths []string) ([]FileStatus, error) {
 	var files []FileStatus
-	statusMap := make(map[string]*FileStatus)
+
+	spec, err := r.ParsePathspec(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	// A fresh listing starts a fresh batch of diffs over the same paths
+	r.Backend().SetDiffScope(paths)
 
 	reference := "HEAD"
 	if revision != "" {
@@ -40,23 +60,67 @@ func (r *Repository) ListModifiedWithRevisionAndPaths(filter, revision string, p
 		reference = emptyTree
 	}
 
+	statusMap, err := r.Backend().Status(filter, reference, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := make([]string, 0, len(statusMap))
+	for path := range statusMap {
+		changed = append(changed, path)
+	}
+	sort.Strings(changed)
+	selected, err := spec.Filter(changed)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range selected {
+		status := statusMap[path]
+		if filter == "index-only" && status.Index == "unchanged" {
+			continue
+		}
+		if filter == "file-only" && status.File == "nothing" {
+			continue
+		}
+
+		if status.Unmerged {
+			status.File = "unmerged"
+		}
+
+		status.Path = path
+		files = append(files, *status)
+	}
+
+	return files, nil
+}
+
+// Status lists changes from the numstat and summary output of diff-index
+// and diff-files, with renames detected as diff.renames asks.
+func (b *execBackend) Status(filter, reference string, paths []string) (map[string]*FileStatus, error) {
+	statusMap := make(map[string]*FileStatus)
+
 	// Only run diff-index if we're not doing file-only filtering
 	if filter != "file-only" {
 		// Build the diff-index command with optional paths
-		indexCmd := []string{"diff-index", "--cached", "--numstat", "--summary", reference}
+		indexCmd := []string{"diff-index", "--cached", "--numstat", "--summary"}
+		if renames := b.repo.RenameDetectionArg(); renames != "" {
+			indexCmd = append(indexCmd, renames)
+		}
+		indexCmd = append(indexCmd, reference)
 		if len(paths) > 0 {
 			indexCmd = append(indexCmd, "--")
 			indexCmd = append(indexCmd, paths...)
 		} else {
 			indexCmd = append(indexCmd, "--")
 		}
-		indexLines, err := r.RunCommandLines(indexCmd...)
+		indexLines, err := b.repo.RunCommandLines(indexCmd...)
 		if err != nil {
 			return nil, err
 		}
 
 		for _, line := range indexLines {
-			if err := r.parseIndexLine(line, statusMap); err != nil {
+			if err := b.repo.parseIndexLine(line, statusMap); err != nil {
 				continue
 			}
 		}
@@ -66,43 +130,37 @@ func (r *Repository) ListModifiedWithRevisionAndPaths(filter, revision string, p
 	if filter != "index-only" {
 		// Build the diff-files command with optional paths
 		fileCmd := []string{"diff-files", "--ignore-submodules=dirty", "--numstat", "--summary", "--raw"}
+		if renames := b.repo.RenameDetectionArg(); renames != "" {
+			fileCmd = append(fileCmd, renames)
+		}
 		if len(paths) > 0 {
 			fileCmd = append(fileCmd, "--")
 			fileCmd = append(fileCmd, paths...)
 		} else {
 			fileCmd = append(fileCmd, "--")
 		}
-		fileLines, err := r.RunCommandLines(fileCmd...)
+		fileLines, err := b.repo.RunCommandLines(fileCmd...)
 		if err != nil {
 			return nil, err
 		}
 
 		for _, line := range fileLines {
-			if err := r.parseFileLine(line, statusMap); err != nil {
+			if err := b.repo.parseFileLine(line, statusMap); err != nil {
 				continue
 			}
 		}
 	}
 
-	for path, status := range statusMap {
-		if filter == "index-only" && status.Index == "unchanged" {
-			continue
-		}
-		if filter == "file-only" && status.File == "nothing" {
-			continue
-		}
-
-		status.Path = path
-		files = append(files, *status)
-	}
-
-	return files, nil
+	return statusMap, nil
 }
 
 func (r *Repository) parseIndexLine(line string, statusMap map[string]*FileStatus) error {
 	parts := strings.Split(line, "\t")
 	if len(parts) >= 3 {
 		add, del, file := parts[0], parts[1], parts[2]
+		if _, newPath, ok := parseRenamePath(file); ok {
+			file = newPath
+		}
 		file = unquotePath(file)
 
 		status := statusMap[file]
@@ -138,6 +196,10 @@ func (r *Repository) parseIndexLine(line string, statusMap map[string]*FileStatu
 		return nil
 	}
 
+	if parseRenameSummary(line, statusMap) {
+		return nil
+	}
+
 	return nil
 }
 
@@ -145,6 +207,9 @@ func (r *Repository) parseFileLine(line string, statusMap map[string]*FileStatus
 	parts := strings.Split(line, "\t")
 	if len(parts) >= 3 {
 		add, del, file := parts[0], parts[1], parts[2]
+		if _, newPath, ok := parseRenamePath(file); ok {
+			file = newPath
+		}
 		file = unquotePath(file)
 
 		status := statusMap[file]
@@ -180,6 +245,10 @@ func (r *Repository) parseFileLine(line string, statusMap map[string]*FileStatus
 		return nil
 	}
 
+	if parseRenameSummary(line, statusMap) {
+		return nil
+	}
+
 	rawRe := regexp.MustCompile(`^:[0-7]+ [0-7]+ [0-9a-f]{7,40} [0-9a-f]{7,40} (.)\t(.*)$`)
 	if matches := rawRe.FindStringSubmatch(line); len(matches) == 3 {
 		statusType, file := matches[1], unquotePath(matches[2])
@@ -201,7 +270,53 @@ func (r *Repository) parseFileLine(line string, statusMap map[string]*FileStatus
 }
 
 func (r *Repository) ListUntracked() ([]string, error) {
-	lines, err := r.RunCommandLines("ls-files", "--others", "--exclude-standard", "--")
+	return r.Backend().Untracked()
+}
+
+// AddIntentToAdd records untracked paths in the index with "git add -N", so
+// that the diff of the worktree shows their content as an addition.
+// update-index has no option for this.
+func (r *Repository) AddIntentToAdd(paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	args := append([]string{"add", "--intent-to-add", "--"}, paths...)
+	_, err := r.RunCommand(args...)
+	r.Invalidate(paths...)
+	return err
+}
+
+// RemoveIntentToAdd takes those of paths that are still only intended to be
+// added out of the index again, and returns them. Paths whose content has
+// been staged in the meantime are left alone.
+func (r *Repository) RemoveIntentToAdd(paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	args := append([]string{"diff-files", "--name-only", "--diff-filter=A", "-z", "--"}, paths...)
+	output, err := r.RunCommand(args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []string
+	for _, path := range strings.Split(string(output), "\x00") {
+		if path != "" {
+			pending = append(pending, path)
+		}
+	}
+	if len(pending) == 0 {
+		return nil, nil
+	}
+
+	args = append([]string{"update-index", "--force-remove", "--"}, pending...)
+	_, err = r.RunCommand(args...)
+	r.Invalidate(pending...)
+	return pending, err
+}
+
+func (b *execBackend) Untracked() ([]string, error) {
+	lines, err := b.repo.RunCommandLines("ls-files", "--others", "--exclude-standard", "--")
 	if err != nil {
 		return nil, err
 	}
@@ -216,6 +331,62 @@ func (r *Repository) ListUntracked() ([]string, error) {
 	return untracked, nil
 }
 
+var renameSummaryRe = regexp.MustCompile(`^ (rename|copy) (.*) \((\d+)%\)$`)
+
+// parseRenameSummary records the source and similarity of a rename or copy
+// from a --summary line such as " rename dir/{a => b}.txt (90%)".
+func parseRenameSummary(line string, statusMap map[string]*FileStatus) bool {
+	matches := renameSummaryRe.FindStringSubmatch(line)
+	if len(matches) != 4 {
+		return false
+	}
+
+	oldPath, newPath, ok := parseRenamePath(matches[2])
+	if !ok {
+		return false
+	}
+	newPath = unquotePath(newPath)
+
+	status := statusMap[newPath]
+	if status == nil {
+		status = &FileStatus{
+			Index: "unchanged",
+			File:  "nothing",
+		}
+		statusMap[newPath] = status
+	}
+	status.OldPath = unquotePath(oldPath)
+	status.Similarity, _ = strconv.Atoi(matches[3])
+	status.Copy = matches[1] == "copy"
+	return true
+}
+
+// parseRenamePath splits the "old => new" notation used by --numstat and
+// --summary, including the "dir/{old => new}/file" form for common parts.
+func parseRenamePath(path string) (oldPath, newPath string, ok bool) {
+	if open := strings.Index(path, "{"); open != -1 {
+		if end := strings.Index(path[open:], "}"); end != -1 {
+			end += open
+			if parts := strings.SplitN(path[open+1:end], " => ", 2); len(parts) == 2 {
+				prefix, suffix := path[:open], path[end+1:]
+				return cleanRenamePath(prefix + parts[0] + suffix), cleanRenamePath(prefix + parts[1] + suffix), true
+			}
+		}
+	}
+
+	if parts := strings.SplitN(path, " => ", 2); len(parts) == 2 {
+		return parts[0], parts[1], true
+	}
+
+	return "", "", false
+}
+
+// cleanRenamePath drops the doubled or leading slash left behind when one
+// side of a "{old => new}" group is empty.
+func cleanRenamePath(path string) string {
+	return strings.TrimPrefix(strings.ReplaceAll(path, "//", "/"), "/")
+}
+
 func unquotePath(path string) string {
 	if len(path) >= 2 && path[0] == '"' && path[len(path)-1] == '"' {
 		if unquoted, err := strconv.Unquote(path); err == nil {
@@ -177,3 +177,70 @@ func TestListModifiedWithRevisionAndPathsFilters(t *testing.T) {
 		t.Error("Expected error for non-git directory, but got none")
 	}
 }
+
+func TestParseRenamePath(t *testing.T) {
+	tests := []struct {
+		input       string
+		expectedOld string
+		expectedNew string
+		expectedOk  bool
+	}{
+		{"f => d/f2", "f", "d/f2", true},
+		{"src/{old.go => new.go}", "src/old.go", "src/new.go", true},
+		{"{a => b}/file.txt", "a/file.txt", "b/file.txt", true},
+		{"{ => sub}/file.txt", "file.txt", "sub/file.txt", true},
+		{"dir/{sub => }/file.txt", "dir/sub/file.txt", "dir/file.txt", true},
+		{"plain.txt", "", "", false},
+	}
+
+	for _, test := range tests {
+		oldPath, newPath, ok := parseRenamePath(test.input)
+		if oldPath != test.expectedOld || newPath != test.expectedNew || ok != test.expectedOk {
+			t.Errorf("parseRenamePath(%q) = (%q, %q, %v), expected (%q, %q, %v)",
+				test.input, oldPath, newPath, ok, test.expectedOld, test.expectedNew, test.expectedOk)
+		}
+	}
+}
+
+func TestParseRenameLines(t *testing.T) {
+	repo := &Repository{}
+	statusMap := make(map[string]*FileStatus)
+
+	lines := []string{
+		"1\t0\tsrc/{old.go => new.go}",
+		" rename src/{old.go => new.go} (76%)",
+		" copy base.txt => copy.txt (100%)",
+	}
+	for _, line := range lines {
+		if err := repo.parseIndexLine(line, statusMap); err != nil {
+			t.Fatalf("Failed to parse line %q: %v", line, err)
+		}
+	}
+
+	renamed := statusMap["src/new.go"]
+	if renamed == nil {
+		t.Fatal("Expected src/new.go in status map")
+	}
+	if renamed.Index != "+1/-0" {
+		t.Errorf("Expected Index=+1/-0, got %s", renamed.Index)
+	}
+	if renamed.OldPath != "src/old.go" || renamed.Similarity != 76 || renamed.Copy {
+		t.Errorf("Unexpected rename info: %+v", renamed)
+	}
+	renamed.Path = "src/new.go"
+	if renamed.DisplayPath() != "src/old.go -> src/new.go" {
+		t.Errorf("Unexpected display path %q", renamed.DisplayPath())
+	}
+
+	copied := statusMap["copy.txt"]
+	if copied == nil {
+		t.Fatal("Expected copy.txt in status map")
+	}
+	if copied.OldPath != "base.txt" || copied.Similarity != 100 || !copied.Copy {
+		t.Errorf("Unexpected copy info: %+v", copied)
+	}
+
+	if _, exists := statusMap["src/old.go"]; exists {
+		t.Error("Rename source should not be listed on its own")
+	}
+}
@@ -0,0 +1,185 @@
+package git
+
+import (
+	"regexp"
+	"strings"
+)
+
+// defaultWordRe splits a line into runs of word characters, runs of
+// whitespace and single other characters when diff.wordRegex is not set.
+var defaultWordRe = regexp.MustCompile(`[\p{L}\p{N}_]+|\s+|.`)
+
+// EnableWordDiff highlights the changed words of paired removed and added
+// lines, as interactive.wordDiff does.
+func (r *Repository) EnableWordDiff() {
+	r.wordDiff = true
+}
+
+// wordDiffRe returns the expression that splits lines into words when word
+// highlighting is on, or nil when it is off or diff.wordRegex is invalid.
+func (r *Repository) wordDiffRe() *regexp.Regexp {
+	if !r.wordDiff && !r.GetConfigBool("interactive.worddiff") {
+		return nil
+	}
+	pattern, err := r.GetConfig("diff.wordregex")
+	if err != nil || pattern == "" {
+		return defaultWordRe
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil
+	}
+	return re
+}
+
+// wordColors are the colors of changed lines and of the words changed
+// within them.
+type wordColors struct {
+	old, new                   string
+	oldHighlight, newHighlight string
+	reset                      string
+}
+
+func (r *Repository) wordColors() wordColors {
+	return wordColors{
+		old:          r.GetColor("color.diff.old", "red"),
+		new:          r.GetColor("color.diff.new", "green"),
+		oldHighlight: r.GetColor("color.diff-highlight.oldhighlight", "red reverse"),
+		newHighlight: r.GetColor("color.diff-highlight.newhighlight", "green reverse"),
+		reset:        r.GetColor("", "reset"),
+	}
+}
+
+// highlightWords redraws the display of each run of removed lines followed
+// by as many added lines, marking the words that differ between the lines
+// at the same position. Runs of different lengths are left alone, as there
+// is no telling which lines belong together.
+func highlightWords(hunks []Hunk, wordRe *regexp.Regexp, colors wordColors) {
+	for h := range hunks {
+		hunk := &hunks[h]
+		if hunk.Type != HunkTypeHunk || len(hunk.Display) != len(hunk.Text) {
+			continue
+		}
+
+		for i := 1; i < len(hunk.Text); {
+			removed := changedRun(hunk.Text, i, '-')
+			if len(removed) == 0 {
+				i++
+				continue
+			}
+			i = skipNoNewline(hunk.Text, removed[len(removed)-1]+1)
+			added := changedRun(hunk.Text, i, '+')
+			if len(added) > 0 {
+				i = added[len(added)-1] + 1
+			}
+
+			if len(removed) == len(added) {
+				for k := range removed {
+					oldLine, newLine := hunk.Text[removed[k]], hunk.Text[added[k]]
+					oldRanges, newRanges, ok := wordDiffRanges(oldLine[1:], newLine[1:], wordRe)
+					if !ok {
+						continue
+					}
+					hunk.Display[removed[k]] = highlightLine(oldLine, oldRanges, colors.old, colors.oldHighlight, colors.reset)
+					hunk.Display[added[k]] = highlightLine(newLine, newRanges, colors.new, colors.newHighlight, colors.reset)
+				}
+			}
+		}
+	}
+}
+
+// changedRun returns the indexes of the lines starting with kind from
+// start on, passing over "\ No newline at end of file" markers.
+func changedRun(lines []string, start int, kind byte) []int {
+	var run []int
+	for i := start; i < len(lines); i++ {
+		if strings.HasPrefix(lines[i], "\\") && len(run) > 0 {
+			continue
+		}
+		if len(lines[i]) == 0 || lines[i][0] != kind {
+			break
+		}
+		run = append(run, i)
+	}
+	return run
+}
+
+// skipNoNewline steps over a "\ No newline at end of file" marker at i.
+func skipNoNewline(lines []string, i int) int {
+	if i < len(lines) && strings.HasPrefix(lines[i], "\\") {
+		return i + 1
+	}
+	return i
+}
+
+// wordDiffRanges returns the byte ranges of the words that differ between
+// old and new. It reports false when the lines have no words other than
+// whitespace in common, as highlighting all of both helps nobody.
+func wordDiffRanges(old, new string, wordRe *regexp.Regexp) ([][2]int, [][2]int, bool) {
+	oldWords, oldBounds := splitWords(old, wordRe)
+	newWords, newBounds := splitWords(new, wordRe)
+
+	var oldRanges, newRanges [][2]int
+	common := false
+	for _, op := range myersDiff(oldWords, newWords) {
+		switch op.Kind {
+		case '-':
+			oldRanges = addRange(oldRanges, oldBounds[op.A])
+		case '+':
+			newRanges = addRange(newRanges, newBounds[op.B])
+		default:
+			if strings.TrimSpace(oldWords[op.A]) != "" {
+				common = true
+			}
+		}
+	}
+	return oldRanges, newRanges, common
+}
+
+// splitWords splits line into the words matched by wordRe and whatever lies
+// between them, so that the words put together are the line again.
+func splitWords(line string, wordRe *regexp.Regexp) ([]string, [][2]int) {
+	var words []string
+	var bounds [][2]int
+	add := func(start, end int) {
+		if start < end {
+			words = append(words, line[start:end])
+			bounds = append(bounds, [2]int{start, end})
+		}
+	}
+
+	last := 0
+	for _, match := range wordRe.FindAllStringIndex(line, -1) {
+		add(last, match[0])
+		add(match[0], match[1])
+		last = match[1]
+	}
+	add(last, len(line))
+	return words, bounds
+}
+
+// addRange appends bounds to ranges, joining it to the last range when they
+// touch.
+func addRange(ranges [][2]int, bounds [2]int) [][2]int {
+	if n := len(ranges); n > 0 && ranges[n-1][1] == bounds[0] {
+		ranges[n-1][1] = bounds[1]
+		return ranges
+	}
+	return append(ranges, bounds)
+}
+
+// highlightLine colors line, a removed or added line of a hunk, with color,
+// and the ranges of its content after the marker with highlight.
+func highlightLine(line string, ranges [][2]int, color, highlight, reset string) string {
+	var b strings.Builder
+	b.WriteString(color + line[:1])
+	last := 0
+	content := line[1:]
+	for _, r := range ranges {
+		b.WriteString(content[last:r[0]] + reset)
+		b.WriteString(highlight + content[r[0]:r[1]] + reset + color)
+		last = r[1]
+	}
+	b.WriteString(content[last:] + reset)
+	return b.String()
+}
@@ -0,0 +1,133 @@
+package git
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestWordDiffRanges(t *testing.T) {
+	tests := []struct {
+		old, new               string
+		wordRe                 *regexp.Regexp
+		oldChanged, newChanged []string
+		ok                     bool
+	}{
+		{
+			old: "return fooBar(x, y)", new: "return fooBaz(x, y)",
+			oldChanged: []string{"fooBar"}, newChanged: []string{"fooBaz"}, ok: true,
+		},
+		{
+			old: "a := b + c", new: "a := b + c + d",
+			oldChanged: nil, newChanged: []string{" + d"}, ok: true,
+		},
+		{
+			old: "alpha beta", new: "gamma delta",
+			ok: false,
+		},
+		{
+			old: "fooBar", new: "fooBaz", wordRe: regexp.MustCompile(`.`),
+			oldChanged: []string{"r"}, newChanged: []string{"z"}, ok: true,
+		},
+	}
+
+	for _, test := range tests {
+		wordRe := test.wordRe
+		if wordRe == nil {
+			wordRe = defaultWordRe
+		}
+		oldRanges, newRanges, ok := wordDiffRanges(test.old, test.new, wordRe)
+		if ok != test.ok {
+			t.Errorf("wordDiffRanges(%q, %q) ok = %v, expected %v", test.old, test.new, ok, test.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if got := rangeTexts(test.old, oldRanges); strings.Join(got, "|") != strings.Join(test.oldChanged, "|") {
+			t.Errorf("wordDiffRanges(%q, %q) changed %q of the old line, expected %q", test.old, test.new, got, test.oldChanged)
+		}
+		if got := rangeTexts(test.new, newRanges); strings.Join(got, "|") != strings.Join(test.newChanged, "|") {
+			t.Errorf("wordDiffRanges(%q, %q) changed %q of the new line, expected %q", test.old, test.new, got, test.newChanged)
+		}
+	}
+}
+
+func rangeTexts(line string, ranges [][2]int) []string {
+	var texts []string
+	for _, r := range ranges {
+		texts = append(texts, line[r[0]:r[1]])
+	}
+	return texts
+}
+
+func TestHighlightWords(t *testing.T) {
+	colors := wordColors{old: "<r>", new: "<g>", oldHighlight: "<R>", newHighlight: "<G>", reset: "<>"}
+	hunks := []Hunk{{
+		Type: HunkTypeHunk,
+		Text: []string{
+			"@@ -1,5 +1,5 @@",
+			" keep",
+			"-x = 1",
+			"-y = 2",
+			"+x = 3",
+			"+y = 2 + z",
+			" keep",
+			"-only removed",
+			"+entirely different",
+			"+and more",
+		},
+	}}
+	hunks[0].Display = append([]string{}, hunks[0].Text...)
+
+	highlightWords(hunks, defaultWordRe, colors)
+
+	expected := []string{
+		"@@ -1,5 +1,5 @@",
+		" keep",
+		"<r>-x = <><R>1<><r><>",
+		"<r>-y = 2<>",
+		"<g>+x = <><G>3<><g><>",
+		"<g>+y = 2<><G> + z<><g><>",
+		" keep",
+		"-only removed",
+		"+entirely different",
+		"+and more",
+	}
+	for i, line := range hunks[0].Display {
+		if line != expected[i] {
+			t.Errorf("Line %d displays %q, expected %q", i, line, expected[i])
+		}
+	}
+
+	// Highlighting survives splitting, which copies the display
+	repo := &Repository{}
+	if err := repo.parseHunkHeader(&hunks[0]); err != nil {
+		t.Fatal(err)
+	}
+	for _, split := range repo.SplitHunk(&hunks[0]) {
+		for i := 1; i < len(split.Text); i++ {
+			if strings.Contains(split.Display[i], "<G>") && !strings.HasPrefix(split.Text[i], "+") {
+				t.Errorf("Split displays %q for %q", split.Display[i], split.Text[i])
+			}
+		}
+	}
+}
+
+func TestHighlightWordsNoNewline(t *testing.T) {
+	colors := wordColors{old: "<r>", new: "<g>", oldHighlight: "<R>", newHighlight: "<G>", reset: "<>"}
+	hunks := []Hunk{{
+		Type: HunkTypeHunk,
+		Text: []string{"@@ -1 +1 @@", "-last a", `\ No newline at end of file`, "+last b", `\ No newline at end of file`},
+	}}
+	hunks[0].Display = append([]string{}, hunks[0].Text...)
+
+	highlightWords(hunks, defaultWordRe, colors)
+
+	if hunks[0].Display[1] != "<r>-last <><R>a<><r><>" || hunks[0].Display[3] != "<g>+last <><G>b<><g><>" {
+		t.Errorf("Unexpected display %q", hunks[0].Display)
+	}
+	if hunks[0].Display[2] != hunks[0].Text[2] {
+		t.Errorf("Marker displays %q", hunks[0].Display[2])
+	}
+}
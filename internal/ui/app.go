@@ -16,6 +16,18 @@ type App struct {
 	colors           ColorConfig
 	globalFilter     string // Global regex filter for all files
 	autoSplitEnabled bool   // Global flag to automatically split hunks to smallest possible
+	stashPatch       []byte // Hunks selected so far in stash mode
+	input            *bufio.Reader
+	singleKey        bool // interactive.singleKey on a terminal
+	tui              bool // Full-screen hunk selection in patch mode
+	journal          *git.Journal
+	scripted         bool             // Answers come from a --script file
+	scriptErr        error            // First answer a scripted session rejected
+	includeUntracked bool             // Offer untracked files in patch mode through intent-to-add
+	paths            []string         // Pathspec the interactive menu is limited to
+	hunkPager        string           // Command the last "|" piped a hunk through
+	sideBySide       bool             // Show hunks in an old and a new column
+	offsets          []git.HunkOffset // Hunks applied away from their headers, not yet reported
 }
 
 type ColorConfig struct {
@@ -33,14 +45,82 @@ type ColorConfig struct {
 
 func NewApp(repo *git.Repository) *App {
 	app := &App{
-		repo: repo,
+		repo:      repo,
+		journal:   repo.NewJournal(),
+		singleKey: repo.GetConfigBool("interactive.singlekey") && isTerminal(os.Stdin),
+
+		includeUntracked: repo.GetConfigBool("interactive.includeuntracked"),
+	}
+	if ui, err := repo.GetConfig("interactive.ui"); err == nil && ui == "tui" {
+		app.tui = true
 	}
 	app.initColors()
 	return app
 }
 
+// EnableTUI selects the full-screen hunk selection for patch mode, as
+// interactive.ui=tui does.
+func (a *App) EnableTUI() {
+	a.tui = true
+}
+
+// IncludeUntracked offers untracked files when staging in patch mode, as
+// interactive.includeUntracked does.
+func (a *App) IncludeUntracked() {
+	a.includeUntracked = true
+}
+
+// addUntrackedIntent marks the untracked files within paths intent-to-add
+// so that they diff as additions, and returns them for rollbackIntent.
+func (a *App) addUntrackedIntent(paths []string) ([]string, error) {
+	untracked, err := a.listUntracked(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	var added []string
+	for _, path := range untracked {
+		// Nested repositories are listed as directories
+		if !strings.HasSuffix(path, "/") {
+			added = append(added, path)
+		}
+	}
+
+	if err := a.repo.AddIntentToAdd(added); err != nil {
+		return nil, err
+	}
+	return added, nil
+}
+
+// rollbackIntent makes the paths addUntrackedIntent marked untracked again,
+// unless some of their content was staged.
+func (a *App) rollbackIntent(added []string) {
+	if _, err := a.repo.RemoveIntentToAdd(added); err != nil {
+		a.printError(fmt.Sprintf("error: %v\n", err))
+	}
+}
+
+// listModified lists the changed files within the pathspec the interactive
+// menu was started with.
+func (a *App) listModified(filter string) ([]git.FileStatus, error) {
+	return a.repo.ListModifiedWithRevisionAndPaths(filter, "", a.paths)
+}
+
+// listUntracked lists the untracked files within paths.
+func (a *App) listUntracked(paths []string) ([]string, error) {
+	spec, err := a.repo.ParsePathspec(paths)
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := a.repo.ListUntracked()
+	if err != nil {
+		return nil, err
+	}
+	return spec.Filter(untracked)
+}
+
 func (a *App) showInteractiveStatus() {
-	files, err := a.repo.ListModified("")
+	files, err := a.listModified("")
 	if err != nil {
 		return // Silently skip status on error
 	}
@@ -60,7 +140,7 @@ func (a *App) showInteractiveStatus() {
 			unstagePart = "nothing"
 		}
 		fmt.Printf("  %d:    %-12s %s %s\n",
-			i+1, stagePart, unstagePart, file.Path)
+			i+1, stagePart, unstagePart, file.DisplayPath())
 	}
 }
 
@@ -80,10 +160,15 @@ func (a *App) initColors() {
 		a.colors.DiffOldColor = a.repo.GetColor("color.diff.old", "red")
 		a.colors.DiffNewColor = a.repo.GetColor("color.diff.new", "green")
 		a.colors.DiffCtxColor = a.repo.GetColor("color.diff.context", "")
+		a.colors.NormalColor = a.repo.GetColor("", "reset")
 	}
 }
 
-func (a *App) RunInteractive() error {
+// RunInteractive runs the command menu over the files within paths, or all
+// files when there are none.
+func (a *App) RunInteractive(paths []string) error {
+	a.paths = paths
+
 	commands := []Command{
 		{"status", "show paths with changes", a.statusCmd},
 		{"update", "add working tree state to the staged set of changes", a.updateCmd},
@@ -93,6 +178,8 @@ func (a *App) RunInteractive() error {
 		{"diff", "view diff between HEAD and index", a.diffCmd},
 		{"quit", "quit", a.quitCmd},
 		{"help", "show help", a.helpCmd},
+		{"conflicts", "resolve merge conflicts in unmerged paths", a.conflictsCmd},
+		{"journal", "roll the index back to an earlier point in this session", a.journalCmd},
 	}
 
 	for {
@@ -114,12 +201,24 @@ func (a *App) RunInteractive() error {
 			a.colored(a.colors.PromptColor, "q")+"uit",
 			a.colored(a.colors.PromptColor, "h")+"elp")
 
+		cmdLine3 := fmt.Sprintf("  9: %s    10: %s",
+			a.colored(a.colors.PromptColor, "c")+"onflicts",
+			a.colored(a.colors.PromptColor, "j")+"ournal")
+
 		fmt.Println(cmdLine1)
 		fmt.Println(cmdLine2)
+		fmt.Println(cmdLine3)
 
 		// Interactive prompt
 		fmt.Print(a.colored(a.colors.PromptColor, "What now> "))
-		input, err := a.promptSingleChar()
+		input, err := a.promptCommand(func(key string) bool {
+			for _, cmd := range commands {
+				if strings.EqualFold(key, cmd.Name[:1]) {
+					return true
+				}
+			}
+			return false
+		})
 		if err != nil {
 			return err
 		}
@@ -160,11 +259,23 @@ func (a *App) RunInteractive() error {
 }
 
 func (a *App) RunPatchMode(mode, revision string, paths []string) error {
+	if mode == "resolve" {
+		return a.RunResolveMode(paths)
+	}
+
 	patchMode, exists := git.PatchModes[mode]
 	if !exists {
 		return fmt.Errorf("unknown patch mode: %s", mode)
 	}
 
+	if a.includeUntracked && patchMode.Name == "stage" {
+		added, err := a.addUntrackedIntent(paths)
+		if err != nil {
+			return err
+		}
+		defer a.rollbackIntent(added)
+	}
+
 	files, err := a.repo.ListModifiedWithRevisionAndPaths(patchMode.Filter, revision, paths)
 	if err != nil {
 		return err
@@ -172,7 +283,7 @@ func (a *App) RunPatchMode(mode, revision string, paths []string) error {
 
 	var filteredFiles []git.FileStatus
 	for _, file := range files {
-		if !file.Unmerged && !file.Binary {
+		if !file.Unmerged {
 			filteredFiles = append(filteredFiles, file)
 		}
 	}
@@ -182,8 +293,20 @@ func (a *App) RunPatchMode(mode, revision string, paths []string) error {
 		return nil
 	}
 
+	a.stashPatch = nil
+
+	if a.tui && isTerminal(os.Stdin) && isTerminal(os.Stdout) {
+		if err := a.runTUI(filteredFiles, patchMode, revision); err != nil {
+			return err
+		}
+		if patchMode.Name == "stash" && len(a.stashPatch) > 0 {
+			return a.finishStash()
+		}
+		return nil
+	}
+
 	for i, file := range filteredFiles {
-		if err := a.patchUpdateFile(file.Path, patchMode, revision); err != nil {
+		if err := a.patchUpdateFile(file, patchMode, revision); err != nil {
 			if errors.Is(err, ErrQuit) {
 				break
 			}
@@ -191,7 +314,7 @@ func (a *App) RunPatchMode(mode, revision string, paths []string) error {
 				// Accept all hunks in all remaining files
 				for j := i + 1; j < len(filteredFiles); j++ {
 					remainingFile := filteredFiles[j]
-					if err := a.acceptAllHunksInFile(remainingFile.Path, patchMode, revision); err != nil {
+					if err := a.acceptAllHunksInFile(remainingFile, patchMode, revision); err != nil {
 						return err
 					}
 				}
@@ -201,86 +324,35 @@ func (a *App) RunPatchMode(mode, revision string, paths []string) error {
 		}
 	}
 
-	return nil
-}
-
-func (a *App) containsPath(paths []string, target string) bool {
-	for _, path := range paths {
-		if a.matchesPathspec(path, target) {
-			return true
-		}
-	}
-	return false
-}
-
-func (a *App) matchesPathspec(pathspec, target string) bool {
-	// Handle exact match first
-	if pathspec == target {
-		return true
-	}
-
-	// Parse pathspec magic signatures like :(,prefix:0)internal/
-	actualPath := a.parsePathspec(pathspec)
-
-	// Handle exact match with parsed path
-	if actualPath == target {
-		return true
-	}
-
-	// Handle directory prefix matching
-	if strings.HasSuffix(actualPath, "/") {
-		if strings.HasPrefix(target, actualPath) {
-			return true
-		}
-	}
-
-	// Handle relative path matching by normalizing both paths
-	normalizedPath := a.normalizePath(actualPath)
-	normalizedTarget := a.normalizePath(target)
-
-	// Exact match after normalization
-	if normalizedPath == normalizedTarget {
-		return true
+	if patchMode.Name == "stash" {
+		return a.finishStash()
 	}
 
-	// Directory prefix matching after normalization
-	if strings.HasSuffix(normalizedPath, "/") {
-		if strings.HasPrefix(normalizedTarget, normalizedPath) {
-			return true
-		}
-	}
-
-	return false
+	return nil
 }
 
-func (a *App) parsePathspec(pathspec string) string {
-	// Handle Git pathspec magic signatures like :(magic)path
-	if strings.HasPrefix(pathspec, ":(") {
-		// Find the closing parenthesis
-		closeParen := strings.Index(pathspec, ")")
-		if closeParen != -1 {
-			// Extract the path part after the magic signature
-			return pathspec[closeParen+1:]
-		}
-	}
-
-	return pathspec
-}
+// finishStash turns the hunks collected during a stash session into a stash
+// entry, like `git stash -p`.
+func (a *App) finishStash() error {
+	patchData := a.stashPatch
+	a.stashPatch = nil
 
-func (a *App) normalizePath(path string) string {
-	// Remove leading "./" if present
-	if strings.HasPrefix(path, "./") {
-		path = path[2:]
+	if len(patchData) == 0 {
+		return fmt.Errorf("no changes selected")
 	}
 
-	// Remove trailing slashes
-	path = strings.TrimSuffix(path, "/")
+	a.recordJournal("before stashing")
+	if err := a.repo.CreateStash(patchData, ""); err != nil {
+		return fmt.Errorf("cannot save the current worktree state: %v", err)
+	}
 
-	return path
+	fmt.Println("Saved working directory and index state")
+	return nil
 }
 
-func (a *App) acceptAllHunksInFile(path string, mode git.PatchMode, revision string) error {
-	hunks, err := a.repo.ParseDiff(path, mode, revision)
+func (a *App) acceptAllHunksInFile(file git.FileStatus, mode git.PatchMode, revision string) error {
+	path := file.Path
+	hunks, err := a.repo.ParseFileDiff(file, mode, revision)
 	if err != nil {
 		return err
 	}
@@ -321,21 +393,11 @@ func (a *App) acceptAllHunksInFile(path string, mode git.PatchMode, revision str
 	}
 
 	// Apply the patch
-	selectedHunks := []git.Hunk{hunks[0]}
-	for _, hunk := range actualHunks {
-		if hunk.Use != nil && *hunk.Use {
-			selectedHunks = append(selectedHunks, hunk)
-		}
-	}
-
-	if len(selectedHunks) > 1 {
-		patchData := a.reassemblePatch(selectedHunks)
-		if err := a.repo.ApplyPatch(patchData, mode); err != nil {
-			return fmt.Errorf("failed to apply patch for %s: %v", path, err)
-		}
-		a.repo.UpdateIndex()
-		fmt.Printf("Accepted all hunks in %s\n", path)
+	if err := a.applyHunkSelection(hunks[0], actualHunks, mode); err != nil {
+		return fmt.Errorf("failed to apply patch for %s: %v", path, err)
 	}
+	a.reportOffsets()
+	fmt.Printf("Accepted all hunks in %s\n", path)
 
 	return nil
 }
@@ -349,11 +411,17 @@ func (a *App) colored(color, text string) string {
 
 func (a *App) printError(text string) {
 	fmt.Fprint(os.Stderr, a.colored(a.colors.ErrorColor, text))
+	if a.scripted && a.scriptErr == nil {
+		a.scriptErr = fmt.Errorf("script stopped: %s", strings.TrimSpace(text))
+	}
 }
 
 func (a *App) promptSingleChar() (string, error) {
-	reader := bufio.NewReader(os.Stdin)
-	input, err := reader.ReadString('\n')
+	if a.scripted {
+		return a.scriptAnswer()
+	}
+
+	input, err := a.stdin().ReadString('\n')
 	if err != nil {
 		return "", err
 	}
@@ -363,7 +431,7 @@ func (a *App) promptSingleChar() (string, error) {
 func (a *App) promptYesNo(prompt string) (bool, error) {
 	for {
 		fmt.Print(a.colored(a.colors.PromptColor, prompt))
-		input, err := a.promptSingleChar()
+		input, err := a.promptKey()
 		if err != nil {
 			return false, err
 		}
@@ -388,7 +456,7 @@ func (c Command) String() string {
 }
 
 func (a *App) statusCmd() error {
-	files, err := a.repo.ListModified("")
+	files, err := a.listModified("")
 	if err != nil {
 		return err
 	}
@@ -396,7 +464,7 @@ func (a *App) statusCmd() error {
 	fmt.Printf(a.colored(a.colors.HeaderColor, "%12s %12s %s\n"), "staged", "unstaged", "path")
 
 	for _, file := range files {
-		fmt.Printf("%12s %12s %s\n", file.Index, file.File, file.Path)
+		fmt.Printf("%12s %12s %s\n", file.Index, file.File, file.DisplayPath())
 	}
 
 	fmt.Println()
@@ -404,7 +472,7 @@ func (a *App) statusCmd() error {
 }
 
 func (a *App) updateCmd() error {
-	files, err := a.repo.ListModified("file-only")
+	files, err := a.listModified("file-only")
 	if err != nil {
 		return err
 	}
@@ -429,10 +497,14 @@ func (a *App) updateCmd() error {
 		for _, item := range chosen {
 			file := item.(git.FileStatus)
 			paths = append(paths, file.Path)
+			if file.OldPath != "" && !file.Copy {
+				paths = append(paths, file.OldPath)
+			}
 		}
 
 		args := append([]string{"update-index", "--add", "--remove", "--"}, paths...)
 		_, err := a.repo.RunCommand(args...)
+		a.repo.Invalidate(paths...)
 		if err != nil {
 			return err
 		}
@@ -445,7 +517,7 @@ func (a *App) updateCmd() error {
 }
 
 func (a *App) revertCmd() error {
-	files, err := a.repo.ListModified("")
+	files, err := a.listModified("")
 	if err != nil {
 		return err
 	}
@@ -470,6 +542,9 @@ func (a *App) revertCmd() error {
 		for _, item := range chosen {
 			file := item.(git.FileStatus)
 			paths = append(paths, file.Path)
+			if file.OldPath != "" && !file.Copy {
+				paths = append(paths, file.OldPath)
+			}
 		}
 
 		if a.repo.IsInitialCommit() {
@@ -492,6 +567,7 @@ func (a *App) revertCmd() error {
 			}
 		}
 
+		a.repo.Invalidate(paths...)
 		a.repo.UpdateIndex()
 		fmt.Printf("reverted %d path(s)\n", len(paths))
 	}
@@ -501,7 +577,7 @@ func (a *App) revertCmd() error {
 }
 
 func (a *App) addUntrackedCmd() error {
-	untracked, err := a.repo.ListUntracked()
+	untracked, err := a.listUntracked(a.paths)
 	if err != nil {
 		return err
 	}
@@ -530,6 +606,7 @@ func (a *App) addUntrackedCmd() error {
 
 		args := append([]string{"update-index", "--add", "--"}, paths...)
 		_, err := a.repo.RunCommand(args...)
+		a.repo.Invalidate(paths...)
 		if err != nil {
 			return err
 		}
@@ -542,7 +619,17 @@ func (a *App) addUntrackedCmd() error {
 }
 
 func (a *App) patchCmd() error {
-	files, err := a.repo.ListModified("file-only")
+	// Untracked files have to be listed to be chosen, and are made untracked
+	// again if they were not chosen
+	if a.includeUntracked {
+		added, err := a.addUntrackedIntent(a.paths)
+		if err != nil {
+			return err
+		}
+		defer a.rollbackIntent(added)
+	}
+
+	files, err := a.listModified("file-only")
 	if err != nil {
 		return err
 	}
@@ -555,7 +642,7 @@ func (a *App) patchCmd() error {
 
 	var fileItems []interface{}
 	for _, file := range files {
-		if !file.Unmerged && !file.Binary {
+		if !file.Unmerged {
 			fileItems = append(fileItems, file)
 		}
 	}
@@ -586,8 +673,95 @@ func (a *App) patchCmd() error {
 	return nil
 }
 
+func (a *App) conflictsCmd() error {
+	files, err := a.listModified("")
+	if err != nil {
+		return err
+	}
+
+	var fileItems []interface{}
+	for _, file := range files {
+		if file.Unmerged {
+			fileItems = append(fileItems, file)
+		}
+	}
+
+	if len(fileItems) == 0 {
+		fmt.Println("No unmerged paths.")
+		fmt.Println()
+		return nil
+	}
+
+	chosen, err := a.listAndChoose("Resolve", fileItems, false, false)
+	if err != nil {
+		return err
+	}
+
+	if len(chosen) > 0 {
+		var paths []string
+		for _, item := range chosen {
+			file := item.(git.FileStatus)
+			paths = append(paths, file.Path)
+		}
+
+		return a.RunResolveMode(paths)
+	}
+
+	fmt.Println()
+	return nil
+}
+
+func (a *App) journalCmd() error {
+	if len(a.journal.Entries) == 0 {
+		fmt.Println("Nothing has been applied to the index in this session.")
+		fmt.Println()
+		return nil
+	}
+
+	var items []interface{}
+	for i, entry := range a.journal.Entries {
+		items = append(items, journalItem{ix: i, entry: entry})
+	}
+
+	chosen, err := a.listAndChoose("Roll back to", items, true, false)
+	if err != nil {
+		return err
+	}
+
+	if len(chosen) == 1 {
+		item := chosen[0].(journalItem)
+		if err := a.journal.Rollback(item.ix); err != nil {
+			return err
+		}
+		fmt.Printf("Index rolled back to %s\n", item.entry)
+	}
+
+	fmt.Println()
+	return nil
+}
+
+// journalItem is a journal entry offered for rollback. It keeps the position
+// of the entry, since two entries may read the same.
+type journalItem struct {
+	ix    int
+	entry git.JournalEntry
+}
+
+func (j journalItem) String() string {
+	return j.entry.String()
+}
+
+// recordJournal saves the index before it is changed. A failure only means
+// that point cannot be rolled back to, so it is reported and ignored; an
+// index with unmerged entries, as during a merge, is skipped quietly.
+func (a *App) recordJournal(description string) {
+	if err := a.journal.Record(description); err != nil && !errors.Is(err, git.ErrUnmergedIndex) {
+		fmt.Fprint(os.Stderr, a.colored(a.colors.ErrorColor, fmt.Sprintf("Cannot record the index for rollback: %v\n", err)))
+	}
+}
+
 func (a *App) diffCmd() error {
-	files, err := a.repo.ListModified("index-only")
+	files, err := a.listModified("index-only")
 	if err != nil {
 		return err
 	}
@@ -625,13 +799,17 @@ func (a *App) diffCmd() error {
 			reference = emptyTree
 		}
 
-		args := append([]string{"diff", "-p", "--cached", reference, "--"}, paths...)
+		args := []string{"diff", "-p", "--cached"}
+		if a.repo.GetColorBool("color.diff") && isTerminal(os.Stdout) {
+			args = append(args, "--color=always")
+		}
+		args = append(append(args, reference, "--"), paths...)
 		output, err := a.repo.RunCommand(args...)
 		if err != nil {
 			return err
 		}
 
-		fmt.Print(string(output))
+		a.page(string(output), a.repo.Pager("diff"))
 	}
 
 	return nil
@@ -650,6 +828,8 @@ revert        - revert staged set of changes back to the HEAD version
 patch         - pick hunks and update selectively
 diff          - view diff between HEAD and index
 add untracked - add contents of untracked files to the staged set of changes
+conflicts     - resolve merge conflicts in unmerged paths
+journal       - roll the index back to an earlier point in this session
 `)
 	fmt.Print(help)
 	return nil
@@ -664,18 +844,39 @@ func (a *App) listAndChoose(prompt string, items []interface{}, singleton, immed
 		return a.listAndChooseSingleton(prompt, items, immediate)
 	}
 
-	// Multi-select mode with persistent selection
+	// Multi-select mode with persistent selection; numbers and prefixes
+	// refer to the items that pass the filters
 	selected := make(map[int]bool)
+	var visible []int
+	var filters []string
+	showAll := func() {
+		visible = make([]int, len(items))
+		for i := range items {
+			visible[i] = i
+		}
+		filters = nil
+	}
+	showAll()
+	page := 0
 
 	for {
+		start, end, current := listPage(len(visible), page, a.listPageSize())
+		page = current
+
 		// Display items with selection markers
 		fmt.Printf(a.colored(a.colors.HeaderColor, "%12s %12s %s\n"), "staged", "unstaged", "path")
-		for i, item := range items {
+		for n := start; n < end; n++ {
 			marker := " "
-			if selected[i] {
+			if selected[visible[n]] {
 				marker = "*"
 			}
-			fmt.Printf("%s%2d: %s\n", marker, i+1, a.formatItem(item))
+			fmt.Printf("%s%2d: %s\n", marker, n+1, a.formatItem(items[visible[n]]))
+		}
+		if len(filters) > 0 {
+			fmt.Printf("(%d of %d items match %s)\n", len(visible), len(items), strings.Join(filters, " "))
+		}
+		if start > 0 || end < len(visible) {
+			fmt.Printf("(%d-%d of %d; > next page, < previous page)\n", start+1, end, len(visible))
 		}
 
 		promptStr := prompt + ">> "
@@ -686,7 +887,8 @@ func (a *App) listAndChoose(prompt string, items []interface{}, singleton, immed
 			return nil, err
 		}
 
-		if input == "" {
+		switch {
+		case input == "":
 			// Empty input - finish selecting
 			var result []interface{}
 			for i, item := range items {
@@ -695,78 +897,46 @@ func (a *App) listAndChoose(prompt string, items []interface{}, singleton, immed
 				}
 			}
 			return result, nil
-		}
-
-		if input == "?" {
+		case input == "?":
 			a.printSelectionHelp(singleton)
-			continue
-		}
-
-		if input == "*" {
-			// Select all items
-			for i := range items {
-				selected[i] = true
-			}
-			continue
-		}
-
-		// Handle deselection with leading dash
-		isDeselect := strings.HasPrefix(input, "-")
-		if isDeselect {
-			input = input[1:]
-		}
-
-		choices := strings.Split(input, ",")
-		hasError := false
-
-		for _, choice := range choices {
-			choice = strings.TrimSpace(choice)
-			if choice == "" {
+		case input == ">":
+			page++
+		case input == "<":
+			page--
+		case input == "/":
+			showAll()
+		case strings.HasPrefix(input, "/"):
+			match, err := parseListFilter(input[1:])
+			if err != nil {
+				a.printError(fmt.Sprintf("Invalid filter: %v\n", err))
 				continue
 			}
-
-			// Handle ranges (e.g., "3-5", "1-3")
-			if strings.Contains(choice, "-") {
-				parts := strings.SplitN(choice, "-", 2)
-				if len(parts) == 2 {
-					start, err1 := strconv.Atoi(strings.TrimSpace(parts[0]))
-					end, err2 := strconv.Atoi(strings.TrimSpace(parts[1]))
-
-					if err1 != nil || err2 != nil || start < 1 || end < 1 || start > len(items) || end > len(items) {
-						a.printError(fmt.Sprintf("Invalid range: %s\n", choice))
-						hasError = true
-						break
-					}
-
-					if start > end {
-						start, end = end, start
-					}
-
-					for i := start; i <= end; i++ {
-						selected[i-1] = !isDeselect
-					}
-					continue
+			var narrowed []int
+			for _, i := range visible {
+				if match(itemName(items[i])) {
+					narrowed = append(narrowed, i)
 				}
 			}
-
-			// Handle single numbers
-			if num, err := strconv.Atoi(choice); err == nil {
-				if num >= 1 && num <= len(items) {
-					selected[num-1] = !isDeselect
-				} else {
-					a.printError(fmt.Sprintf("Invalid number: %s\n", choice))
-					hasError = true
-					break
-				}
-			} else {
-				a.printError(fmt.Sprintf("Invalid input: %s\n", choice))
-				hasError = true
-				break
+			if len(narrowed) == 0 {
+				a.printError(fmt.Sprintf("No items match %s\n", input))
+				continue
+			}
+			visible = narrowed
+			filters = append(filters, input)
+			page = 0
+		default:
+			names := make([]string, len(visible))
+			for n, i := range visible {
+				names[n] = itemName(items[i])
+			}
+			choices, deselect, err := parseListChoices(input, names)
+			if err != nil {
+				a.printError(fmt.Sprintf("%v\n", err))
+				continue
+			}
+			for _, n := range choices {
+				selected[visible[n]] = !deselect
 			}
-		}
-
-		if hasError {
-			continue
 		}
 	}
 }
@@ -784,7 +954,17 @@ func (a *App) listAndChooseSingleton(prompt string, items []interface{}, immedia
 		promptStr := prompt + "> "
 		fmt.Print(a.colored(a.colors.PromptColor, promptStr))
 
-		input, err := a.promptSingleChar()
+		input, err := a.promptCommand(func(key string) bool {
+			if key == "?" {
+				return true
+			}
+			for _, item := range items {
+				if cmd, ok := item.(Command); ok && strings.EqualFold(key, cmd.Name[:1]) {
+					return true
+				}
+			}
+			return false
+		})
 		if err != nil {
 			return nil, err
 		}
@@ -822,6 +1002,14 @@ func (a *App) listAndChooseSingleton(prompt string, items []interface{}, immedia
 			return []interface{}{*selectedCmd}, nil
 		}
 
+		names := make([]string, len(items))
+		for i, item := range items {
+			names[i] = itemName(item)
+		}
+		if i, ok := findUnique(input, names); ok {
+			return []interface{}{items[i]}, nil
+		}
+
 		a.printError(fmt.Sprintf("Invalid input: %s\n", input))
 	}
 
@@ -831,7 +1019,7 @@ func (a *App) listAndChooseSingleton(prompt string, items []interface{}, immedia
 func (a *App) formatItem(item interface{}) string {
 	switch v := item.(type) {
 	case git.FileStatus:
-		return fmt.Sprintf("%12s %12s %s", v.Index, v.File, v.Path)
+		return fmt.Sprintf("%12s %12s %s", v.Index, v.File, v.DisplayPath())
 	case string:
 		return v
 	case Command:
@@ -854,9 +1042,15 @@ foo        - select item based on unique prefix
 1          - select a single item
 3-5        - select a range of items
 2-3,6-9    - select multiple ranges
+3-         - select from item 3 to the last one
 foo        - select item based on unique prefix
 -...       - unselect specified items
-*          - choose all items
+*          - choose all items shown
+/foo       - show only items whose path contains f, o, o in this order
+/*.go      - show only items matching a glob, as pathspecs do
+//regex    - show only items matching a regular expression
+/          - show all items again
+>, <       - show the next or previous page of a long list
            - (empty) finish selecting
 `)
 		fmt.Print(help)
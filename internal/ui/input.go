@@ -0,0 +1,232 @@
+package ui
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// Escape sequences sent by the arrow keys, mapped to the navigation keys of
+// the hunk prompt.
+var arrowKeys = map[string]string{
+	"\x1b[A": "k",
+	"\x1b[B": "j",
+	"\x1bOA": "k",
+	"\x1bOB": "j",
+}
+
+// stdin returns the reader shared by all prompts. Using a single reader keeps
+// input that arrives ahead of the prompt, such as piped answers.
+func (a *App) stdin() *bufio.Reader {
+	if a.input == nil {
+		a.input = bufio.NewReader(os.Stdin)
+	}
+	return a.input
+}
+
+// promptKey reads a one-letter answer. With interactive.singleKey on a
+// terminal the key is taken without waiting for Enter; otherwise a whole
+// line is read.
+func (a *App) promptKey() (string, error) {
+	if !a.singleKey {
+		input, err := a.promptSingleChar()
+		if err != nil {
+			return "", err
+		}
+		return translateArrowKey(input), nil
+	}
+
+	restore, err := rawTerminal()
+	if err != nil {
+		return a.promptSingleChar()
+	}
+	defer restore()
+
+	input, err := a.readRawKey()
+	if err != nil {
+		return "", err
+	}
+
+	// Echo the key the way line input would have
+	os.Stdout.WriteString(input + "\n")
+	return input, nil
+}
+
+// promptCommand reads an answer that is either a one-letter command or a
+// longer input, such as a number or the start of a name. With
+// interactive.singleKey on a terminal, a key that isCommand accepts is
+// taken without waiting for Enter; any other key starts a line that is read
+// up to Enter.
+func (a *App) promptCommand(isCommand func(key string) bool) (string, error) {
+	if !a.singleKey {
+		return a.promptSingleChar()
+	}
+
+	restore, err := rawTerminal()
+	if err != nil {
+		return a.promptSingleChar()
+	}
+
+	input, err := a.readRawKey()
+	if err != nil {
+		restore()
+		return "", err
+	}
+	if input == "" || isCommand(input) {
+		restore()
+		os.Stdout.WriteString(input + "\n")
+		return input, nil
+	}
+
+	// Echo the key and let the terminal edit the rest of the line
+	os.Stdout.WriteString(input)
+	restore()
+	rest, err := a.stdin().ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(input + rest), nil
+}
+
+// readRawKey reads one key from a terminal in cbreak mode. Arrow keys are
+// translated to j/k and Enter is returned as an empty string.
+func (a *App) readRawKey() (string, error) {
+	reader := a.stdin()
+	key, _, err := reader.ReadRune()
+	if err != nil {
+		return "", err
+	}
+
+	if key == '\x04' {
+		return "", io.EOF
+	}
+
+	input := string(key)
+	if key == '\x1b' {
+		input += readEscapeSequence(reader)
+	}
+
+	input = translateArrowKey(input)
+	if input == "\n" || input == "\r" {
+		input = ""
+	}
+	return input, nil
+}
+
+// escapeTimeout is how long, in tenths of a second, the rest of an escape
+// sequence is waited for once ESC has arrived.
+const escapeTimeout = "5"
+
+// readEscapeSequence reads what follows ESC in the sequence a key sends:
+// "[" and parameters up to a final byte, or "O" and one byte. Over a slow
+// link the rest can arrive well after the ESC, so when nothing is buffered
+// the terminal is briefly put in timed reads; a lone ESC ends the wait.
+func readEscapeSequence(reader *bufio.Reader) string {
+	next := func() (byte, bool) {
+		if reader.Buffered() == 0 {
+			if _, err := stty("min", "0", "time", escapeTimeout); err != nil {
+				return 0, false
+			}
+			defer stty("min", "1", "time", "1")
+		}
+		b, err := reader.ReadByte()
+		return b, err == nil
+	}
+
+	first, ok := next()
+	if !ok {
+		return ""
+	}
+	seq := []byte{first}
+	switch first {
+	case '[':
+		for len(seq) < 16 {
+			b, ok := next()
+			if !ok {
+				break
+			}
+			seq = append(seq, b)
+			if b >= 0x40 && b <= 0x7e {
+				break
+			}
+		}
+	case 'O':
+		if b, ok := next(); ok {
+			seq = append(seq, b)
+		}
+	}
+	return string(seq)
+}
+
+func translateArrowKey(input string) string {
+	if key, ok := arrowKeys[input]; ok {
+		return key
+	}
+	return input
+}
+
+// rawTerminal puts the terminal on stdin into cbreak mode, plus any extra
+// stty settings, and returns a function that restores the previous settings.
+// The terminal is also restored if the process is interrupted meanwhile.
+func rawTerminal(extra ...string) (func(), error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, err
+	}
+
+	args := append([]string{"-icanon", "-echo", "min", "1", "time", "1"}, extra...)
+	if _, err := stty(args...); err != nil {
+		return nil, err
+	}
+
+	reset := func() {
+		stty(strings.TrimSpace(saved))
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		if _, ok := <-sigs; ok {
+			reset()
+			os.Exit(130)
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigs)
+		close(sigs)
+		reset()
+	}, nil
+}
+
+// terminalSize returns the rows and columns of the terminal on stdin.
+func terminalSize() (int, int) {
+	output, err := stty("size")
+	if err == nil {
+		var rows, cols int
+		if _, err := fmt.Sscan(output, &rows, &cols); err == nil && rows > 0 && cols > 0 {
+			return rows, cols
+		}
+	}
+	return 24, 80
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	output, err := cmd.Output()
+	return string(output), err
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
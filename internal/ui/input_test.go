@@ -0,0 +1,81 @@
+package ui
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestTranslateArrowKey(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"\x1b[A", "k"},
+		{"\x1b[B", "j"},
+		{"\x1bOA", "k"},
+		{"\x1bOB", "j"},
+		{"\x1b[C", "\x1b[C"},
+		{"y", "y"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if result := translateArrowKey(test.input); result != test.expected {
+			t.Errorf("translateArrowKey(%q) = %q, expected %q", test.input, result, test.expected)
+		}
+	}
+}
+
+func TestPromptKeyLineMode(t *testing.T) {
+	app := &App{
+		input: bufio.NewReader(strings.NewReader("y\n\x1b[B\n  n  \n")),
+	}
+
+	for _, expected := range []string{"y", "j", "n"} {
+		input, err := app.promptKey()
+		if err != nil {
+			t.Fatalf("promptKey() returned error: %v", err)
+		}
+		if input != expected {
+			t.Errorf("promptKey() = %q, expected %q", input, expected)
+		}
+	}
+
+	if _, err := app.promptKey(); err == nil {
+		t.Error("Expected an error once input is exhausted")
+	}
+}
+
+func TestPromptCommandLineMode(t *testing.T) {
+	app := &App{
+		input: bufio.NewReader(strings.NewReader("10\n s \n")),
+	}
+	isCommand := func(key string) bool { return key == "s" }
+
+	for _, expected := range []string{"10", "s"} {
+		input, err := app.promptCommand(isCommand)
+		if err != nil {
+			t.Fatalf("promptCommand() returned error: %v", err)
+		}
+		if input != expected {
+			t.Errorf("promptCommand() = %q, expected %q", input, expected)
+		}
+	}
+}
+
+func TestReadRawKeyEscapeSequences(t *testing.T) {
+	app := &App{
+		input: bufio.NewReader(strings.NewReader("\x1b[Ay\x1b[1;5C\x1bOBq\x1b")),
+	}
+
+	for _, expected := range []string{"k", "y", "\x1b[1;5C", "j", "q", "\x1b"} {
+		key, err := app.readRawKey()
+		if err != nil {
+			t.Fatalf("readRawKey() returned error: %v", err)
+		}
+		if key != expected {
+			t.Errorf("readRawKey() = %q, expected %q", key, expected)
+		}
+	}
+}
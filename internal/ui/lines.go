@@ -0,0 +1,179 @@
+package ui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cwarden/git-add--interactive/internal/git"
+)
+
+const lineSelectionHelp = `Prompt help:
+1          - toggle a single line
+3-5        - select a range of lines
+2-3,6-9    - select multiple ranges
+-...       - unselect specified lines
+*          - select all lines
+           - (empty) finish selecting`
+
+// selectHunkLines lets the user pick the individual "+" and "-" lines of a
+// hunk to use, for changes that cannot be split. The resulting hunk is
+// checked against the target before it is accepted; nil means the hunk is
+// left as it was.
+func (a *App) selectHunkLines(hunk *git.Hunk, mode git.PatchMode, header git.Hunk) (*git.Hunk, error) {
+	changed := changedLines(hunk)
+	if len(changed) == 0 {
+		return nil, nil
+	}
+
+	selected := make([]bool, len(hunk.Text))
+	for _, i := range changed {
+		selected[i] = true
+	}
+
+	for {
+		a.printHunkLines(hunk, changed, selected)
+
+		fmt.Print(a.colored(a.colors.PromptColor, "Select lines>> "))
+		input, err := a.promptSingleChar()
+		if err != nil {
+			return nil, err
+		}
+
+		switch input {
+		case "":
+			newHunk, ok := a.buildSelectedHunk(hunk, selected, mode, header)
+			if ok {
+				return newHunk, nil
+			}
+			continue
+		case "?":
+			fmt.Print(a.colored(a.colors.HelpColor, lineSelectionHelp+"\n"))
+			continue
+		case "*":
+			for _, i := range changed {
+				selected[i] = true
+			}
+			continue
+		}
+
+		deselect := strings.HasPrefix(input, "-")
+		numbers, err := parseNumberList(strings.TrimPrefix(input, "-"), len(changed))
+		if err != nil {
+			a.printError(fmt.Sprintf("%v\n", err))
+			continue
+		}
+
+		for _, num := range numbers {
+			line := changed[num-1]
+			switch {
+			case deselect:
+				selected[line] = false
+			case len(numbers) == 1:
+				selected[line] = !selected[line]
+			default:
+				selected[line] = true
+			}
+		}
+	}
+}
+
+// buildSelectedHunk turns the selection into a hunk and checks that it
+// applies. The second result is false when the user should select again.
+func (a *App) buildSelectedHunk(hunk *git.Hunk, selected []bool, mode git.PatchMode, header git.Hunk) (*git.Hunk, bool) {
+	count := 0
+	for _, isSelected := range selected {
+		if isSelected {
+			count++
+		}
+	}
+	if count == 0 {
+		fmt.Println("No lines selected; leaving the hunk unchanged.")
+		return nil, true
+	}
+
+	newHunk := a.repo.SelectHunkLines(hunk, selected, mode.IsReverse)
+	patchData := a.reassemblePatch([]git.Hunk{header, newHunk})
+	if err := a.repo.CheckPatch(patchData, mode); err != nil {
+		a.printError(fmt.Sprintf("%v\n", err))
+		a.printError("Sorry, the selected lines do not apply; please change the selection.\n")
+		return nil, false
+	}
+
+	use := true
+	newHunk.Use = &use
+	return &newHunk, true
+}
+
+func (a *App) printHunkLines(hunk *git.Hunk, changed []int, selected []bool) {
+	numbers := make(map[int]int)
+	for n, i := range changed {
+		numbers[i] = n + 1
+	}
+
+	if len(hunk.Display) > 0 {
+		fmt.Println(hunk.Display[0])
+	}
+	for i := 1; i < len(hunk.Text); i++ {
+		display := hunk.Text[i]
+		if i < len(hunk.Display) {
+			display = hunk.Display[i]
+		}
+
+		num, isChanged := numbers[i]
+		if !isChanged {
+			fmt.Printf("     %s\n", display)
+			continue
+		}
+
+		marker := " "
+		if selected[i] {
+			marker = "*"
+		}
+		fmt.Printf("%s%3d %s\n", marker, num, display)
+	}
+}
+
+// changedLines returns the indexes of the "+" and "-" lines in hunk.Text.
+func changedLines(hunk *git.Hunk) []int {
+	var changed []int
+	for i := 1; i < len(hunk.Text); i++ {
+		if strings.HasPrefix(hunk.Text[i], "+") || strings.HasPrefix(hunk.Text[i], "-") {
+			changed = append(changed, i)
+		}
+	}
+	return changed
+}
+
+// parseNumberList parses comma or space separated numbers and ranges such as
+// "1,3-5", each between 1 and max.
+func parseNumberList(input string, max int) ([]int, error) {
+	var numbers []int
+	for _, choice := range strings.FieldsFunc(input, func(r rune) bool { return r == ',' || r == ' ' }) {
+		start, end := choice, choice
+		if parts := strings.SplitN(choice, "-", 2); len(parts) == 2 {
+			start, end = parts[0], parts[1]
+		}
+
+		first, err1 := strconv.Atoi(start)
+		last, err2 := strconv.Atoi(end)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("Invalid input: %s", choice)
+		}
+		if first > last {
+			first, last = last, first
+		}
+		if first < 1 || last > max {
+			return nil, fmt.Errorf("Invalid number: %s", choice)
+		}
+
+		for num := first; num <= last; num++ {
+			numbers = append(numbers, num)
+		}
+	}
+
+	if len(numbers) == 0 {
+		return nil, fmt.Errorf("Invalid input: %s", input)
+	}
+	return numbers, nil
+}
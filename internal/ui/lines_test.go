@@ -0,0 +1,45 @@
+package ui
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cwarden/git-add--interactive/internal/git"
+)
+
+func TestParseNumberList(t *testing.T) {
+	tests := []struct {
+		input     string
+		expected  []int
+		expectErr bool
+	}{
+		{"2", []int{2}, false},
+		{"1,3-4", []int{1, 3, 4}, false},
+		{"4-3 1", []int{3, 4, 1}, false},
+		{"5", nil, true},
+		{"0", nil, true},
+		{"x", nil, true},
+		{",", nil, true},
+	}
+
+	for _, test := range tests {
+		result, err := parseNumberList(test.input, 4)
+		if (err != nil) != test.expectErr {
+			t.Errorf("parseNumberList(%q) error = %v, expected error %v", test.input, err, test.expectErr)
+			continue
+		}
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("parseNumberList(%q) = %v, expected %v", test.input, result, test.expected)
+		}
+	}
+}
+
+func TestChangedLines(t *testing.T) {
+	hunk := &git.Hunk{
+		Text: []string{"@@ -1,3 +1,3 @@", " a", "-b", "+B", "\\ No newline at end of file"},
+	}
+
+	if result := changedLines(hunk); !reflect.DeepEqual(result, []int{2, 3}) {
+		t.Errorf("changedLines() = %v, expected [2 3]", result)
+	}
+}
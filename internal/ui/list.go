@@ -0,0 +1,168 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/cwarden/git-add--interactive/internal/git"
+)
+
+// listChoiceRe matches a number or a range of numbers, which may be open at
+// the end ("3-" is 3 to the last item).
+var listChoiceRe = regexp.MustCompile(`^(\d+)(-(\d*))?$`)
+
+// itemName is what unique prefixes and filters of a list are matched
+// against.
+func itemName(item interface{}) string {
+	switch v := item.(type) {
+	case git.FileStatus:
+		return v.Path
+	case Command:
+		return v.Name
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// parseListChoices turns the answer to a list prompt into indexes into
+// names, the items on show. Choices are numbers, ranges, "*" for all of
+// them or a unique prefix of a name, separated by commas or spaces; a
+// leading "-" asks to unselect them.
+func parseListChoices(input string, names []string) ([]int, bool, error) {
+	deselect := strings.HasPrefix(input, "-")
+	if deselect {
+		input = input[1:]
+	}
+
+	var chosen []int
+	for _, choice := range strings.FieldsFunc(input, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		if choice == "*" {
+			for i := range names {
+				chosen = append(chosen, i)
+			}
+			continue
+		}
+
+		if m := listChoiceRe.FindStringSubmatch(choice); m != nil {
+			numbers := choice
+			if m[2] == "-" {
+				numbers = fmt.Sprintf("%s-%d", m[1], len(names))
+			}
+			list, err := parseNumberList(numbers, len(names))
+			if err != nil {
+				return nil, false, fmt.Errorf("Invalid number: %s", choice)
+			}
+			for _, num := range list {
+				chosen = append(chosen, num-1)
+			}
+			continue
+		}
+
+		i, ok := findUnique(choice, names)
+		if !ok {
+			return nil, false, fmt.Errorf("Invalid input: %s", choice)
+		}
+		chosen = append(chosen, i)
+	}
+
+	if len(chosen) == 0 {
+		return nil, false, fmt.Errorf("Invalid input: %s", input)
+	}
+	return chosen, deselect, nil
+}
+
+// findUnique returns the name that is exactly prefix, or else the only one
+// that starts with it.
+func findUnique(prefix string, names []string) (int, bool) {
+	found := -1
+	for i, name := range names {
+		if name == prefix {
+			return i, true
+		}
+		if strings.HasPrefix(name, prefix) {
+			if found != -1 {
+				return 0, false
+			}
+			found = i
+		}
+	}
+	return found, found != -1
+}
+
+// parseListFilter compiles the pattern of a "/pattern" answer: "/regex"
+// (that is, "//regex" at the prompt) is a regular expression, a pattern
+// with "*", "?" or "[" matches like a pathspec, and anything else matches
+// names containing its characters in order, ignoring case.
+func parseListFilter(pattern string) (func(string) bool, error) {
+	switch {
+	case strings.HasPrefix(pattern, "/"):
+		re, err := regexp.Compile(pattern[1:])
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	case strings.ContainsAny(pattern, "*?["):
+		spec, err := git.ParsePathspec([]string{pattern})
+		if err != nil {
+			return nil, err
+		}
+		return spec.Match, nil
+	default:
+		return func(name string) bool {
+			return fuzzyMatch(pattern, name)
+		}, nil
+	}
+}
+
+// fuzzyMatch reports whether the characters of pattern appear in name in
+// the same order, ignoring case.
+func fuzzyMatch(pattern, name string) bool {
+	rest := []rune(strings.ToLower(name))
+	for _, c := range strings.ToLower(pattern) {
+		i := 0
+		for i < len(rest) && rest[i] != c {
+			i++
+		}
+		if i == len(rest) {
+			return false
+		}
+		rest = rest[i+1:]
+	}
+	return true
+}
+
+// listPageSize is how many items of a list are shown at once, leaving room
+// for the header and the prompt; 0 shows all of them.
+func (a *App) listPageSize() int {
+	if a.scripted || !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+		return 0
+	}
+	rows, _ := terminalSize()
+	if rows < 8 {
+		return 0
+	}
+	return rows - 4
+}
+
+// listPage returns the bounds of page within count items, moving page back
+// onto the list if it has shrunk.
+func listPage(count, page, size int) (int, int, int) {
+	if size <= 0 || count <= size {
+		return 0, count, 0
+	}
+	pages := (count + size - 1) / size
+	if page >= pages {
+		page = pages - 1
+	}
+	if page < 0 {
+		page = 0
+	}
+	end := (page + 1) * size
+	if end > count {
+		end = count
+	}
+	return page * size, end, page
+}
@@ -0,0 +1,137 @@
+package ui
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cwarden/git-add--interactive/internal/git"
+)
+
+func TestParseListChoices(t *testing.T) {
+	names := []string{"Makefile", "main.go", "main.go.orig", "src/app.go", "src/list.go"}
+
+	tests := []struct {
+		input    string
+		expected []int
+		deselect bool
+		err      string
+	}{
+		{input: "2", expected: []int{1}},
+		{input: "1,3 5", expected: []int{0, 2, 4}},
+		{input: "2-3", expected: []int{1, 2}},
+		{input: "4-", expected: []int{3, 4}},
+		{input: "*", expected: []int{0, 1, 2, 3, 4}},
+		{input: "-1-2", expected: []int{0, 1}, deselect: true},
+		{input: "Ma", expected: []int{0}},
+		{input: "main.go", expected: []int{1}},
+		{input: "src/l,1", expected: []int{4, 0}},
+		{input: "main.go.", expected: []int{2}},
+		{input: "src/", err: "Invalid input: src/"},
+		{input: "x", err: "Invalid input: x"},
+		{input: "6", err: "Invalid number: 6"},
+		{input: "0-2", err: "Invalid number: 0-2"},
+	}
+
+	for _, test := range tests {
+		chosen, deselect, err := parseListChoices(test.input, names)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("parseListChoices(%q) error = %v, expected %q", test.input, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseListChoices(%q) returned error: %v", test.input, err)
+			continue
+		}
+		if fmt.Sprint(chosen) != fmt.Sprint(test.expected) || deselect != test.deselect {
+			t.Errorf("parseListChoices(%q) = %v, %v; expected %v, %v", test.input, chosen, deselect, test.expected, test.deselect)
+		}
+	}
+}
+
+func TestParseListFilter(t *testing.T) {
+	names := []string{"README.md", "internal/ui/app.go", "internal/ui/list.go", "main.go", "main_test.go"}
+
+	tests := []struct {
+		pattern  string
+		expected []string
+	}{
+		{"uilst", []string{"internal/ui/list.go"}},
+		{"MAIN", []string{"main.go", "main_test.go"}},
+		{"*.go", []string{"internal/ui/app.go", "internal/ui/list.go", "main.go", "main_test.go"}},
+		{"internal/*/a*", []string{"internal/ui/app.go"}},
+		{"/_test\\.go$", []string{"main_test.go"}},
+		{"/^[A-Z]", []string{"README.md"}},
+	}
+
+	for _, test := range tests {
+		match, err := parseListFilter(test.pattern)
+		if err != nil {
+			t.Errorf("parseListFilter(%q) returned error: %v", test.pattern, err)
+			continue
+		}
+		var matched []string
+		for _, name := range names {
+			if match(name) {
+				matched = append(matched, name)
+			}
+		}
+		if strings.Join(matched, "|") != strings.Join(test.expected, "|") {
+			t.Errorf("Filter %q matched %q, expected %q", test.pattern, matched, test.expected)
+		}
+	}
+
+	if _, err := parseListFilter("/("); err == nil {
+		t.Error("Expected an invalid regular expression to be rejected")
+	}
+}
+
+func TestListPage(t *testing.T) {
+	tests := []struct {
+		count, page, size        int
+		start, end, expectedPage int
+	}{
+		{count: 5, page: 0, size: 0, start: 0, end: 5},
+		{count: 5, page: 0, size: 10, start: 0, end: 5},
+		{count: 25, page: 1, size: 10, start: 10, end: 20, expectedPage: 1},
+		{count: 25, page: 2, size: 10, start: 20, end: 25, expectedPage: 2},
+		{count: 25, page: 7, size: 10, start: 20, end: 25, expectedPage: 2},
+		{count: 25, page: -1, size: 10, start: 0, end: 10},
+	}
+
+	for _, test := range tests {
+		start, end, page := listPage(test.count, test.page, test.size)
+		if start != test.start || end != test.end || page != test.expectedPage {
+			t.Errorf("listPage(%d, %d, %d) = %d, %d, %d; expected %d, %d, %d",
+				test.count, test.page, test.size, start, end, page, test.start, test.end, test.expectedPage)
+		}
+	}
+}
+
+func TestListAndChooseFilter(t *testing.T) {
+	var items []interface{}
+	for _, path := range []string{"docs/a.md", "src/a.go", "src/b.go", "src/b_test.go"} {
+		items = append(items, git.FileStatus{Path: path, Index: "unchanged", File: "+1/-0"})
+	}
+
+	// Narrow to Go files, then to tests, choose all shown; the filter is
+	// cleared and the first item chosen by prefix
+	app := &App{
+		input: bufio.NewReader(strings.NewReader("/*.go\n/test\n*\n/\ndocs\n\n")),
+	}
+	chosen, err := app.listAndChoose("Update", items, false, false)
+	if err != nil {
+		t.Fatalf("listAndChoose returned error: %v", err)
+	}
+
+	var paths []string
+	for _, item := range chosen {
+		paths = append(paths, item.(git.FileStatus).Path)
+	}
+	if strings.Join(paths, " ") != "docs/a.md src/b_test.go" {
+		t.Errorf("Chose %q, expected docs/a.md and src/b_test.go", paths)
+	}
+}
@@ -0,0 +1,261 @@
+package ui
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cwarden/git-add--interactive/internal/git"
+)
+
+// FileInfo describes a changed path for the machine interface.
+type FileInfo struct {
+	Path       string `json:"path"`
+	OldPath    string `json:"old_path,omitempty"`
+	Copy       bool   `json:"copy,omitempty"`
+	Similarity int    `json:"similarity,omitempty"`
+	Staged     string `json:"staged"`
+	Unstaged   string `json:"unstaged"`
+	Binary     bool   `json:"binary"`
+	Unmerged   bool   `json:"unmerged"`
+}
+
+// HunkInfo describes one hunk for the machine interface. The ID is derived
+// from the hunk's content, so it stays valid for as long as the diff does.
+type HunkInfo struct {
+	ID      string   `json:"id"`
+	Type    string   `json:"type"`
+	OldLine int      `json:"old_line"`
+	OldCnt  int      `json:"old_count"`
+	NewLine int      `json:"new_line"`
+	NewCnt  int      `json:"new_count"`
+	Text    []string `json:"text"`
+	Use     *bool    `json:"selected,omitempty"`
+}
+
+// HunkList is the diff of one path split into hunks.
+type HunkList struct {
+	Path     string     `json:"path"`
+	Mode     string     `json:"mode"`
+	Revision string     `json:"revision,omitempty"`
+	Header   []string   `json:"header"`
+	Hunks    []HunkInfo `json:"hunks"`
+}
+
+// ApplyResult reports the hunks applied by ApplyHunks.
+type ApplyResult struct {
+	Path    string   `json:"path"`
+	Mode    string   `json:"mode"`
+	Applied []string `json:"applied"`
+	Offsets []string `json:"offsets,omitempty"`
+}
+
+// ListFiles returns the changed paths, limited to those a patch mode would
+// offer when mode is not empty.
+func (a *App) ListFiles(mode, revision string, paths []string) ([]FileInfo, error) {
+	var err error
+	filter := ""
+	if mode != "" {
+		patchMode, exists := git.PatchModes[mode]
+		if !exists {
+			return nil, fmt.Errorf("unknown patch mode: %s", mode)
+		}
+		filter = patchMode.Filter
+		if revision, err = modeRevision(patchMode, revision); err != nil {
+			return nil, err
+		}
+	}
+
+	files, err := a.repo.ListModifiedWithRevisionAndPaths(filter, revision, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []FileInfo{}
+	for _, file := range files {
+		result = append(result, FileInfo{
+			Path:       file.Path,
+			OldPath:    file.OldPath,
+			Copy:       file.Copy,
+			Similarity: file.Similarity,
+			Staged:     file.Index,
+			Unstaged:   file.File,
+			Binary:     file.Binary,
+			Unmerged:   file.Unmerged,
+		})
+	}
+	return result, nil
+}
+
+// ListHunks returns the hunks of path as the patch mode would offer them;
+// the mode defaults to stage. oldPath names the source of a rename, as
+// ListFiles reports it, so that the rename is detected.
+func (a *App) ListHunks(path, oldPath, mode, revision string) (*HunkList, error) {
+	patchMode, header, hunks, err := a.machineHunks(path, oldPath, mode, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	return newHunkList(path, patchMode.Name, revision, header, hunks), nil
+}
+
+func newHunkList(path, mode, revision string, header git.Hunk, hunks []git.Hunk) *HunkList {
+	result := &HunkList{
+		Path:     path,
+		Mode:     mode,
+		Revision: revision,
+		Header:   header.Text,
+		Hunks:    []HunkInfo{},
+	}
+	for _, hunk := range hunks {
+		result.Hunks = append(result.Hunks, HunkInfo{
+			ID:      hunkID(hunk),
+			Type:    string(hunk.Type),
+			OldLine: hunk.OldLine,
+			OldCnt:  hunk.OldCnt,
+			NewLine: hunk.NewLine,
+			NewCnt:  hunk.NewCnt,
+			Text:    hunk.Text,
+			Use:     hunk.Use,
+		})
+	}
+	return result
+}
+
+// ApplyHunks applies the hunks of path with the given IDs, as if they had
+// been answered with y and every other hunk with n.
+func (a *App) ApplyHunks(path, oldPath, mode, revision string, ids []string) (*ApplyResult, error) {
+	patchMode, header, hunks, err := a.machineHunks(path, oldPath, mode, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[string]bool)
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	result := &ApplyResult{Path: path, Mode: patchMode.Name, Applied: []string{}}
+	for i := range hunks {
+		id := hunkID(hunks[i])
+		use := wanted[id]
+		hunks[i].Use = &use
+		if use {
+			result.Applied = append(result.Applied, id)
+			delete(wanted, id)
+		}
+	}
+
+	if len(wanted) > 0 {
+		var unknown []string
+		for id := range wanted {
+			unknown = append(unknown, id)
+		}
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown hunk IDs for %s: %s", path, strings.Join(unknown, ", "))
+	}
+
+	a.stashPatch = nil
+	a.offsets = nil
+	if err := a.applyHunkSelection(header, hunks, patchMode); err != nil {
+		return nil, err
+	}
+	result.Offsets = a.offsetMessages()
+	if patchMode.Name == "stash" && len(a.stashPatch) > 0 {
+		patchData := a.stashPatch
+		a.stashPatch = nil
+		a.recordJournal("before stashing")
+		if err := a.repo.CreateStash(patchData, ""); err != nil {
+			return nil, fmt.Errorf("cannot save the current worktree state: %v", err)
+		}
+	}
+
+	return result, nil
+}
+
+// machineHunks parses the diff of one path for a patch mode. Only the path
+// and oldPath, the source of a rename, are listed, since a rename is only
+// detected when both sides are.
+func (a *App) machineHunks(path, oldPath, mode, revision string) (git.PatchMode, git.Hunk, []git.Hunk, error) {
+	mode = modeOrDefault(mode)
+	patchMode, exists := git.PatchModes[mode]
+	if !exists {
+		return patchMode, git.Hunk{}, nil, fmt.Errorf("unknown patch mode: %s", mode)
+	}
+	revision, err := modeRevision(patchMode, revision)
+	if err != nil {
+		return patchMode, git.Hunk{}, nil, err
+	}
+
+	paths := []string{":(literal)" + path}
+	if oldPath != "" {
+		paths = append(paths, ":(literal)"+oldPath)
+	}
+	files, err := a.repo.ListModifiedWithRevisionAndPaths(patchMode.Filter, revision, paths)
+	if err != nil {
+		return patchMode, git.Hunk{}, nil, err
+	}
+
+	for _, file := range files {
+		if file.Path != path {
+			continue
+		}
+		if file.Unmerged {
+			return patchMode, git.Hunk{}, nil, fmt.Errorf("%s is unmerged", path)
+		}
+
+		hunks, err := a.repo.ParseFileDiff(file, patchMode, revision)
+		if err != nil {
+			return patchMode, git.Hunk{}, nil, err
+		}
+		if len(hunks) == 0 {
+			break
+		}
+		return patchMode, hunks[0], hunks[1:], nil
+	}
+
+	return patchMode, git.Hunk{}, nil, fmt.Errorf("no changes for %s in mode %s", path, patchMode.Name)
+}
+
+// modeOrDefault makes stage the patch mode when none is given.
+func modeOrDefault(mode string) string {
+	if mode == "" {
+		return "stage"
+	}
+	return mode
+}
+
+// modeRevision supplies the HEAD that the *_head modes diff against, and
+// insists on a revision for the *_nothead ones.
+func modeRevision(mode git.PatchMode, revision string) (string, error) {
+	switch {
+	case revision != "":
+		return revision, nil
+	case strings.HasSuffix(mode.Name, "_nothead"):
+		return "", fmt.Errorf("patch mode %s needs a revision", mode.Name)
+	case strings.HasSuffix(mode.Name, "_head"):
+		return "HEAD", nil
+	}
+	return "", nil
+}
+
+// sortedPaths returns the paths sorted and without duplicates.
+func sortedPaths(paths []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, path := range paths {
+		if !seen[path] {
+			seen[path] = true
+			result = append(result, path)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func hunkID(hunk git.Hunk) string {
+	sum := sha1.Sum([]byte(string(hunk.Type) + "\n" + strings.Join(hunk.Text, "\n")))
+	return hex.EncodeToString(sum[:])[:12]
+}
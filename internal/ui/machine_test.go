@@ -0,0 +1,55 @@
+package ui
+
+import (
+	"testing"
+
+	"github.com/cwarden/git-add--interactive/internal/git"
+)
+
+func TestHunkID(t *testing.T) {
+	first := git.Hunk{Type: git.HunkTypeHunk, Text: []string{"@@ -1 +1 @@", "-a", "+b"}}
+	second := git.Hunk{Type: git.HunkTypeHunk, Text: []string{"@@ -5 +5 @@", "-a", "+b"}}
+
+	if hunkID(first) != hunkID(first) {
+		t.Error("Expected the same hunk to get the same ID")
+	}
+	if len(hunkID(first)) != 12 {
+		t.Errorf("Expected a 12 character ID, got %q", hunkID(first))
+	}
+	if hunkID(first) == hunkID(second) {
+		t.Error("Expected hunks at different lines to get different IDs")
+	}
+
+	decided := first
+	use := true
+	decided.Use = &use
+	if hunkID(decided) != hunkID(first) {
+		t.Error("Expected the ID not to depend on the decision")
+	}
+}
+
+func TestModeRevision(t *testing.T) {
+	tests := []struct {
+		mode      string
+		revision  string
+		expected  string
+		expectErr bool
+	}{
+		{"stage", "", "", false},
+		{"reset_head", "", "HEAD", false},
+		{"checkout_head", "", "HEAD", false},
+		{"reset_nothead", "", "", true},
+		{"reset_nothead", "main~1", "main~1", false},
+	}
+
+	for _, test := range tests {
+		result, err := modeRevision(git.PatchModes[test.mode], test.revision)
+		if (err != nil) != test.expectErr {
+			t.Errorf("modeRevision(%s, %q) error = %v, expected error %v", test.mode, test.revision, err, test.expectErr)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("modeRevision(%s, %q) = %q, expected %q", test.mode, test.revision, result, test.expected)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// page shows text through pager, or prints it when there is no pager or
+// nobody to page for: a script, or output that is not a terminal.
+func (a *App) page(text, pager string) {
+	if pager == "" || a.scripted || !isTerminal(os.Stdout) {
+		fmt.Print(text)
+		return
+	}
+
+	cmd := exec.Command("sh", "-c", pager)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = pagerEnv(os.Environ())
+
+	var exitErr *exec.ExitError
+	if err := cmd.Run(); err != nil && !errors.As(err, &exitErr) {
+		a.printError(fmt.Sprintf("Could not run pager '%s': %v\n", pager, err))
+		fmt.Print(text)
+	}
+}
+
+// pagerEnv adds the settings git gives less and lv to env, unless the user
+// chose their own: quit when the text fits on one screen, pass colors
+// through and leave the screen alone on exit.
+func pagerEnv(env []string) []string {
+	defaults := []string{"LESS=FRX", "LV=-c"}
+	for _, setting := range defaults {
+		name := setting[:strings.Index(setting, "=")+1]
+		found := false
+		for _, existing := range env {
+			if strings.HasPrefix(existing, name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			env = append(env, setting)
+		}
+	}
+	return env
+}
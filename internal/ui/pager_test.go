@@ -0,0 +1,24 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPagerEnv(t *testing.T) {
+	tests := []struct {
+		env      []string
+		expected []string
+	}{
+		{[]string{"HOME=/root"}, []string{"HOME=/root", "LESS=FRX", "LV=-c"}},
+		{[]string{"LESS=-S"}, []string{"LESS=-S", "LV=-c"}},
+		{[]string{"LV=", "LESSOPEN=|x %s"}, []string{"LV=", "LESSOPEN=|x %s", "LESS=FRX"}},
+	}
+
+	for _, test := range tests {
+		result := pagerEnv(test.env)
+		if strings.Join(result, " ") != strings.Join(test.expected, " ") {
+			t.Errorf("pagerEnv(%q) = %q, expected %q", test.env, result, test.expected)
+		}
+	}
+}
@@ -23,54 +23,81 @@ var patchPrompts = map[string]map[string]string{
 		"mode":     "Stage mode change [y,n,q,a,d%s,?]? ",
 		"deletion": "Stage deletion [y,n,q,a,d%s,?]? ",
 		"addition": "Stage addition [y,n,q,a,d%s,?]? ",
+		"binary":   "Stage this binary file [y,n,q,a,d%s,?]? ",
+		"rename":   "Stage rename [y,n,q,a,d%s,?]? ",
+		"copy":     "Stage copy [y,n,q,a,d%s,?]? ",
 	},
 	"reset_head": {
 		"hunk":     "Unstage this hunk [y,n,q,a,d%s,?]? ",
 		"mode":     "Unstage mode change [y,n,q,a,d%s,?]? ",
 		"deletion": "Unstage deletion [y,n,q,a,d%s,?]? ",
 		"addition": "Unstage addition [y,n,q,a,d%s,?]? ",
+		"binary":   "Unstage this binary file [y,n,q,a,d%s,?]? ",
+		"rename":   "Unstage rename [y,n,q,a,d%s,?]? ",
+		"copy":     "Unstage copy [y,n,q,a,d%s,?]? ",
 	},
 	"checkout_index": {
 		"hunk":     "Discard this hunk from worktree [y,n,q,a,d%s,?]? ",
 		"mode":     "Discard mode change from worktree [y,n,q,a,d%s,?]? ",
 		"deletion": "Discard deletion from worktree [y,n,q,a,d%s,?]? ",
 		"addition": "Discard addition from worktree [y,n,q,a,d%s,?]? ",
+		"binary":   "Discard this binary file from worktree [y,n,q,a,d%s,?]? ",
+		"rename":   "Discard rename from worktree [y,n,q,a,d%s,?]? ",
+		"copy":     "Discard copy from worktree [y,n,q,a,d%s,?]? ",
 	},
 	"reset_nothead": {
 		"hunk":     "Apply this hunk to index [y,n,q,a,d%s,?]? ",
 		"mode":     "Apply mode change to index [y,n,q,a,d%s,?]? ",
 		"deletion": "Apply deletion to index [y,n,q,a,d%s,?]? ",
 		"addition": "Apply addition to index [y,n,q,a,d%s,?]? ",
+		"binary":   "Apply this binary file to index [y,n,q,a,d%s,?]? ",
+		"rename":   "Apply rename to index [y,n,q,a,d%s,?]? ",
+		"copy":     "Apply copy to index [y,n,q,a,d%s,?]? ",
 	},
 	"checkout_head": {
 		"hunk":     "Discard this hunk from index and worktree [y,n,q,a,d%s,?]? ",
 		"mode":     "Discard mode change from index and worktree [y,n,q,a,d%s,?]? ",
 		"deletion": "Discard deletion from index and worktree [y,n,q,a,d%s,?]? ",
 		"addition": "Discard addition from index and worktree [y,n,q,a,d%s,?]? ",
+		"binary":   "Discard this binary file from index and worktree [y,n,q,a,d%s,?]? ",
+		"rename":   "Discard rename from index and worktree [y,n,q,a,d%s,?]? ",
+		"copy":     "Discard copy from index and worktree [y,n,q,a,d%s,?]? ",
 	},
 	"checkout_nothead": {
 		"hunk":     "Apply this hunk to index and worktree [y,n,q,a,d%s,?]? ",
 		"mode":     "Apply mode change to index and worktree [y,n,q,a,d%s,?]? ",
 		"deletion": "Apply deletion to index and worktree [y,n,q,a,d%s,?]? ",
 		"addition": "Apply addition to index and worktree [y,n,q,a,d%s,?]? ",
+		"binary":   "Apply this binary file to index and worktree [y,n,q,a,d%s,?]? ",
+		"rename":   "Apply rename to index and worktree [y,n,q,a,d%s,?]? ",
+		"copy":     "Apply copy to index and worktree [y,n,q,a,d%s,?]? ",
 	},
 	"worktree_head": {
 		"hunk":     "Discard this hunk from worktree [y,n,q,a,d%s,?]? ",
 		"mode":     "Discard mode change from worktree [y,n,q,a,d%s,?]? ",
 		"deletion": "Discard deletion from worktree [y,n,q,a,d%s,?]? ",
 		"addition": "Discard addition from worktree [y,n,q,a,d%s,?]? ",
+		"binary":   "Discard this binary file from worktree [y,n,q,a,d%s,?]? ",
+		"rename":   "Discard rename from worktree [y,n,q,a,d%s,?]? ",
+		"copy":     "Discard copy from worktree [y,n,q,a,d%s,?]? ",
 	},
 	"worktree_nothead": {
 		"hunk":     "Apply this hunk to worktree [y,n,q,a,d%s,?]? ",
 		"mode":     "Apply mode change to worktree [y,n,q,a,d%s,?]? ",
 		"deletion": "Apply deletion to worktree [y,n,q,a,d%s,?]? ",
 		"addition": "Apply addition to worktree [y,n,q,a,d%s,?]? ",
+		"binary":   "Apply this binary file to worktree [y,n,q,a,d%s,?]? ",
+		"rename":   "Apply rename to worktree [y,n,q,a,d%s,?]? ",
+		"copy":     "Apply copy to worktree [y,n,q,a,d%s,?]? ",
 	},
 	"stash": {
 		"hunk":     "Stash this hunk [y,n,q,a,d%s,?]? ",
 		"mode":     "Stash mode change [y,n,q,a,d%s,?]? ",
 		"deletion": "Stash deletion [y,n,q,a,d%s,?]? ",
 		"addition": "Stash addition [y,n,q,a,d%s,?]? ",
+		"binary":   "Stash this binary file [y,n,q,a,d%s,?]? ",
+		"rename":   "Stash rename [y,n,q,a,d%s,?]? ",
+		"copy":     "Stash copy [y,n,q,a,d%s,?]? ",
 	},
 }
 
@@ -122,8 +149,8 @@ a - stash this hunk and all later hunks in the file
 d - do not stash this hunk or any of the later hunks in the file`,
 }
 
-func (a *App) patchUpdateFile(path string, mode git.PatchMode, revision string) error {
-	hunks, err := a.repo.ParseDiff(path, mode, revision)
+func (a *App) patchUpdateFile(file git.FileStatus, mode git.PatchMode, revision string) error {
+	hunks, err := a.repo.ParseFileDiff(file, mode, revision)
 	if err != nil {
 		return err
 	}
@@ -164,6 +191,7 @@ func (a *App) patchUpdateFile(path string, mode git.PatchMode, revision string)
 	}
 
 	ix := 0
+	var decisions []int // Hunks decided so far, most recent last
 	for {
 		if ix >= len(actualHunks) {
 			break
@@ -176,21 +204,23 @@ func (a *App) patchUpdateFile(path string, mode git.PatchMode, revision string)
 		}
 
 		other := a.buildOtherOptions(actualHunks, ix)
-
-		for _, line := range hunk.Display {
-			fmt.Println(line)
+		if len(decisions) > 0 {
+			other += ",u"
 		}
+		other += ",v,|"
 
-		promptKey := "hunk"
-		if hunk.Type == git.HunkTypeMode {
-			promptKey = "mode"
-		} else if hunk.Type == git.HunkTypeDeletion {
-			promptKey = "deletion"
-		} else if hunk.Type == git.HunkTypeAddition {
-			promptKey = "addition"
+		display := hunk.Display
+		if a.sideBySide {
+			_, cols := terminalSize()
+			if lines := a.renderSideBySide(hunk, cols); lines != nil {
+				display = lines
+			}
+		}
+		for _, line := range display {
+			fmt.Println(line)
 		}
 
-		prompt := fmt.Sprintf(patchPrompts[mode.Name][promptKey], other)
+		prompt := fmt.Sprintf(patchPrompts[mode.Name][hunkPromptKey(hunk)], other)
 		statusInfo := ""
 		if a.globalFilter != "" {
 			statusInfo += fmt.Sprintf(" [filter: %s]", a.globalFilter)
@@ -198,9 +228,12 @@ func (a *App) patchUpdateFile(path string, mode git.PatchMode, revision string)
 		if a.autoSplitEnabled {
 			statusInfo += " [auto-split]"
 		}
+		if a.sideBySide {
+			statusInfo += " [side-by-side]"
+		}
 		fmt.Printf("(%d/%d)%s %s", ix+1, len(actualHunks), statusInfo, a.colored(a.colors.PromptColor, prompt))
 
-		input, err := a.promptSingleChar()
+		input, err := a.promptKey()
 		if err != nil {
 			return err
 		}
@@ -216,6 +249,7 @@ func (a *App) patchUpdateFile(path string, mode git.PatchMode, revision string)
 			originalCount := len(actualHunks)
 			actualHunks = a.autoSplitAllHunks(actualHunks)
 			ix = 0 // Reset to beginning since hunk indices changed
+			decisions = nil
 			fmt.Printf(a.colored(a.colors.HeaderColor, "Auto-split enabled globally: expanded %d hunks into %d smaller hunks\n"), originalCount, len(actualHunks))
 			continue
 		}
@@ -230,20 +264,10 @@ func (a *App) patchUpdateFile(path string, mode git.PatchMode, revision string)
 			}
 
 			// Apply current file's changes first
-			selectedHunks := []git.Hunk{hunks[0]}
-			for _, hunk := range actualHunks {
-				if hunk.Use != nil && *hunk.Use {
-					selectedHunks = append(selectedHunks, hunk)
-				}
-			}
-
-			if len(selectedHunks) > 1 {
-				patchData := a.reassemblePatch(selectedHunks)
-				if err := a.repo.ApplyPatch(patchData, mode); err != nil {
-					a.printError(fmt.Sprintf("Failed to apply patch: %v\n", err))
-				}
-				a.repo.UpdateIndex()
+			if err := a.applyHunkSelection(hunks[0], actualHunks, mode); err != nil {
+				a.printError(fmt.Sprintf("Failed to apply patch: %v\n", err))
 			}
+			a.reportOffsets()
 
 			fmt.Println()
 			return ErrAcceptAll
@@ -253,13 +277,24 @@ func (a *App) patchUpdateFile(path string, mode git.PatchMode, revision string)
 		case 'y':
 			use := true
 			hunk.Use = &use
+			decisions = append(decisions, ix)
 			ix++
 
 		case 'n':
 			use := false
 			hunk.Use = &use
+			decisions = append(decisions, ix)
 			ix++
 
+		case 'u':
+			if len(decisions) == 0 {
+				a.printError("No decision to undo\n")
+				continue
+			}
+			ix = decisions[len(decisions)-1]
+			decisions = decisions[:len(decisions)-1]
+			actualHunks[ix].Use = nil
+
 		case 'q':
 			for i := ix; i < len(actualHunks); i++ {
 				if actualHunks[i].Use == nil {
@@ -268,20 +303,10 @@ func (a *App) patchUpdateFile(path string, mode git.PatchMode, revision string)
 				}
 			}
 
-			selectedHunks := []git.Hunk{hunks[0]}
-			for _, hunk := range actualHunks {
-				if hunk.Use != nil && *hunk.Use {
-					selectedHunks = append(selectedHunks, hunk)
-				}
-			}
-
-			if len(selectedHunks) > 1 {
-				patchData := a.reassemblePatch(selectedHunks)
-				if err := a.repo.ApplyPatch(patchData, mode); err != nil {
-					a.printError(fmt.Sprintf("Failed to apply patch: %v\n", err))
-				}
-				a.repo.UpdateIndex()
+			if err := a.applyHunkSelection(hunks[0], actualHunks, mode); err != nil {
+				a.printError(fmt.Sprintf("Failed to apply patch: %v\n", err))
 			}
+			a.reportOffsets()
 
 			fmt.Println()
 			return ErrQuit
@@ -310,25 +335,41 @@ func (a *App) patchUpdateFile(path string, mode git.PatchMode, revision string)
 				continue
 			}
 
-			splits := a.repo.SplitHunk(hunk)
-			if len(splits) > 1 {
-				fmt.Printf(a.colored(a.colors.HeaderColor, "Split into %d hunks.\n"), len(splits))
-				copy(actualHunks[ix:], actualHunks[ix+1:])
-				actualHunks = actualHunks[:len(actualHunks)-1]
-
-				for i, split := range splits {
-					actualHunks = append(actualHunks[:ix+i], append([]git.Hunk{split}, actualHunks[ix+i:]...)...)
-				}
+			var pieces int
+			actualHunks, pieces = a.splitHunkAt(actualHunks, ix)
+			decisions = nil
+			if pieces > 1 {
+				fmt.Printf(a.colored(a.colors.HeaderColor, "Split into %d hunks.\n"), pieces)
 			}
 
 		case 'e':
+			if hunk.Type != git.HunkTypeHunk {
+				a.printError("Sorry, cannot edit this hunk\n")
+				continue
+			}
 			newHunk, err := a.editHunk(hunk, mode, hunks[0])
 			if err != nil {
 				a.printError(fmt.Sprintf("Error editing hunk: %v\n", err))
 				continue
 			}
 			if newHunk != nil {
-				actualHunks[ix] = *newHunk
+				replaceHunk(actualHunks, ix, *newHunk, mode.IsReverse)
+				decisions = append(decisions, ix)
+			}
+
+		case 'l':
+			if hunk.Type != git.HunkTypeHunk {
+				a.printError("Sorry, cannot select lines of this hunk\n")
+				continue
+			}
+			newHunk, err := a.selectHunkLines(hunk, mode, hunks[0])
+			if err != nil {
+				a.printError(fmt.Sprintf("Error selecting lines: %v\n", err))
+				continue
+			}
+			if newHunk != nil {
+				replaceHunk(actualHunks, ix, *newHunk, mode.IsReverse)
+				decisions = append(decisions, ix)
 			}
 
 		case 'j':
@@ -347,7 +388,7 @@ func (a *App) patchUpdateFile(path string, mode git.PatchMode, revision string)
 			}
 
 		case 'g':
-			if strings.ToUpper(input) == "G" || (len(input) > 1 && strings.ToUpper(input)[0:1] == "G") {
+			if input[0] == 'G' {
 				// G <regex> command for global filtering
 				regexStr := strings.TrimSpace(input[1:])
 				if regexStr == "" {
@@ -364,13 +405,14 @@ func (a *App) patchUpdateFile(path string, mode git.PatchMode, revision string)
 					a.globalFilter = ""
 					fmt.Println("Global filter cleared")
 					// Reparse the current file without filter
-					hunks, err := a.repo.ParseDiff(path, mode, revision)
+					hunks, err := a.repo.ParseFileDiff(file, mode, revision)
 					if err != nil {
 						a.printError(fmt.Sprintf("Error reparsing hunks: %v\n", err))
 						continue
 					}
 					actualHunks = hunks[1:]
 					ix = 0
+					decisions = nil
 					continue
 				}
 
@@ -387,13 +429,17 @@ func (a *App) patchUpdateFile(path string, mode git.PatchMode, revision string)
 				fmt.Printf("Global filter set to '%s': showing %d hunks in current file\n", regexStr, len(filteredHunks))
 				actualHunks = filteredHunks
 				ix = 0
+				decisions = nil
 				continue
 			} else {
 				// Original 'g' command for goto hunk number
-				fmt.Print("go to which hunk? ")
-				gotoInput, err := a.promptSingleChar()
-				if err != nil {
-					continue
+				gotoInput := strings.TrimSpace(input[1:])
+				if gotoInput == "" {
+					fmt.Print("go to which hunk? ")
+					gotoInput, err = a.promptSingleChar()
+					if err != nil {
+						return err
+					}
 				}
 				if gotoNum, err := strconv.Atoi(gotoInput); err == nil {
 					if gotoNum >= 1 && gotoNum <= len(actualHunks) {
@@ -444,6 +490,19 @@ func (a *App) patchUpdateFile(path string, mode git.PatchMode, revision string)
 				a.printError(fmt.Sprintf("Pattern not found: %s\n", regexStr))
 			}
 
+		case 'v':
+			a.sideBySide = !a.sideBySide
+
+		case '|':
+			if command := strings.TrimSpace(input[1:]); command != "" {
+				a.hunkPager = command
+			}
+			pager := a.hunkPager
+			if pager == "" {
+				pager = a.repo.Pager("")
+			}
+			a.page(strings.Join(hunk.Display, "\n")+"\n", pager)
+
 		case '?':
 			help := patchHelp[mode.Name]
 			if help == "" {
@@ -459,10 +518,18 @@ k - leave this hunk undecided, see previous undecided hunk
 s - split the current hunk into smaller hunks
 S - enable auto-splitting globally and split all hunks
 e - manually edit the current hunk
+l - select individual lines of the current hunk
+u - undo the most recent decision in this file
+v - toggle showing hunks side by side
+| - pipe the current hunk to the pager, or to the command given after it
 ? - print help`
 			fmt.Print(a.colored(a.colors.HelpColor, help+"\n"))
 
 		default:
+			if a.scripted {
+				a.printError(fmt.Sprintf("Unknown command: %s\n", input))
+				continue
+			}
 			help := patchHelp[mode.Name]
 			if help == "" {
 				help = patchHelp["stage"]
@@ -472,25 +539,39 @@ e - manually edit the current hunk
 	}
 
 applyPatch:
-	selectedHunks := []git.Hunk{hunks[0]}
-	for _, hunk := range actualHunks {
-		if hunk.Use != nil && *hunk.Use {
-			selectedHunks = append(selectedHunks, hunk)
-		}
-	}
-
-	if len(selectedHunks) > 1 {
-		patchData := a.reassemblePatch(selectedHunks)
-		if err := a.repo.ApplyPatch(patchData, mode); err != nil {
-			a.printError(fmt.Sprintf("Failed to apply patch: %v\n", err))
-		}
-		a.repo.UpdateIndex()
+	if err := a.applyHunkSelection(hunks[0], actualHunks, mode); err != nil {
+		a.printError(fmt.Sprintf("Failed to apply patch: %v\n", err))
 	}
+	a.reportOffsets()
 
 	fmt.Println()
 	return nil
 }
 
+// hunkPromptKey selects the patchPrompts entry for the type of hunk.
+func hunkPromptKey(hunk *git.Hunk) string {
+	switch hunk.Type {
+	case git.HunkTypeMode, git.HunkTypeDeletion, git.HunkTypeAddition,
+		git.HunkTypeBinary, git.HunkTypeRename, git.HunkTypeCopy:
+		return string(hunk.Type)
+	}
+	return "hunk"
+}
+
+// splitHunkAt replaces the hunk at ix with the pieces it splits into and
+// returns the new list along with the number of pieces.
+func (a *App) splitHunkAt(hunks []git.Hunk, ix int) ([]git.Hunk, int) {
+	splits := a.repo.SplitHunk(&hunks[ix])
+	if len(splits) <= 1 {
+		return hunks, 1
+	}
+
+	result := append([]git.Hunk{}, hunks[:ix]...)
+	result = append(result, splits...)
+	result = append(result, hunks[ix+1:]...)
+	return result, len(splits)
+}
+
 func (a *App) buildOtherOptions(hunks []git.Hunk, currentIx int) string {
 	var options []string
 
@@ -538,7 +619,7 @@ func (a *App) buildOtherOptions(hunks []git.Hunk, currentIx int) string {
 	// Always show S for auto-splitting all hunks
 	options = append(options, "S")
 	if hunk.Type == git.HunkTypeHunk {
-		options = append(options, "e")
+		options = append(options, "e", "l")
 	}
 
 	if len(options) > 0 {
@@ -566,22 +647,7 @@ func (a *App) editHunk(hunk *git.Hunk, mode git.PatchMode, header git.Hunk) (*gi
 
 	defer os.Remove(hunkFile)
 
-	editor := os.Getenv("EDITOR")
-	if editor == "" {
-		editorOutput, err := a.repo.RunCommand("var", "GIT_EDITOR")
-		if err != nil {
-			editor = "vi"
-		} else {
-			editor = strings.TrimSpace(string(editorOutput))
-		}
-	}
-
-	cmd := exec.Command("sh", "-c", editor+" "+hunkFile)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	if err := cmd.Run(); err != nil {
+	if err := a.launchEditor(hunkFile); err != nil {
 		return nil, err
 	}
 
@@ -590,88 +656,121 @@ func (a *App) editHunk(hunk *git.Hunk, mode git.PatchMode, header git.Hunk) (*gi
 		return nil, err
 	}
 
-	lines := strings.Split(string(editedContent), "\n")
-	var newText []string
-	var newDisplay []string
+	newHunk := editedHunk(hunk, strings.Split(string(editedContent), "\n"))
+	if newHunk == nil {
+		return nil, nil
+	}
 
+	patchData := a.reassemblePatch([]git.Hunk{header, *newHunk})
+	if err := a.repo.CheckPatch(patchData, mode); err != nil {
+		a.printError(fmt.Sprintf("%v\n", err))
+		retry, err := a.promptYesNo("Your edited hunk does not apply. Edit again (saying \"no\" discards!) [y/n]? ")
+		if err != nil || !retry {
+			return nil, nil
+		}
+		return a.editHunk(hunk, mode, header)
+	}
+
+	return newHunk, nil
+}
+
+// editedHunk builds a hunk from the edited lines of hunk, dropping comments
+// and the blank lines at the end, and recounts it. A blank line within the
+// hunk is a context line whose space the editor stripped. It returns nil
+// when nothing is left.
+func editedHunk(hunk *git.Hunk, lines []string) *git.Hunk {
+	var newText []string
 	for _, line := range lines {
-		if !strings.HasPrefix(line, "#") && strings.TrimSpace(line) != "" {
+		if !strings.HasPrefix(line, "#") {
 			newText = append(newText, line)
-			newDisplay = append(newDisplay, line)
 		}
 	}
+	for len(newText) > 0 && newText[len(newText)-1] == "" {
+		newText = newText[:len(newText)-1]
+	}
 
 	if len(newText) == 0 {
-		return nil, nil
+		return nil
 	}
 
-	if !strings.HasPrefix(newText[0], "@@") {
-		newText = append([]string{hunk.Text[0]}, newText...)
-		newDisplay = append([]string{hunk.Display[0]}, newDisplay...)
+	header := hunk.Text[0]
+	if strings.HasPrefix(newText[0], "@@") {
+		header = newText[0]
+		newText = newText[1:]
+	}
+	if len(newText) == 0 {
+		return nil
 	}
 
 	newHunk := &git.Hunk{
-		Text:    newText,
-		Display: newDisplay,
+		Text:    []string{header},
+		Display: []string{header},
 		Type:    hunk.Type,
 		Dirty:   true,
+		OldLine: hunk.OldLine,
+		NewLine: hunk.NewLine,
+	}
+	if header == hunk.Text[0] && len(hunk.Display) > 0 {
+		newHunk.Display[0] = hunk.Display[0]
+	}
+	for _, line := range newText {
+		if line == "" {
+			line = " "
+		}
+		newHunk.Text = append(newHunk.Text, line)
+		newHunk.Display = append(newHunk.Display, line)
 	}
+	git.RecountHunk(newHunk)
 
 	use := true
 	newHunk.Use = &use
+	return newHunk
+}
 
-	patchData := a.reassemblePatch([]git.Hunk{header, *newHunk})
-	if err := a.repo.CheckPatch(patchData, mode); err != nil {
-		retry, err := a.promptYesNo("Your edited hunk does not apply. Edit again (saying \"no\" discards!) [y/n]? ")
-		if err != nil || !retry {
-			return nil, nil
-		}
-		return a.editHunk(hunk, mode, header)
+// replaceHunk puts changed, an edited version of hunks[ix], in its place.
+// The hunks after it are numbered for the lines the original adds to the
+// result, so they move by what changed adds or takes away compared to it.
+func replaceHunk(hunks []git.Hunk, ix int, changed git.Hunk, reverse bool) {
+	shift := resultLines(changed, reverse) - resultLines(hunks[ix], reverse)
+	hunks[ix] = changed
+	for i := ix + 1; i < len(hunks); i++ {
+		hunks[i] = git.RenumberHunk(hunks[i], shift, reverse)
 	}
-
-	return newHunk, nil
 }
 
-func (a *App) autoSplitAllHunks(hunks []git.Hunk) []git.Hunk {
-	var result []git.Hunk
-
-	for _, hunk := range hunks {
-		if a.repo.HunkSplittable(&hunk) {
-			// Keep splitting until no more splits are possible
-			currentSplits := []git.Hunk{hunk}
-			totalSplitRounds := 0
-
-			for {
-				var newSplits []git.Hunk
-				splitOccurred := false
-
-				for _, splitHunk := range currentSplits {
-					if a.repo.HunkSplittable(&splitHunk) {
-						splits := a.repo.SplitHunk(&splitHunk)
-						if len(splits) > 1 {
-							splitOccurred = true
-							newSplits = append(newSplits, splits...)
-						} else {
-							newSplits = append(newSplits, splitHunk)
-						}
-					} else {
-						newSplits = append(newSplits, splitHunk)
-					}
-				}
-
-				currentSplits = newSplits
-				totalSplitRounds++
-				if !splitOccurred || totalSplitRounds > 10 { // Safety limit
-					break
-				}
-			}
+// resultLines is how many lines applying hunk adds to the file.
+func resultLines(hunk git.Hunk, reverse bool) int {
+	if reverse {
+		return hunk.OldCnt - hunk.NewCnt
+	}
+	return hunk.NewCnt - hunk.OldCnt
+}
 
-			result = append(result, currentSplits...)
+func (a *App) launchEditor(path string) error {
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editorOutput, err := a.repo.RunCommand("var", "GIT_EDITOR")
+		if err != nil {
+			editor = "vi"
 		} else {
-			result = append(result, hunk)
+			editor = strings.TrimSpace(string(editorOutput))
 		}
 	}
 
+	cmd := exec.Command("sh", "-c", editor+" "+path)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+// autoSplitAllHunks splits every hunk into the smallest hunks it is made of.
+func (a *App) autoSplitAllHunks(hunks []git.Hunk) []git.Hunk {
+	var result []git.Hunk
+	for i := range hunks {
+		result = append(result, a.repo.SplitHunk(&hunks[i])...)
+	}
 	return result
 }
 
@@ -708,30 +807,191 @@ func (a *App) filterHunksByRegex(hunks []git.Hunk, regexStr string) []git.Hunk {
 	return filteredHunks
 }
 
+// selectHunks returns the header followed by every hunk marked for use,
+// renumbered for the hunks left out before it and merged with the one
+// before it where they overlap.
+// When a rename or copy is declined but other parts of the file are not,
+// the content is applied to a single path instead: the source when applying
+// forward, the destination when applying in reverse. A forward copy has no
+// such path, so it is selected along with its content.
+func selectHunks(header git.Hunk, hunks []git.Hunk, mode git.PatchMode) []git.Hunk {
+	selected := []git.Hunk{header}
+	var rename *git.Hunk
+	delta := 0 // Lines the hunks left out would have added to the result
+	for i, hunk := range hunks {
+		if hunk.Use != nil && *hunk.Use {
+			hunk = git.RenumberHunk(hunk, delta, mode.IsReverse)
+			// Pieces of a split hunk that are selected together overlap
+			last := len(selected) - 1
+			if i > 0 && last > 0 && hunks[i-1].Use != nil && *hunks[i-1].Use {
+				if merged, ok := git.MergeHunks(selected[last], hunk); ok {
+					selected[last] = merged
+					continue
+				}
+			}
+			selected = append(selected, hunk)
+		} else if hunk.Type == git.HunkTypeRename || hunk.Type == git.HunkTypeCopy {
+			rename = &hunks[i]
+		} else if hunk.Type == git.HunkTypeHunk {
+			if mode.IsReverse {
+				delta += hunk.NewCnt - hunk.OldCnt
+			} else {
+				delta += hunk.OldCnt - hunk.NewCnt
+			}
+		}
+	}
+
+	if rename == nil || len(selected) == 1 {
+		return selected
+	}
+
+	if rename.Type == git.HunkTypeCopy && !mode.IsReverse {
+		return append([]git.Hunk{header, *rename}, selected[1:]...)
+	}
+
+	selected[0] = singlePathHeader(header, *rename, mode.IsReverse)
+	return selected
+}
+
+// singlePathHeader rewrites the paths of a rename or copy header so both
+// sides name the same file.
+func singlePathHeader(header git.Hunk, rename git.Hunk, useNew bool) git.Hunk {
+	var oldPath, newPath string
+	for _, line := range rename.Text {
+		if path, ok := strings.CutPrefix(line, "rename from "); ok {
+			oldPath = path
+		} else if path, ok := strings.CutPrefix(line, "copy from "); ok {
+			oldPath = path
+		} else if path, ok := strings.CutPrefix(line, "rename to "); ok {
+			newPath = path
+		} else if path, ok := strings.CutPrefix(line, "copy to "); ok {
+			newPath = path
+		}
+	}
+
+	path := oldPath
+	if useNew {
+		path = newPath
+	}
+
+	result := git.Hunk{Type: header.Type}
+	for i, line := range header.Text {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			line = "diff --git a/" + path + " b/" + path
+		case strings.HasPrefix(line, "--- a/"):
+			line = "--- a/" + path
+		case strings.HasPrefix(line, "+++ b/"):
+			line = "+++ b/" + path
+		}
+		result.Text = append(result.Text, line)
+		if i < len(header.Display) {
+			result.Display = append(result.Display, header.Display[i])
+		}
+	}
+	return result
+}
+
+// applyHunkSelection applies the hunks of one file that are marked for use.
+func (a *App) applyHunkSelection(header git.Hunk, hunks []git.Hunk, mode git.PatchMode) error {
+	selectedHunks := selectHunks(header, hunks, mode)
+	if len(selectedHunks) <= 1 {
+		return nil
+	}
+
+	patchData := a.reassemblePatch(selectedHunks)
+	if mode.Name != "stash" && mode.UpdatesIndex() {
+		a.recordJournal(fmt.Sprintf("before %s of %s", mode.Name, headerPath(header)))
+	}
+	if err := a.applyPatch(patchData, mode); err != nil {
+		return err
+	}
+	a.repo.UpdateIndex()
+	return nil
+}
+
+// headerPath returns the path a diff header names on its new side.
+func headerPath(header git.Hunk) string {
+	for _, line := range header.Text {
+		if path, ok := strings.CutPrefix(line, "+++ b/"); ok {
+			return path
+		}
+	}
+	for _, line := range header.Text {
+		if rest, ok := strings.CutPrefix(line, "diff --git "); ok {
+			if ix := strings.LastIndex(rest, " b/"); ix >= 0 {
+				return rest[ix+3:]
+			}
+		}
+	}
+	return ""
+}
+
+// applyPatch applies the selected hunks for mode. In stash mode nothing is
+// applied yet; the patch is collected and turned into a stash entry once all
+// files have been visited. Hunks that applied away from where their headers
+// placed them are kept for reportOffsets.
+func (a *App) applyPatch(patchData []byte, mode git.PatchMode) error {
+	if mode.Name == "stash" {
+		a.stashPatch = append(a.stashPatch, patchData...)
+		return nil
+	}
+	offsets, err := a.repo.ApplyPatch(patchData, mode)
+	a.offsets = append(a.offsets, offsets...)
+	return err
+}
+
+// reportOffsets tells about the hunks applied away from where their headers
+// placed them, as git apply does.
+func (a *App) reportOffsets() {
+	for _, message := range a.offsetMessages() {
+		fmt.Println(message)
+	}
+}
+
+// offsetMessages returns the offsets not reported yet and forgets them.
+func (a *App) offsetMessages() []string {
+	var messages []string
+	for _, offset := range a.offsets {
+		messages = append(messages, offset.String())
+	}
+	a.offsets = nil
+	return messages
+}
+
 func (a *App) reassemblePatch(hunks []git.Hunk) []byte {
 	var lines []string
 
-	if len(hunks) > 0 {
-		for _, line := range hunks[0].Text {
-			if !strings.HasPrefix(line, "+++") && !strings.HasPrefix(line, "---") {
-				lines = append(lines, line)
-			}
+	if len(hunks) == 0 {
+		return []byte{}
+	}
+
+	// Everything in the header except the ---/+++ lines comes first
+	for _, line := range hunks[0].Text {
+		if !strings.HasPrefix(line, "+++") && !strings.HasPrefix(line, "---") {
+			lines = append(lines, line)
 		}
+	}
 
-		headerAdded := false
-		for _, hunk := range hunks[1:] {
-			if hunk.Type == git.HunkTypeHunk && !headerAdded {
-				for _, line := range hunks[0].Text {
-					if strings.HasPrefix(line, "+++") || strings.HasPrefix(line, "---") {
-						lines = append(lines, line)
-					}
-				}
-				headerAdded = true
-			}
-			for _, line := range hunk.Text {
+	var body []string
+	for _, hunk := range hunks[1:] {
+		body = append(body, hunk.Text...)
+	}
+
+	// Extended header lines from mode, deletion and addition hunks must
+	// come before the ---/+++ lines that start the actual diff
+	for len(body) > 0 && !strings.HasPrefix(body[0], "@@") {
+		lines = append(lines, body[0])
+		body = body[1:]
+	}
+
+	if len(body) > 0 {
+		for _, line := range hunks[0].Text {
+			if strings.HasPrefix(line, "+++") || strings.HasPrefix(line, "---") {
 				lines = append(lines, line)
 			}
 		}
+		lines = append(lines, body...)
 	}
 
 	return []byte(strings.Join(lines, "\n") + "\n")
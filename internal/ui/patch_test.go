@@ -1,6 +1,7 @@
 package ui
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/cwarden/git-add--interactive/internal/git"
@@ -222,3 +223,307 @@ func trimSpace(s string) string {
 	}
 	return s[start:end]
 }
+
+func TestApplyPatchCollectsStash(t *testing.T) {
+	app := &App{}
+	mode := git.PatchModes["stash"]
+
+	first := []byte("diff --git a/a b/a\n")
+	second := []byte("diff --git a/b b/b\n")
+
+	if err := app.applyPatch(first, mode); err != nil {
+		t.Fatalf("applyPatch() returned error: %v", err)
+	}
+	if err := app.applyPatch(second, mode); err != nil {
+		t.Fatalf("applyPatch() returned error: %v", err)
+	}
+
+	expected := string(first) + string(second)
+	if string(app.stashPatch) != expected {
+		t.Errorf("stashPatch = %q, expected %q", app.stashPatch, expected)
+	}
+}
+
+func TestReassemblePatch(t *testing.T) {
+	app := &App{}
+
+	header := git.Hunk{
+		Type: git.HunkTypeHeader,
+		Text: []string{
+			"diff --git a/script.sh b/script.sh",
+			"index 1234567..abcdefg",
+			"--- a/script.sh",
+			"+++ b/script.sh",
+		},
+	}
+	mode := git.Hunk{
+		Type: git.HunkTypeMode,
+		Text: []string{"old mode 100644", "new mode 100755"},
+	}
+	content := git.Hunk{
+		Type: git.HunkTypeHunk,
+		Text: []string{"@@ -1,2 +1,3 @@", " line 1", "+line 1.5", " line 2"},
+	}
+
+	tests := []struct {
+		name     string
+		hunks    []git.Hunk
+		expected []string
+	}{
+		{
+			name:  "mode and content",
+			hunks: []git.Hunk{header, mode, content},
+			expected: []string{
+				"diff --git a/script.sh b/script.sh",
+				"index 1234567..abcdefg",
+				"old mode 100644",
+				"new mode 100755",
+				"--- a/script.sh",
+				"+++ b/script.sh",
+				"@@ -1,2 +1,3 @@",
+				" line 1",
+				"+line 1.5",
+				" line 2",
+			},
+		},
+		{
+			name:  "mode only",
+			hunks: []git.Hunk{header, mode},
+			expected: []string{
+				"diff --git a/script.sh b/script.sh",
+				"index 1234567..abcdefg",
+				"old mode 100644",
+				"new mode 100755",
+			},
+		},
+		{
+			name:  "content only",
+			hunks: []git.Hunk{header, content},
+			expected: []string{
+				"diff --git a/script.sh b/script.sh",
+				"index 1234567..abcdefg",
+				"--- a/script.sh",
+				"+++ b/script.sh",
+				"@@ -1,2 +1,3 @@",
+				" line 1",
+				"+line 1.5",
+				" line 2",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := string(app.reassemblePatch(tt.hunks))
+			expected := strings.Join(tt.expected, "\n") + "\n"
+			if result != expected {
+				t.Errorf("reassemblePatch() = %q, expected %q", result, expected)
+			}
+		})
+	}
+}
+
+func TestSelectHunksRename(t *testing.T) {
+	header := git.Hunk{
+		Type: git.HunkTypeHeader,
+		Text: []string{
+			"diff --git a/f b/d/f2",
+			"index 92dfa21..7690fc8 100644",
+			"--- a/f",
+			"+++ b/d/f2",
+		},
+	}
+	yes, no := true, false
+	rename := git.Hunk{
+		Type: git.HunkTypeRename,
+		Text: []string{"similarity index 90%", "rename from f", "rename to d/f2"},
+		Use:  &no,
+	}
+	content := git.Hunk{
+		Type: git.HunkTypeHunk,
+		Text: []string{"@@ -8,3 +8,4 @@", " j", "+Z"},
+		Use:  &yes,
+	}
+
+	tests := []struct {
+		name         string
+		mode         string
+		expectedPath string
+	}{
+		{"forward applies to source", "stage", "f"},
+		{"reverse applies to destination", "reset_head", "d/f2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected := selectHunks(header, []git.Hunk{rename, content}, git.PatchModes[tt.mode])
+			if len(selected) != 2 {
+				t.Fatalf("Expected header and content hunk, got %d hunks", len(selected))
+			}
+
+			expected := []string{
+				"diff --git a/" + tt.expectedPath + " b/" + tt.expectedPath,
+				"index 92dfa21..7690fc8 100644",
+				"--- a/" + tt.expectedPath,
+				"+++ b/" + tt.expectedPath,
+			}
+			if strings.Join(selected[0].Text, "\n") != strings.Join(expected, "\n") {
+				t.Errorf("Header = %q, expected %q", selected[0].Text, expected)
+			}
+		})
+	}
+
+	copyHunk := rename
+	copyHunk.Type = git.HunkTypeCopy
+	selected := selectHunks(header, []git.Hunk{copyHunk, content}, git.PatchModes["stage"])
+	if len(selected) != 3 || selected[1].Type != git.HunkTypeCopy {
+		t.Errorf("Forward copy should be selected along with its content, got %d hunks", len(selected))
+	}
+}
+
+func TestSelectHunksRenumbers(t *testing.T) {
+	header := git.Hunk{Type: git.HunkTypeHeader, Text: []string{"diff --git a/f b/f", "--- a/f", "+++ b/f"}}
+	yes, no := true, false
+	hunks := []git.Hunk{
+		{Type: git.HunkTypeHunk, Text: []string{"@@ -1,2 +1,4 @@"}, OldLine: 1, OldCnt: 2, NewLine: 1, NewCnt: 4, Use: &no},
+		{Type: git.HunkTypeHunk, Text: []string{"@@ -10,3 +12,2 @@ func f()"}, OldLine: 10, OldCnt: 3, NewLine: 12, NewCnt: 2, Use: &yes},
+		{Type: git.HunkTypeHunk, Text: []string{"@@ -20,1 +21,1 @@"}, OldLine: 20, OldCnt: 1, NewLine: 21, NewCnt: 1, Use: &yes},
+	}
+
+	tests := []struct {
+		mode     string
+		expected []string
+	}{
+		{"stage", []string{"@@ -10,3 +10,2 @@ func f()", "@@ -20 +19 @@"}},
+		{"reset_head", []string{"@@ -12,3 +12,2 @@ func f()", "@@ -22 +21 @@"}},
+	}
+
+	for _, test := range tests {
+		selected := selectHunks(header, hunks, git.PatchModes[test.mode])
+		var headers []string
+		for _, hunk := range selected[1:] {
+			headers = append(headers, hunk.Text[0])
+		}
+		if strings.Join(headers, "|") != strings.Join(test.expected, "|") {
+			t.Errorf("%s: headers %q, expected %q", test.mode, headers, test.expected)
+		}
+	}
+	if hunks[1].Text[0] != "@@ -10,3 +12,2 @@ func f()" {
+		t.Errorf("selectHunks changed the hunk it was given: %q", hunks[1].Text[0])
+	}
+}
+
+func TestSelectHunksMergesPieces(t *testing.T) {
+	header := git.Hunk{Type: git.HunkTypeHeader, Text: []string{"diff --git a/f b/f", "--- a/f", "+++ b/f"}}
+	yes, no := true, false
+	pieces := []git.Hunk{
+		{Type: git.HunkTypeHunk, Text: []string{"@@ -1,2 +1,2 @@", "-a", "+A", " b"}, OldLine: 1, OldCnt: 2, NewLine: 1, NewCnt: 2, Use: &no},
+		{Type: git.HunkTypeHunk, Text: []string{"@@ -2,3 +2,2 @@", " b", "-c", " d"}, OldLine: 2, OldCnt: 3, NewLine: 2, NewCnt: 2, Use: &yes},
+		{Type: git.HunkTypeHunk, Text: []string{"@@ -4,2 +3,3 @@", " d", "+D", " e"}, OldLine: 4, OldCnt: 2, NewLine: 3, NewCnt: 3, Use: &yes},
+	}
+
+	selected := selectHunks(header, pieces, git.PatchModes["stage"])
+	if len(selected) != 2 {
+		t.Fatalf("Expected the header and one merged hunk, got %d hunks", len(selected))
+	}
+	expected := []string{"@@ -2,4 +2,4 @@", " b", "-c", " d", "+D", " e"}
+	if strings.Join(selected[1].Text, "|") != strings.Join(expected, "|") {
+		t.Errorf("Merged %q, expected %q", selected[1].Text, expected)
+	}
+}
+
+func TestEditedHunk(t *testing.T) {
+	original := &git.Hunk{
+		Type:    git.HunkTypeHunk,
+		Text:    []string{"@@ -10,3 +10,3 @@ func f()", " a", "-b", "+B", " "},
+		Display: []string{"<cyan>@@ -10,3 +10,3 @@<reset> func f()", " a", "-b", "+B", " "},
+		OldLine: 10, OldCnt: 3, NewLine: 10, NewCnt: 3,
+	}
+
+	tests := []struct {
+		name     string
+		lines    []string
+		expected []string
+		display  string
+	}{
+		{
+			name:     "added lines are recounted",
+			lines:    []string{"@@ -10,3 +10,3 @@ func f()", " a", "-b", "+B", "+C", "# comment", "", ""},
+			expected: []string{"@@ -10,2 +10,3 @@ func f()", " a", "-b", "+B", "+C"},
+			display:  "<cyan>@@ -10,2 +10,3 @@<reset> func f()",
+		},
+		{
+			name:     "blank context line in the middle is kept",
+			lines:    []string{" a", "", "-b", " c"},
+			expected: []string{"@@ -10,4 +10,3 @@ func f()", " a", " ", "-b", " c"},
+			display:  "<cyan>@@ -10,4 +10,3 @@<reset> func f()",
+		},
+		{
+			name:     "typed header without ranges",
+			lines:    []string{"@@ here @@", " a", "+x"},
+			expected: []string{"@@ -10 +10,2 @@", " a", "+x"},
+			display:  "@@ -10 +10,2 @@",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			edited := editedHunk(original, tt.lines)
+			if edited == nil {
+				t.Fatal("Expected an edited hunk")
+			}
+			if strings.Join(edited.Text, "|") != strings.Join(tt.expected, "|") {
+				t.Errorf("Edited hunk %q, expected %q", edited.Text, tt.expected)
+			}
+			if len(edited.Display) != len(edited.Text) {
+				t.Errorf("Edited hunk has %d display lines for %d lines", len(edited.Display), len(edited.Text))
+			}
+			if edited.Display[0] != tt.display {
+				t.Errorf("Edited hunk displays header %q, expected %q", edited.Display[0], tt.display)
+			}
+		})
+	}
+
+	for _, lines := range [][]string{{"# all gone", ""}, {"@@ -10,3 +10,3 @@", "", ""}} {
+		if edited := editedHunk(original, lines); edited != nil {
+			t.Errorf("Expected nothing left of %q, got %q", lines, edited.Text)
+		}
+	}
+}
+
+func TestReplaceHunk(t *testing.T) {
+	hunks := []git.Hunk{
+		{Type: git.HunkTypeHunk, Text: []string{"@@ -1,3 +1,4 @@"}, OldLine: 1, OldCnt: 3, NewLine: 1, NewCnt: 4},
+		{Type: git.HunkTypeHunk, Text: []string{"@@ -10,2 +11,2 @@"}, OldLine: 10, OldCnt: 2, NewLine: 11, NewCnt: 2},
+	}
+	edited := git.Hunk{Type: git.HunkTypeHunk, Text: []string{"@@ -1,3 +1,6 @@"}, OldLine: 1, OldCnt: 3, NewLine: 1, NewCnt: 6, Dirty: true}
+
+	forward := append([]git.Hunk{}, hunks...)
+	replaceHunk(forward, 0, edited, false)
+	if forward[0].NewCnt != 6 || forward[1].Text[0] != "@@ -10,2 +13,2 @@" {
+		t.Errorf("Forward replacement gave %q, %q", forward[0].Text[0], forward[1].Text[0])
+	}
+
+	reverse := append([]git.Hunk{}, hunks...)
+	replaceHunk(reverse, 0, edited, true)
+	if reverse[1].Text[0] != "@@ -8,2 +11,2 @@" {
+		t.Errorf("Reverse replacement gave %q", reverse[1].Text[0])
+	}
+}
+
+func TestHeaderPath(t *testing.T) {
+	tests := []struct {
+		text     []string
+		expected string
+	}{
+		{[]string{"diff --git a/f b/f", "index 1..2 100644", "--- a/f", "+++ b/f"}, "f"},
+		{[]string{"diff --git a/dir/old b/dir/gone", "deleted file mode 100644", "--- a/dir/old", "+++ /dev/null"}, "dir/gone"},
+		{[]string{"diff --git a/x.bin b/x.bin", "index 1..2 100644"}, "x.bin"},
+	}
+
+	for _, test := range tests {
+		if result := headerPath(git.Hunk{Text: test.text}); result != test.expected {
+			t.Errorf("headerPath(%q) = %q, expected %q", test.text, result, test.expected)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cwarden/git-add--interactive/internal/git"
+)
+
+const resolvePrompt = "Resolve this conflict [o,t,b,e,n,q,?]? "
+
+const resolveHelp = `o - keep our side of this conflict
+t - keep their side of this conflict
+b - keep both sides, ours first
+e - manually edit this conflict
+n - leave this conflict (and the file) unresolved
+q - quit; leave this conflict and all remaining files unresolved
+? - print help`
+
+// RunResolveMode walks every unmerged path and lets the user resolve each
+// conflict region. A file is written and added to the index only once all of
+// its regions are resolved.
+func (a *App) RunResolveMode(paths []string) error {
+	files, err := a.repo.ListModifiedWithRevisionAndPaths("", "", paths)
+	if err != nil {
+		return err
+	}
+
+	var unmerged []git.FileStatus
+	for _, file := range files {
+		if file.Unmerged {
+			unmerged = append(unmerged, file)
+		}
+	}
+
+	if len(unmerged) == 0 {
+		fmt.Println("No unmerged paths.")
+		return nil
+	}
+
+	for _, file := range unmerged {
+		if err := a.resolveFile(file.Path); err != nil {
+			if errors.Is(err, ErrQuit) {
+				break
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *App) resolveFile(path string) error {
+	conflictFile, err := a.repo.ParseConflicts(path)
+	if err != nil {
+		return err
+	}
+
+	total := conflictFile.Conflicts()
+	fmt.Print(a.colored(a.colors.HeaderColor, fmt.Sprintf("%s: %d conflict(s)\n", path, total)))
+
+	var result []string
+	ix := 0
+	for _, segment := range conflictFile.Segments {
+		if !segment.Conflict {
+			result = append(result, segment.Lines...)
+			continue
+		}
+		ix++
+
+		resolution, remove, err := a.resolveSegment(segment, conflictFile.MarkerSize, ix, total)
+		if err != nil {
+			return err
+		}
+		if remove {
+			if err := a.repo.RemoveConflict(path); err != nil {
+				return err
+			}
+			fmt.Printf("removed %s\n\n", path)
+			return nil
+		}
+		if resolution == nil {
+			fmt.Printf("Leaving %s unresolved\n\n", path)
+			return nil
+		}
+		result = append(result, resolution...)
+	}
+
+	if err := a.repo.ResolveConflict(path, []byte(strings.Join(result, ""))); err != nil {
+		return err
+	}
+
+	fmt.Printf("resolved %s\n\n", path)
+	return nil
+}
+
+// resolveSegment returns the chosen lines for one conflict region, or nil if
+// the region is left unresolved. The second result is true when the chosen
+// side deleted the file. Markers are size characters long.
+func (a *App) resolveSegment(segment git.ConflictSegment, size, ix, total int) ([]string, bool, error) {
+	for {
+		for _, line := range conflictMarkup(segment, size) {
+			fmt.Print(a.colorConflictLine(line, size))
+		}
+
+		fmt.Printf("(%d/%d) %s", ix, total, a.colored(a.colors.PromptColor, resolvePrompt))
+		input, err := a.promptKey()
+		if err != nil {
+			return nil, false, err
+		}
+
+		if input == "" {
+			continue
+		}
+
+		switch strings.ToLower(input)[0] {
+		case 'o':
+			return nonNil(segment.Ours), segment.OursDeleted, nil
+		case 't':
+			return nonNil(segment.Theirs), segment.TheirsDeleted, nil
+		case 'b':
+			return nonNil(append(withFinalNewline(segment.Ours), segment.Theirs...)), false, nil
+		case 'e':
+			lines, err := a.editConflict(segment, size)
+			if err != nil {
+				a.printError(fmt.Sprintf("Error editing conflict: %v\n", err))
+				continue
+			}
+			if hasConflictMarkers(lines, size) {
+				a.printError("Sorry, the edited conflict still has conflict markers\n")
+				continue
+			}
+			return lines, false, nil
+		case 'n':
+			return nil, false, nil
+		case 'q':
+			return nil, false, ErrQuit
+		case '?':
+			fmt.Print(a.colored(a.colors.HelpColor, resolveHelp+"\n"))
+		default:
+			if a.scripted {
+				a.printError(fmt.Sprintf("Unknown command: %s\n", input))
+				continue
+			}
+			fmt.Print(a.colored(a.colors.HelpColor, resolveHelp+"\n"))
+		}
+	}
+}
+
+func (a *App) editConflict(segment git.ConflictSegment, size int) ([]string, error) {
+	conflictFile := filepath.Join(a.repo.GitDir(), "addp-conflict-edit")
+
+	content := strings.Join(conflictMarkup(segment, size), "")
+	if err := os.WriteFile(conflictFile, []byte(content), 0644); err != nil {
+		return nil, err
+	}
+
+	defer os.Remove(conflictFile)
+
+	if err := a.launchEditor(conflictFile); err != nil {
+		return nil, err
+	}
+
+	edited, err := os.ReadFile(conflictFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	for _, line := range strings.SplitAfter(string(edited), "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return nonNil(lines), nil
+}
+
+// conflictMarkup renders a conflict region the way merges do, with markers
+// of size characters, the base when it is known and a note on a side that
+// deleted the file.
+func conflictMarkup(segment git.ConflictSegment, size int) []string {
+	label := func(marker byte, name string, deleted bool) string {
+		if deleted {
+			name += " (deleted)"
+		}
+		return strings.Repeat(string(marker), size) + " " + name + "\n"
+	}
+
+	lines := []string{label('<', "ours", segment.OursDeleted)}
+	lines = append(lines, withFinalNewline(segment.Ours)...)
+	if segment.HasBase {
+		lines = append(lines, label('|', "base", false))
+		lines = append(lines, withFinalNewline(segment.Base)...)
+	}
+	lines = append(lines, strings.Repeat("=", size)+"\n")
+	lines = append(lines, withFinalNewline(segment.Theirs)...)
+	return append(lines, label('>', "theirs", segment.TheirsDeleted))
+}
+
+func (a *App) colorConflictLine(line string, size int) string {
+	if git.IsConflictMarker(line, size) {
+		return a.colored(a.colors.FragInfoColor, strings.TrimSuffix(line, "\n")) + "\n"
+	}
+	return line
+}
+
+// hasConflictMarkers reports whether lines still hold a conflict marker of
+// size characters.
+func hasConflictMarkers(lines []string, size int) bool {
+	for _, line := range lines {
+		if git.IsConflictMarker(line, size) {
+			return true
+		}
+	}
+	return false
+}
+
+// withFinalNewline makes sure a side of a conflict ends in a newline so it
+// can be followed by more lines; the input slice is not modified.
+func withFinalNewline(lines []string) []string {
+	if len(lines) == 0 || strings.HasSuffix(lines[len(lines)-1], "\n") {
+		return lines
+	}
+	result := append([]string{}, lines...)
+	result[len(result)-1] += "\n"
+	return result
+}
+
+// nonNil distinguishes an empty resolution from an unresolved conflict.
+func nonNil(lines []string) []string {
+	if lines == nil {
+		return []string{}
+	}
+	return lines
+}
@@ -0,0 +1,72 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cwarden/git-add--interactive/internal/git"
+)
+
+func TestConflictMarkup(t *testing.T) {
+	segment := git.ConflictSegment{
+		Conflict: true,
+		Ours:     []string{"ours\n"},
+		Base:     []string{"base\n"},
+		Theirs:   []string{"theirs"},
+		HasBase:  true,
+	}
+
+	expected := "<<<<<<< ours\nours\n||||||| base\nbase\n=======\ntheirs\n>>>>>>> theirs\n"
+	if result := strings.Join(conflictMarkup(segment, git.DefaultMarkerSize), ""); result != expected {
+		t.Errorf("conflictMarkup() = %q, expected %q", result, expected)
+	}
+
+	if segment.Theirs[0] != "theirs" {
+		t.Errorf("conflictMarkup() should not modify the segment, got %q", segment.Theirs)
+	}
+
+	deleted := git.ConflictSegment{Conflict: true, Ours: []string{"ours\n"}, TheirsDeleted: true}
+	expected = "<<<<<<< ours\nours\n=======\n>>>>>>> theirs (deleted)\n"
+	if result := strings.Join(conflictMarkup(deleted, git.DefaultMarkerSize), ""); result != expected {
+		t.Errorf("conflictMarkup() = %q, expected %q", result, expected)
+	}
+}
+
+func TestHasConflictMarkers(t *testing.T) {
+	tests := []struct {
+		lines    []string
+		expected bool
+	}{
+		{[]string{"a\n", "b\n"}, false},
+		{[]string{"a\n", "=======\n"}, true},
+		{[]string{"<<<<<<< ours\n"}, true},
+		{[]string{"a ======= b\n", "======== \n"}, false},
+	}
+
+	for _, test := range tests {
+		if result := hasConflictMarkers(test.lines, git.DefaultMarkerSize); result != test.expected {
+			t.Errorf("hasConflictMarkers(%q) = %v, expected %v", test.lines, result, test.expected)
+		}
+	}
+}
+
+func TestWithFinalNewline(t *testing.T) {
+	tests := []struct {
+		name     string
+		lines    []string
+		expected []string
+	}{
+		{"empty", nil, nil},
+		{"already terminated", []string{"a\n", "b\n"}, []string{"a\n", "b\n"}},
+		{"missing newline", []string{"a\n", "b"}, []string{"a\n", "b\n"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := withFinalNewline(tt.lines)
+			if strings.Join(result, "|") != strings.Join(tt.expected, "|") {
+				t.Errorf("withFinalNewline(%q) = %q, expected %q", tt.lines, result, tt.expected)
+			}
+		})
+	}
+}
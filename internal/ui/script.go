@@ -0,0 +1,74 @@
+package ui
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// UseScript makes every prompt read its answer from a file instead of the
+// terminal. The file holds one answer per line, exactly as it would be typed;
+// lines starting with "#" are comments. Answers are echoed after their
+// prompts so that the output reads as a transcript of the session.
+func (a *App) UseScript(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var answers []string
+	for _, line := range strings.SplitAfter(string(content), "\n") {
+		if line != "" && !strings.HasPrefix(line, "#") {
+			answers = append(answers, line)
+		}
+	}
+
+	a.input = bufio.NewReader(strings.NewReader(strings.Join(answers, "")))
+	a.scripted = true
+	a.singleKey = false
+	a.tui = false
+	return nil
+}
+
+// FinishScript reports whether a scripted session went as written: no
+// answer was rejected and every answer was used.
+func (a *App) FinishScript() error {
+	if !a.scripted {
+		return nil
+	}
+	if a.scriptErr != nil {
+		return a.scriptErr
+	}
+
+	rest, _ := io.ReadAll(a.stdin())
+	if remaining := strings.TrimSpace(string(rest)); remaining != "" {
+		return fmt.Errorf("script has unused answers starting at %q", strings.SplitN(remaining, "\n", 2)[0])
+	}
+	return nil
+}
+
+// scriptAnswer returns the next scripted answer, or the error that stopped
+// the script.
+func (a *App) scriptAnswer() (string, error) {
+	if a.scriptErr != nil {
+		return "", a.scriptErr
+	}
+
+	input, err := a.stdin().ReadString('\n')
+	if errors.Is(err, io.EOF) && input != "" {
+		err = nil
+	}
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return "", fmt.Errorf("script ended before the session finished")
+		}
+		return "", err
+	}
+
+	input = strings.TrimSpace(input)
+	fmt.Println(input)
+	return input, nil
+}
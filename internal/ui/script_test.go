@@ -0,0 +1,71 @@
+package ui
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeScript(t *testing.T, content string) *App {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "answers")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	app := &App{singleKey: true, tui: true}
+	if err := app.UseScript(path); err != nil {
+		t.Fatalf("UseScript() returned error: %v", err)
+	}
+	return app
+}
+
+func TestScriptAnswers(t *testing.T) {
+	app := writeScript(t, "# stage the first hunk\ny\n\n n \n")
+	if app.singleKey || app.tui {
+		t.Error("Expected a script to turn off single keys and the TUI")
+	}
+
+	for _, expected := range []string{"y", "", "n"} {
+		input, err := app.promptKey()
+		if err != nil {
+			t.Fatalf("promptKey() returned error: %v", err)
+		}
+		if input != expected {
+			t.Errorf("promptKey() = %q, expected %q", input, expected)
+		}
+	}
+
+	if _, err := app.promptKey(); err == nil {
+		t.Error("Expected an error once the script is exhausted")
+	}
+	if err := app.FinishScript(); err != nil {
+		t.Errorf("FinishScript() returned error: %v", err)
+	}
+}
+
+func TestScriptUnusedAnswers(t *testing.T) {
+	app := writeScript(t, "y\nq\n")
+	if _, err := app.promptKey(); err != nil {
+		t.Fatalf("promptKey() returned error: %v", err)
+	}
+
+	if err := app.FinishScript(); err == nil {
+		t.Error("Expected an error for unused answers")
+	}
+}
+
+func TestScriptStopsOnError(t *testing.T) {
+	app := writeScript(t, "5\ny\n")
+	if _, err := app.promptKey(); err != nil {
+		t.Fatalf("promptKey() returned error: %v", err)
+	}
+
+	app.printError("Sorry, only 2 hunks available.\n")
+	if _, err := app.promptKey(); err == nil {
+		t.Error("Expected prompts to fail after a rejected answer")
+	}
+	if err := app.FinishScript(); err == nil {
+		t.Error("Expected FinishScript to report the rejected answer")
+	}
+}
@@ -0,0 +1,343 @@
+package ui
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/cwarden/git-add--interactive/internal/git"
+)
+
+// JSON-RPC 2.0 error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcServerError    = -32000
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// rpcParams are the parameters of every method; each method uses the ones
+// it needs.
+type rpcParams struct {
+	Mode     string   `json:"mode"`
+	Revision string   `json:"revision"`
+	Path     string   `json:"path"`
+	OldPath  string   `json:"old_path"`
+	Paths    []string `json:"paths"`
+	ID       string   `json:"id"`
+	IDs      []string `json:"ids"`
+	Text     []string `json:"text"`
+	Reload   bool     `json:"reload"`
+}
+
+// serverFile is the hunk state of one path in a server session, the same
+// state patchUpdateFile keeps while prompting.
+type serverFile struct {
+	mode     git.PatchMode
+	revision string
+	header   git.Hunk
+	hunks    []git.Hunk
+}
+
+// CommitResult reports the paths applied by the commit method, and the
+// hunks that applied away from where their headers placed them.
+type CommitResult struct {
+	Applied []string `json:"applied"`
+	Offsets []string `json:"offsets,omitempty"`
+}
+
+// Serve answers JSON-RPC 2.0 requests, one per line, until r is exhausted or
+// a shutdown request arrives. The session keeps the hunks of every path
+// asked for, so splits, edits and decisions carry over between requests
+// until the selection is committed.
+func (a *App) Serve(r io.Reader, w io.Writer) error {
+	session := make(map[string]*serverFile)
+	encoder := json.NewEncoder(w)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var request rpcRequest
+		response := rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null")}
+		if err := json.Unmarshal(line, &request); err != nil {
+			response.Error = &rpcError{Code: rpcParseError, Message: err.Error()}
+			if err := encoder.Encode(response); err != nil {
+				return err
+			}
+			continue
+		}
+
+		result, err := a.serveRequest(session, request)
+		if request.ID == nil {
+			// Notifications get no response
+			continue
+		}
+
+		response.ID = request.ID
+		if err != nil {
+			rpcErr, ok := err.(*rpcError)
+			if !ok {
+				rpcErr = &rpcError{Code: rpcServerError, Message: err.Error()}
+			}
+			response.Error = rpcErr
+		} else {
+			response.Result = result
+		}
+		if err := encoder.Encode(response); err != nil {
+			return err
+		}
+
+		if request.Method == "shutdown" {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+func (a *App) serveRequest(session map[string]*serverFile, request rpcRequest) (interface{}, error) {
+	if request.JSONRPC != "2.0" || request.Method == "" {
+		return nil, &rpcError{Code: rpcInvalidRequest, Message: "not a JSON-RPC 2.0 request"}
+	}
+
+	var params rpcParams
+	if len(request.Params) > 0 {
+		if err := json.Unmarshal(request.Params, &params); err != nil {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+		}
+	}
+
+	switch request.Method {
+	case "status":
+		return a.ListFiles(params.Mode, params.Revision, params.Paths)
+	case "hunks":
+		file, err := a.serverFile(session, params)
+		if err != nil {
+			return nil, err
+		}
+		return newHunkList(params.Path, file.mode.Name, file.revision, file.header, file.hunks), nil
+	case "split":
+		return a.serveSplit(session, params)
+	case "edit":
+		return a.serveEdit(session, params)
+	case "select", "deselect", "undecide":
+		return a.serveDecide(session, params, request.Method)
+	case "commit":
+		return a.serveCommit(session, params)
+	case "shutdown":
+		return true, nil
+	}
+	return nil, &rpcError{Code: rpcMethodNotFound, Message: "unknown method " + request.Method}
+}
+
+// serverFile returns the session state of a path, parsing its diff the first
+// time, when asked to reload, or when another mode or revision is given.
+func (a *App) serverFile(session map[string]*serverFile, params rpcParams) (*serverFile, error) {
+	if params.Path == "" {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "missing path"}
+	}
+
+	if file, ok := session[params.Path]; ok && !params.Reload &&
+		(params.Mode == "" || params.Mode == file.mode.Name) &&
+		(params.Revision == "" || params.Revision == file.revision) {
+		return file, nil
+	}
+
+	patchMode, header, hunks, err := a.machineHunks(params.Path, params.OldPath, params.Mode, params.Revision)
+	if err != nil {
+		return nil, err
+	}
+	revision, _ := modeRevision(patchMode, params.Revision)
+
+	file := &serverFile{mode: patchMode, revision: revision, header: header, hunks: hunks}
+	session[params.Path] = file
+	return file, nil
+}
+
+func (a *App) serveSplit(session map[string]*serverFile, params rpcParams) (interface{}, error) {
+	file, ix, err := a.serverHunk(session, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if !a.repo.HunkSplittable(&file.hunks[ix]) {
+		return nil, fmt.Errorf("hunk %s cannot be split", params.ID)
+	}
+	file.hunks, _ = a.splitHunkAt(file.hunks, ix)
+	return newHunkList(params.Path, file.mode.Name, file.revision, file.header, file.hunks), nil
+}
+
+func (a *App) serveEdit(session map[string]*serverFile, params rpcParams) (interface{}, error) {
+	file, ix, err := a.serverHunk(session, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if file.hunks[ix].Type != git.HunkTypeHunk {
+		return nil, fmt.Errorf("hunk %s cannot be edited", params.ID)
+	}
+	newHunk := editedHunk(&file.hunks[ix], params.Text)
+	if newHunk == nil {
+		return nil, fmt.Errorf("edited hunk is empty")
+	}
+	if err := a.repo.ParseHunkHeader(newHunk); err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+
+	patchData := a.reassemblePatch([]git.Hunk{file.header, *newHunk})
+	if err := a.repo.CheckPatch(patchData, file.mode); err != nil {
+		return nil, fmt.Errorf("edited hunk does not apply: %v", err)
+	}
+
+	replaceHunk(file.hunks, ix, *newHunk, file.mode.IsReverse)
+	return newHunkList(params.Path, file.mode.Name, file.revision, file.header, file.hunks), nil
+}
+
+func (a *App) serveDecide(session map[string]*serverFile, params rpcParams, method string) (interface{}, error) {
+	file, err := a.serverFile(session, params)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := params.IDs
+	if params.ID != "" {
+		ids = append(ids, params.ID)
+	}
+
+	var indexes []int
+	for _, id := range ids {
+		ix := findHunk(file.hunks, id)
+		if ix < 0 {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: "unknown hunk ID " + id}
+		}
+		indexes = append(indexes, ix)
+	}
+
+	for _, ix := range indexes {
+		switch method {
+		case "select", "deselect":
+			use := method == "select"
+			file.hunks[ix].Use = &use
+		default:
+			file.hunks[ix].Use = nil
+		}
+	}
+	return newHunkList(params.Path, file.mode.Name, file.revision, file.header, file.hunks), nil
+}
+
+// serveCommit applies the selected hunks of one path, or of every path in
+// the session, and forgets the applied paths. Every patch is checked before
+// any is applied; should applying still fail, the error carries the paths
+// applied until then as its data and the rest stay in the session.
+func (a *App) serveCommit(session map[string]*serverFile, params rpcParams) (interface{}, error) {
+	paths := params.Paths
+	if params.Path != "" {
+		paths = append(paths, params.Path)
+	}
+	if len(paths) == 0 {
+		for path := range session {
+			paths = append(paths, path)
+		}
+	}
+
+	paths = sortedPaths(paths)
+	for _, path := range paths {
+		file, ok := session[path]
+		if !ok {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: "no hunks loaded for " + path}
+		}
+		if file.mode.Name == "stash" {
+			continue
+		}
+		selected := selectHunks(file.header, file.hunks, file.mode)
+		if len(selected) <= 1 {
+			continue
+		}
+		if err := a.repo.CheckPatch(a.reassemblePatch(selected), file.mode); err != nil {
+			return nil, fmt.Errorf("cannot apply %s: %v", path, err)
+		}
+	}
+
+	result := &CommitResult{Applied: []string{}}
+	a.stashPatch = nil
+	a.offsets = nil
+	var stashPaths []string
+	for _, path := range paths {
+		file := session[path]
+		err := a.applyHunkSelection(file.header, file.hunks, file.mode)
+		result.Offsets = append(result.Offsets, a.offsetMessages()...)
+		if err != nil {
+			return nil, &rpcError{Code: rpcServerError, Message: fmt.Sprintf("cannot apply %s: %v", path, err), Data: result}
+		}
+		delete(session, path)
+		if file.mode.Name == "stash" {
+			stashPaths = append(stashPaths, path)
+			continue
+		}
+		result.Applied = append(result.Applied, path)
+	}
+
+	if len(a.stashPatch) > 0 {
+		patchData := a.stashPatch
+		a.stashPatch = nil
+		a.recordJournal("before stashing")
+		if err := a.repo.CreateStash(patchData, ""); err != nil {
+			return nil, &rpcError{Code: rpcServerError, Message: fmt.Sprintf("cannot save the current worktree state: %v", err), Data: result}
+		}
+		result.Applied = append(result.Applied, stashPaths...)
+	}
+	return result, nil
+}
+
+// serverHunk finds the hunk named by params.ID in the session.
+func (a *App) serverHunk(session map[string]*serverFile, params rpcParams) (*serverFile, int, error) {
+	file, err := a.serverFile(session, params)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ix := findHunk(file.hunks, params.ID)
+	if ix < 0 {
+		return nil, 0, &rpcError{Code: rpcInvalidParams, Message: "unknown hunk ID " + params.ID}
+	}
+	return file, ix, nil
+}
+
+func findHunk(hunks []git.Hunk, id string) int {
+	for i := range hunks {
+		if hunkID(hunks[i]) == id {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,68 @@
+package ui
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func serve(t *testing.T, input string) []map[string]interface{} {
+	t.Helper()
+	var output bytes.Buffer
+	app := &App{}
+	if err := app.Serve(strings.NewReader(input), &output); err != nil {
+		t.Fatalf("Serve() returned error: %v", err)
+	}
+
+	var responses []map[string]interface{}
+	decoder := json.NewDecoder(&output)
+	for decoder.More() {
+		var response map[string]interface{}
+		if err := decoder.Decode(&response); err != nil {
+			t.Fatalf("Invalid response: %v", err)
+		}
+		responses = append(responses, response)
+	}
+	return responses
+}
+
+func errorCode(response map[string]interface{}) int {
+	rpcErr, ok := response["error"].(map[string]interface{})
+	if !ok {
+		return 0
+	}
+	return int(rpcErr["code"].(float64))
+}
+
+func TestServeErrors(t *testing.T) {
+	responses := serve(t, strings.Join([]string{
+		`not json`,
+		`{"jsonrpc":"2.0","id":1,"method":"bogus"}`,
+		`{"jsonrpc":"1.0","id":2,"method":"status"}`,
+		`{"jsonrpc":"2.0","id":3,"method":"hunks","params":{}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"hunks","params":"path"}`,
+		`{"jsonrpc":"2.0","method":"bogus"}`,
+	}, "\n"))
+
+	expected := []int{rpcParseError, rpcMethodNotFound, rpcInvalidRequest, rpcInvalidParams, rpcInvalidParams}
+	if len(responses) != len(expected) {
+		t.Fatalf("Expected %d responses, got %d: %v", len(expected), len(responses), responses)
+	}
+	for i, code := range expected {
+		if errorCode(responses[i]) != code {
+			t.Errorf("Response %d: expected error code %d, got %v", i, code, responses[i])
+		}
+	}
+}
+
+func TestServeShutdown(t *testing.T) {
+	responses := serve(t, `{"jsonrpc":"2.0","id":"a","method":"shutdown"}`+"\n"+`{"jsonrpc":"2.0","id":"b","method":"bogus"}`+"\n")
+
+	if len(responses) != 1 {
+		t.Fatalf("Expected the server to stop after shutdown, got %v", responses)
+	}
+	if responses[0]["id"] != "a" || responses[0]["result"] != true {
+		t.Errorf("Unexpected shutdown response %v", responses[0])
+	}
+}
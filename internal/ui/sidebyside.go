@@ -0,0 +1,189 @@
+package ui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cwarden/git-add--interactive/internal/git"
+)
+
+// sideBySideSeparator divides the old side of a row from the new one.
+const sideBySideSeparator = " | "
+
+// minSideBySideText is the narrowest text column worth showing; below it
+// hunks are shown unified.
+const minSideBySideText = 12
+
+// sideCell is one side of a row of the side-by-side view.
+type sideCell struct {
+	num  int  // Line number, 0 for none
+	mark byte // ' ', '-' or '+', 0 for an empty cell
+	text string
+}
+
+// sideBySideRows pairs the lines of hunk into rows of old and new lines.
+// Context lines fill both sides; a run of removed lines is set against the
+// added lines that follow it.
+func sideBySideRows(hunk *git.Hunk) [][2]sideCell {
+	var rows [][2]sideCell
+	var removed, added []sideCell
+	flush := func() {
+		for i := 0; i < len(removed) || i < len(added); i++ {
+			var row [2]sideCell
+			if i < len(removed) {
+				row[0] = removed[i]
+			}
+			if i < len(added) {
+				row[1] = added[i]
+			}
+			rows = append(rows, row)
+		}
+		removed, added = nil, nil
+	}
+
+	oldNum, newNum := hunk.OldLine, hunk.NewLine
+	var last byte
+	for _, line := range hunk.Text[1:] {
+		if line == "" {
+			line = " "
+		}
+		switch line[0] {
+		case '-':
+			if len(added) > 0 {
+				flush()
+			}
+			removed = append(removed, sideCell{num: oldNum, mark: '-', text: line[1:]})
+			oldNum++
+		case '+':
+			added = append(added, sideCell{num: newNum, mark: '+', text: line[1:]})
+			newNum++
+		case '\\':
+			// "\ No newline at end of file" goes with the line before it
+			cell := sideCell{mark: ' ', text: line}
+			switch last {
+			case '-':
+				removed = append(removed, cell)
+			case '+':
+				added = append(added, cell)
+			default:
+				flush()
+				rows = append(rows, [2]sideCell{cell, cell})
+			}
+			continue
+		default:
+			flush()
+			rows = append(rows, [2]sideCell{
+				{num: oldNum, mark: ' ', text: line[1:]},
+				{num: newNum, mark: ' ', text: line[1:]},
+			})
+			oldNum++
+			newNum++
+		}
+		last = line[0]
+	}
+	flush()
+	return rows
+}
+
+// renderSideBySide lays hunk out in an old and a new column that fit in
+// width, wrapping long lines. It returns nil when the terminal is too narrow
+// or the hunk has no line numbers to show.
+func (a *App) renderSideBySide(hunk *git.Hunk, width int) []string {
+	if hunk.Type != git.HunkTypeHunk || (hunk.OldLine == 0 && hunk.NewLine == 0) {
+		return nil
+	}
+
+	numWidth := len(strconv.Itoa(hunk.OldLine + hunk.OldCnt))
+	if n := len(strconv.Itoa(hunk.NewLine + hunk.NewCnt)); n > numWidth {
+		numWidth = n
+	}
+	column := (width - len(sideBySideSeparator)) / 2
+	textWidth := column - numWidth - 2
+	if textWidth < minSideBySideText {
+		return nil
+	}
+
+	lines := []string{hunk.Display[0]}
+	for _, row := range sideBySideRows(hunk) {
+		left := wrapText(expandTabs(row[0].text), textWidth)
+		right := wrapText(expandTabs(row[1].text), textWidth)
+		for i := 0; i < len(left) || i < len(right); i++ {
+			line := a.formatSideCell(row[0], left, i, numWidth, textWidth) + sideBySideSeparator +
+				a.formatSideCell(row[1], right, i, numWidth, textWidth)
+			lines = append(lines, strings.TrimRight(line, " "))
+		}
+	}
+	return lines
+}
+
+// formatSideCell renders the part-th wrapped part of cell, padded to the
+// width of its column. The line number and mark only show on the first
+// part.
+func (a *App) formatSideCell(cell sideCell, parts []string, part, numWidth, textWidth int) string {
+	if cell.mark == 0 || part >= len(parts) {
+		return strings.Repeat(" ", numWidth+2+textWidth)
+	}
+
+	prefix := strings.Repeat(" ", numWidth+1)
+	mark := " "
+	if part == 0 {
+		if cell.num > 0 {
+			prefix = fmt.Sprintf("%*d ", numWidth, cell.num)
+		}
+		mark = string(cell.mark)
+	}
+
+	text := parts[part]
+	padding := strings.Repeat(" ", textWidth-len([]rune(text)))
+
+	var color string
+	switch cell.mark {
+	case '-':
+		color = a.colors.DiffOldColor
+	case '+':
+		color = a.colors.DiffNewColor
+	default:
+		color = a.colors.DiffCtxColor
+	}
+	if color == "" {
+		return prefix + mark + text + padding
+	}
+	return prefix + color + mark + text + a.colors.NormalColor + padding
+}
+
+// expandTabs replaces tabs by spaces up to the next multiple of eight
+// columns.
+func expandTabs(text string) string {
+	if !strings.Contains(text, "\t") {
+		return text
+	}
+	var b strings.Builder
+	col := 0
+	for _, r := range text {
+		if r == '\t' {
+			n := 8 - col%8
+			b.WriteString(strings.Repeat(" ", n))
+			col += n
+			continue
+		}
+		b.WriteRune(r)
+		col++
+	}
+	return b.String()
+}
+
+// wrapText breaks text into parts of at most width characters; empty text
+// is a single empty part.
+func wrapText(text string, width int) []string {
+	runes := []rune(text)
+	if len(runes) <= width {
+		return []string{text}
+	}
+	var parts []string
+	for len(runes) > width {
+		parts = append(parts, string(runes[:width]))
+		runes = runes[width:]
+	}
+	return append(parts, string(runes))
+}
@@ -0,0 +1,67 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cwarden/git-add--interactive/internal/git"
+)
+
+func TestRenderSideBySide(t *testing.T) {
+	hunk := &git.Hunk{
+		Type:    git.HunkTypeHunk,
+		OldLine: 9,
+		OldCnt:  5,
+		NewLine: 9,
+		NewCnt:  4,
+		Text: []string{
+			"@@ -9,5 +9,4 @@",
+			" func f() {",
+			"-\treturn a",
+			"-\t// gone",
+			"+\treturn a + b + c + d + e",
+			"",
+			"-}",
+			`\ No newline at end of file`,
+			"+}",
+		},
+	}
+	hunk.Display = hunk.Text
+
+	app := &App{}
+	lines := app.renderSideBySide(hunk, 51)
+	expected := []string{
+		"@@ -9,5 +9,4 @@",
+		" 9  func f() {           |  9  func f() {",
+		"10 -        return a     | 10 +        return a + b",
+		"                         |      + c + d + e",
+		"11 -        // gone      |",
+		"12                       | 11",
+		"13 -}                    | 12 +}",
+		"    \\ No newline at end  |",
+		"    of file              |",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("renderSideBySide rendered\n%s\nexpected\n%s", strings.Join(lines, "\n"), strings.Join(expected, "\n"))
+	}
+
+	if lines := app.renderSideBySide(hunk, 30); lines != nil {
+		t.Errorf("Expected no side-by-side view on a narrow terminal, got %q", lines)
+	}
+}
+
+func TestExpandTabs(t *testing.T) {
+	tests := []struct {
+		text, expected string
+	}{
+		{"none", "none"},
+		{"\tx", "        x"},
+		{"ab\tc\td", "ab      c       d"},
+	}
+
+	for _, test := range tests {
+		if result := expandTabs(test.text); result != test.expected {
+			t.Errorf("expandTabs(%q) = %q, expected %q", test.text, result, test.expected)
+		}
+	}
+}
@@ -0,0 +1,593 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cwarden/git-add--interactive/internal/git"
+)
+
+const tuiHelp = `y - select this hunk
+n - do not select this hunk
+a - select this and all remaining undecided hunks in the file
+d - do not select this or any remaining undecided hunks in the file
+u - forget the decision for this hunk
+j - go to the next hunk (also down arrow)
+k - go to the previous hunk (also up arrow)
+] - go to the next file (also right arrow)
+[ - go to the previous file (also left arrow)
+s - split the current hunk into smaller hunks
+S - split all hunks into the smallest possible hunks
+e - manually edit the current hunk
+l - select lines of the current hunk
+/ - show only hunks matching a regex (drops splits and edits)
+A - select all undecided hunks in all files and apply
+q - apply the selected hunks and quit
+x - quit without applying anything
+? - toggle this help`
+
+// Escape sequences for the left and right arrow keys; up and down are
+// translated by readRawKey already.
+var tuiArrowKeys = map[string]string{
+	"\x1b[C": "]",
+	"\x1b[D": "[",
+	"\x1bOC": "]",
+	"\x1bOD": "[",
+}
+
+type tuiFile struct {
+	status git.FileStatus
+	header git.Hunk
+	hunks  []git.Hunk
+	hidden map[string]*bool // Decisions on hunks the filter hides, by hunkID
+}
+
+// decided returns the number of hunks in the file that have a decision.
+func (f *tuiFile) decided() int {
+	count := 0
+	for _, hunk := range f.hunks {
+		if hunk.Use != nil {
+			count++
+		}
+	}
+	return count
+}
+
+type tuiState struct {
+	files    []tuiFile
+	fileIx   int
+	fileTop  int // The first file shown in the file pane
+	hunkIx   int
+	message  string
+	showHelp bool
+	mode     git.PatchMode
+	revision string
+	restore  func()
+}
+
+func (s *tuiState) file() *tuiFile {
+	return &s.files[s.fileIx]
+}
+
+// runTUI shows every file and hunk of a patch session on one screen. Nothing
+// is applied until the user quits with q or A; x and Ctrl-C leave the
+// repository untouched.
+func (a *App) runTUI(files []git.FileStatus, mode git.PatchMode, revision string) error {
+	state := &tuiState{mode: mode, revision: revision}
+	for _, file := range files {
+		tf := tuiFile{status: file}
+		if _, err := a.loadTUIFile(&tf, mode, revision); err != nil {
+			return err
+		}
+		state.files = append(state.files, tf)
+	}
+
+	// Ctrl-C arrives as a key so the screen can be torn down properly
+	restore, err := rawTerminal("-isig")
+	if err != nil {
+		return err
+	}
+	state.restore = restore
+	os.Stdout.WriteString("\x1b[?1049h\x1b[?25l")
+
+	apply, err := a.tuiLoop(state)
+
+	os.Stdout.WriteString("\x1b[?25h\x1b[?1049l")
+	state.restore()
+
+	if err != nil || !apply {
+		return err
+	}
+
+	for i := range state.files {
+		file := &state.files[i]
+		if err := a.applyHunkSelection(file.header, file.hunks, mode); err != nil {
+			a.printError(fmt.Sprintf("Failed to apply patch for %s: %v\n", file.status.Path, err))
+		}
+	}
+	a.reportOffsets()
+	return nil
+}
+
+// loadTUIFile parses the diff of a file, applying auto-split and the global
+// filter the same way the prompt loop does. Decisions already made on the
+// file, including those on hunks the filter hides, are kept for the hunks
+// that are parsed again; it returns how many could not be kept because
+// their hunks were split or edited.
+func (a *App) loadTUIFile(file *tuiFile, mode git.PatchMode, revision string) (int, error) {
+	decisions := make(map[string]*bool)
+	for id, use := range file.hidden {
+		decisions[id] = use
+	}
+	for _, hunk := range file.hunks {
+		if hunk.Use != nil {
+			decisions[hunkID(hunk)] = hunk.Use
+		}
+	}
+
+	hunks, err := a.repo.ParseFileDiff(file.status, mode, revision)
+	if err != nil {
+		return 0, err
+	}
+
+	file.header = git.Hunk{}
+	file.hunks = nil
+	file.hidden = nil
+	if len(hunks) == 0 {
+		return len(decisions), nil
+	}
+
+	file.header = hunks[0]
+	file.hunks = hunks[1:]
+	if a.autoSplitEnabled {
+		file.hunks = a.autoSplitAllHunks(file.hunks)
+	}
+	for i := range file.hunks {
+		id := hunkID(file.hunks[i])
+		if use, ok := decisions[id]; ok {
+			file.hunks[i].Use = use
+			delete(decisions, id)
+		}
+	}
+
+	if a.globalFilter != "" {
+		shown := a.filterHunksByRegex(file.hunks, a.globalFilter)
+		visible := make(map[string]bool)
+		for _, hunk := range shown {
+			visible[hunkID(hunk)] = true
+		}
+		for _, hunk := range file.hunks {
+			if id := hunkID(hunk); hunk.Use != nil && !visible[id] {
+				if file.hidden == nil {
+					file.hidden = make(map[string]*bool)
+				}
+				file.hidden[id] = hunk.Use
+			}
+		}
+		file.hunks = shown
+	}
+	return len(decisions), nil
+}
+
+// tuiLoop handles keys until the session ends, reporting whether the
+// selected hunks should be applied.
+func (a *App) tuiLoop(state *tuiState) (bool, error) {
+	for {
+		a.drawTUI(state)
+		state.message = ""
+
+		key, err := a.readRawKey()
+		if err != nil {
+			return false, err
+		}
+		if mapped, ok := tuiArrowKeys[key]; ok {
+			key = mapped
+		}
+		if state.showHelp && key != "q" && key != "x" && key != "\x03" {
+			state.showHelp = false
+			continue
+		}
+
+		file := state.file()
+		var hunk *git.Hunk
+		if state.hunkIx < len(file.hunks) {
+			hunk = &file.hunks[state.hunkIx]
+		}
+
+		switch key {
+		case "y", "n":
+			if hunk != nil {
+				use := key == "y"
+				hunk.Use = &use
+				a.tuiAdvance(state)
+			}
+		case "a", "d":
+			use := key == "a"
+			for i := range file.hunks {
+				if file.hunks[i].Use == nil {
+					file.hunks[i].Use = &use
+				}
+			}
+			a.tuiAdvance(state)
+		case "u":
+			if hunk != nil {
+				hunk.Use = nil
+			}
+		case "j":
+			if state.hunkIx+1 < len(file.hunks) {
+				state.hunkIx++
+			}
+		case "k":
+			if state.hunkIx > 0 {
+				state.hunkIx--
+			}
+		case "]":
+			if state.fileIx+1 < len(state.files) {
+				state.fileIx++
+				state.hunkIx = 0
+			}
+		case "[":
+			if state.fileIx > 0 {
+				state.fileIx--
+				state.hunkIx = 0
+			}
+		case "s":
+			if hunk == nil || !a.repo.HunkSplittable(hunk) {
+				state.message = "Sorry, cannot split this hunk"
+				continue
+			}
+			var pieces int
+			file.hunks, pieces = a.splitHunkAt(file.hunks, state.hunkIx)
+			state.message = fmt.Sprintf("Split into %d hunks.", pieces)
+		case "S":
+			a.autoSplitEnabled = true
+			for i := range state.files {
+				state.files[i].hunks = a.autoSplitAllHunks(state.files[i].hunks)
+			}
+			state.hunkIx = 0
+			state.message = "Auto-split enabled"
+		case "e":
+			if hunk == nil {
+				continue
+			}
+			if hunk.Type != git.HunkTypeHunk {
+				state.message = "Sorry, cannot edit this hunk"
+				continue
+			}
+			err := a.tuiSuspend(state, func() error {
+				newHunk, err := a.editHunk(hunk, state.mode, file.header)
+				if err == nil && newHunk != nil {
+					replaceHunk(file.hunks, state.hunkIx, *newHunk, state.mode.IsReverse)
+				}
+				return err
+			})
+			if err != nil {
+				state.message = fmt.Sprintf("Error editing hunk: %v", err)
+			}
+		case "l":
+			if hunk == nil {
+				continue
+			}
+			if hunk.Type != git.HunkTypeHunk {
+				state.message = "Sorry, cannot select lines of this hunk"
+				continue
+			}
+			err := a.tuiSuspend(state, func() error {
+				newHunk, err := a.selectHunkLines(hunk, state.mode, file.header)
+				if err == nil && newHunk != nil {
+					replaceHunk(file.hunks, state.hunkIx, *newHunk, state.mode.IsReverse)
+				}
+				return err
+			})
+			if err != nil {
+				state.message = fmt.Sprintf("Error selecting lines: %v", err)
+			}
+		case "/":
+			if err := a.tuiSuspend(state, func() error { return a.tuiFilter(state) }); err != nil {
+				state.message = err.Error()
+			}
+		case "A":
+			use := true
+			for i := range state.files {
+				for j := range state.files[i].hunks {
+					if state.files[i].hunks[j].Use == nil {
+						state.files[i].hunks[j].Use = &use
+					}
+				}
+			}
+			return true, nil
+		case "q":
+			return true, nil
+		case "x", "\x03":
+			return false, nil
+		case "?":
+			state.showHelp = true
+		default:
+			state.message = "Unknown key; press ? for help"
+		}
+	}
+}
+
+// tuiAdvance moves to the next undecided hunk, looking in the following files
+// once the current one is fully decided.
+func (a *App) tuiAdvance(state *tuiState) {
+	for offset := 0; offset <= len(state.files); offset++ {
+		fileIx := (state.fileIx + offset) % len(state.files)
+		start := 0
+		if offset == 0 {
+			start = state.hunkIx
+		}
+		for i := start; i < len(state.files[fileIx].hunks); i++ {
+			if state.files[fileIx].hunks[i].Use == nil {
+				state.fileIx, state.hunkIx = fileIx, i
+				return
+			}
+		}
+	}
+	state.message = "All hunks decided; press q to apply"
+}
+
+// tuiSuspend leaves the full screen so that fn can use the terminal the way
+// the prompt loop does, then takes the screen back.
+func (a *App) tuiSuspend(state *tuiState, fn func() error) error {
+	os.Stdout.WriteString("\x1b[?25h\x1b[?1049l")
+	state.restore()
+
+	fnErr := fn()
+
+	restore, err := rawTerminal("-isig")
+	if err != nil {
+		return err
+	}
+	state.restore = restore
+	os.Stdout.WriteString("\x1b[?1049h\x1b[?25l")
+	return fnErr
+}
+
+// tuiFilter asks for a regex and reloads every file with it as the global
+// filter. Decisions are kept, except on hunks that were split or edited,
+// which are parsed again whole.
+func (a *App) tuiFilter(state *tuiState) error {
+	fmt.Print(a.colored(a.colors.PromptColor, "search for which pattern (empty to clear global filter)? "))
+	input, err := a.stdin().ReadString('\n')
+	if err != nil {
+		return err
+	}
+
+	pattern := strings.TrimSpace(input)
+	if pattern != "" {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("Invalid regex pattern: %v", err)
+		}
+	}
+
+	a.globalFilter = pattern
+	dropped := 0
+	for i := range state.files {
+		n, err := a.loadTUIFile(&state.files[i], state.mode, state.revision)
+		if err != nil {
+			return err
+		}
+		dropped += n
+	}
+	state.hunkIx = 0
+	if dropped > 0 {
+		state.message = fmt.Sprintf("Dropped %d decision(s) on split or edited hunks", dropped)
+	}
+	return nil
+}
+
+func (a *App) drawTUI(state *tuiState) {
+	rows, cols := terminalSize()
+	height := rows - 1
+	listWidth := cols / 3
+	if listWidth > 40 {
+		listWidth = 40
+	}
+	paneWidth := cols - listWidth - 1
+
+	scrollFiles(state, height)
+	fileLines := a.tuiFileLines(state)[state.fileTop:]
+	var hunkLines []string
+	if state.showHelp {
+		hunkLines = strings.Split(tuiHelp, "\n")
+	} else {
+		var current int
+		hunkLines, current = a.tuiHunkLines(state)
+		if len(hunkLines) > height {
+			offset := current
+			if offset > len(hunkLines)-height {
+				offset = len(hunkLines) - height
+			}
+			hunkLines = hunkLines[offset:]
+		}
+	}
+
+	var screen strings.Builder
+	screen.WriteString("\x1b[H")
+	for row := 0; row < height; row++ {
+		left, right := "", ""
+		if row < len(fileLines) {
+			left = fileLines[row]
+		}
+		if row < len(hunkLines) {
+			right = hunkLines[row]
+		}
+		screen.WriteString(fitANSI(left, listWidth))
+		screen.WriteString("|")
+		screen.WriteString(truncateANSI(right, paneWidth))
+		screen.WriteString("\x1b[K\r\n")
+	}
+
+	screen.WriteString("\x1b[7m")
+	screen.WriteString(fitANSI(a.tuiStatusLine(state), cols))
+	screen.WriteString("\x1b[m")
+	os.Stdout.WriteString(screen.String())
+}
+
+// scrollFiles moves the file pane, height rows high, just enough to show the
+// current file.
+func scrollFiles(state *tuiState, height int) {
+	if state.fileIx < state.fileTop {
+		state.fileTop = state.fileIx
+	}
+	if height > 0 && state.fileIx >= state.fileTop+height {
+		state.fileTop = state.fileIx - height + 1
+	}
+}
+
+func (a *App) tuiFileLines(state *tuiState) []string {
+	var lines []string
+	for i := range state.files {
+		file := &state.files[i]
+		cursor := " "
+		if i == state.fileIx {
+			cursor = ">"
+		}
+		line := fmt.Sprintf("%s %d/%d %s", cursor, file.decided(), len(file.hunks), file.status.DisplayPath())
+		if i == state.fileIx {
+			line = a.colored(a.colors.HeaderColor, line)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// tuiHunkLines renders the current file, each hunk preceded by a marker line
+// showing its decision. It also returns the line where the current hunk
+// starts.
+func (a *App) tuiHunkLines(state *tuiState) ([]string, int) {
+	file := state.file()
+	lines := append([]string{}, file.header.Display...)
+	if len(file.hunks) == 0 {
+		return append(lines, "(no hunks)"), 0
+	}
+
+	current := 0
+	for i, hunk := range file.hunks {
+		cursor := " "
+		if i == state.hunkIx {
+			cursor = ">"
+			current = len(lines)
+		}
+		marker := fmt.Sprintf("%s [%s] %d/%d", cursor, tuiDecision(hunk.Use), i+1, len(file.hunks))
+		if hunk.Type != git.HunkTypeHunk {
+			marker += " " + string(hunk.Type)
+		}
+		if i == state.hunkIx {
+			marker = a.colored(a.colors.HeaderColor, marker)
+		}
+		lines = append(lines, marker)
+		lines = append(lines, hunk.Display...)
+	}
+	return lines, current
+}
+
+func tuiDecision(use *bool) string {
+	switch {
+	case use == nil:
+		return "?"
+	case *use:
+		return "y"
+	}
+	return "n"
+}
+
+func (a *App) tuiStatusLine(state *tuiState) string {
+	if state.message != "" {
+		return " " + state.message
+	}
+
+	file := state.file()
+	question := "No hunks"
+	if state.hunkIx < len(file.hunks) {
+		prompt := patchPrompts[state.mode.Name][hunkPromptKey(&file.hunks[state.hunkIx])]
+		if ix := strings.Index(prompt, " ["); ix >= 0 {
+			question = prompt[:ix] + "?"
+		}
+	}
+
+	status := fmt.Sprintf(" %s (%d/%d)", question, state.hunkIx+1, len(file.hunks))
+	if a.globalFilter != "" {
+		status += fmt.Sprintf(" [filter: %s]", a.globalFilter)
+	}
+	if a.autoSplitEnabled {
+		status += " [auto-split]"
+	}
+	return status + "  y,n,a,d,u,j,k,[,],s,S,e,l,/,A,q,x,?"
+}
+
+// truncateANSI cuts s to at most width visible columns, leaving escape
+// sequences intact and expanding tabs.
+func truncateANSI(s string, width int) string {
+	var result strings.Builder
+	visible := 0
+	escaped := false
+	for i := 0; i < len(s); {
+		if s[i] == '\x1b' {
+			end := i + 1
+			if end < len(s) && s[end] == '[' {
+				end++
+				for end < len(s) && (s[end] < 0x40 || s[end] > 0x7e) {
+					end++
+				}
+			}
+			if end < len(s) {
+				end++
+			}
+			result.WriteString(s[i:end])
+			escaped = true
+			i = end
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		if r == '\t' {
+			spaces := 8 - visible%8
+			if visible+spaces > width {
+				spaces = width - visible
+			}
+			result.WriteString(strings.Repeat(" ", spaces))
+			visible += spaces
+		} else if visible < width {
+			result.WriteRune(r)
+			visible++
+		}
+		if visible >= width {
+			break
+		}
+	}
+
+	if escaped {
+		result.WriteString("\x1b[m")
+	}
+	return result.String()
+}
+
+// fitANSI truncates or pads s to exactly width visible columns.
+func fitANSI(s string, width int) string {
+	truncated := truncateANSI(s, width)
+	return truncated + strings.Repeat(" ", width-visibleWidth(truncated))
+}
+
+func visibleWidth(s string) int {
+	width := 0
+	inEscape := false
+	for _, r := range s {
+		switch {
+		case r == '\x1b':
+			inEscape = true
+		case inEscape:
+			if r != '[' && r >= 0x40 && r <= 0x7e {
+				inEscape = false
+			}
+		default:
+			width++
+		}
+	}
+	return width
+}
@@ -0,0 +1,83 @@
+package ui
+
+import (
+	"testing"
+
+	"github.com/cwarden/git-add--interactive/internal/git"
+)
+
+func TestTruncateANSI(t *testing.T) {
+	tests := []struct {
+		input    string
+		width    int
+		expected string
+	}{
+		{"hello", 10, "hello"},
+		{"hello world", 5, "hello"},
+		{"\x1b[32m+added line\x1b[m", 4, "\x1b[32m+add\x1b[m"},
+		{"a\tb", 10, "a       b"},
+		{"a\tb", 4, "a   "},
+		{"héllo", 2, "hé"},
+	}
+
+	for _, test := range tests {
+		if result := truncateANSI(test.input, test.width); result != test.expected {
+			t.Errorf("truncateANSI(%q, %d) = %q, expected %q", test.input, test.width, result, test.expected)
+		}
+	}
+}
+
+func TestFitANSI(t *testing.T) {
+	result := fitANSI("\x1b[1mab\x1b[m", 4)
+	if visibleWidth(result) != 4 {
+		t.Errorf("fitANSI produced %q with width %d, expected 4", result, visibleWidth(result))
+	}
+	if result != "\x1b[1mab\x1b[m\x1b[m  " {
+		t.Errorf("Unexpected fitANSI result %q", result)
+	}
+}
+
+func TestTUIAdvance(t *testing.T) {
+	yes := true
+	state := &tuiState{
+		files: []tuiFile{
+			{hunks: []git.Hunk{{Use: &yes}, {Use: &yes}}},
+			{hunks: []git.Hunk{{Use: &yes}, {}}},
+			{hunks: []git.Hunk{{}}},
+		},
+		fileIx: 1,
+		hunkIx: 0,
+	}
+
+	app := &App{}
+	app.tuiAdvance(state)
+	if state.fileIx != 1 || state.hunkIx != 1 {
+		t.Errorf("Expected next undecided hunk 1/1, got %d/%d", state.fileIx, state.hunkIx)
+	}
+
+	state.files[1].hunks[1].Use = &yes
+	app.tuiAdvance(state)
+	if state.fileIx != 2 || state.hunkIx != 0 {
+		t.Errorf("Expected to move on to file 2, got %d/%d", state.fileIx, state.hunkIx)
+	}
+
+	state.files[2].hunks[0].Use = &yes
+	app.tuiAdvance(state)
+	if state.message == "" {
+		t.Error("Expected a message once every hunk is decided")
+	}
+}
+
+func TestScrollFiles(t *testing.T) {
+	state := &tuiState{files: make([]tuiFile, 10)}
+
+	for _, test := range []struct{ fileIx, expected int }{
+		{0, 0}, {3, 0}, {4, 1}, {9, 6}, {7, 6}, {2, 2}, {0, 0},
+	} {
+		state.fileIx = test.fileIx
+		scrollFiles(state, 4)
+		if state.fileTop != test.expected {
+			t.Errorf("File %d: expected the pane to start at %d, got %d", test.fileIx, test.expected, state.fileTop)
+		}
+	}
+}
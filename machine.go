@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cwarden/git-add--interactive/internal/git"
+	"github.com/cwarden/git-add--interactive/internal/ui"
+)
+
+// machineCommands are the subcommands that print JSON for editor
+// integrations instead of prompting.
+var machineCommands = map[string]bool{
+	"list-files":  true,
+	"list-hunks":  true,
+	"apply-hunks": true,
+}
+
+type machineArgs struct {
+	json     bool
+	mode     string
+	revision string
+	oldPath  string
+	args     []string
+}
+
+// parseMachineArgs parses the options of a machine subcommand. Options and
+// positional arguments may be mixed; everything after "--" is positional.
+func parseMachineArgs(command string, args []string) (*machineArgs, error) {
+	parsed := &machineArgs{}
+
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	fs.BoolVar(&parsed.json, "json", false, "print JSON")
+	fs.StringVar(&parsed.mode, "mode", "", "patch mode (stage, stash, reset_head, checkout_index, ...)")
+	fs.StringVar(&parsed.revision, "revision", "", "revision to diff against")
+	fs.StringVar(&parsed.oldPath, "old-path", "", "source of a renamed path, as list-files reports it")
+	fs.SetOutput(&nullWriter{})
+
+	var rest []string
+	for i, arg := range args {
+		if arg == "--" {
+			args, rest = args[:i], args[i+1:]
+			break
+		}
+	}
+
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, fmt.Errorf("%s: %v", command, err)
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		parsed.args = append(parsed.args, args[0])
+		args = args[1:]
+	}
+	parsed.args = append(parsed.args, rest...)
+
+	if !parsed.json {
+		return nil, fmt.Errorf("%s: only --json output is supported", command)
+	}
+	return parsed, nil
+}
+
+func runMachineCommand(command string, args []string) error {
+	parsed, err := parseMachineArgs(command, args)
+	if err != nil {
+		return err
+	}
+
+	repo, err := git.NewRepository(".")
+	if err != nil {
+		return err
+	}
+	app := ui.NewApp(repo)
+
+	var result interface{}
+	switch command {
+	case "list-files":
+		result, err = app.ListFiles(parsed.mode, parsed.revision, parsed.args)
+	case "list-hunks":
+		if len(parsed.args) != 1 {
+			return fmt.Errorf("usage: list-hunks --json [--mode=<mode>] [--revision=<rev>] [--old-path=<path>] <path>")
+		}
+		result, err = app.ListHunks(parsed.args[0], parsed.oldPath, parsed.mode, parsed.revision)
+	case "apply-hunks":
+		if len(parsed.args) < 1 {
+			return fmt.Errorf("usage: apply-hunks --json [--mode=<mode>] [--revision=<rev>] [--old-path=<path>] <path> <hunk-id>...")
+		}
+		result, err = app.ApplyHunks(parsed.args[0], parsed.oldPath, parsed.mode, parsed.revision, parsed.args[1:])
+	}
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMachineArgs(t *testing.T) {
+	tests := []struct {
+		name             string
+		args             []string
+		expectedArgs     []string
+		expectedMode     string
+		expectedRevision string
+		expectedOldPath  string
+		expectError      bool
+	}{
+		{
+			name:         "options after path",
+			args:         []string{"--json", "f.txt", "--mode=reset_head"},
+			expectedArgs: []string{"f.txt"},
+			expectedMode: "reset_head",
+		},
+		{
+			name:             "path and ids",
+			args:             []string{"--json", "--revision", "HEAD~1", "f.txt", "abc", "--mode=checkout_nothead", "def"},
+			expectedArgs:     []string{"f.txt", "abc", "def"},
+			expectedMode:     "checkout_nothead",
+			expectedRevision: "HEAD~1",
+		},
+		{
+			name:            "old path of a rename",
+			args:            []string{"--json", "new.txt", "--old-path", "old.txt"},
+			expectedArgs:    []string{"new.txt"},
+			expectedOldPath: "old.txt",
+		},
+		{
+			name:         "paths after separator",
+			args:         []string{"--json", "--", "--mode=x"},
+			expectedArgs: []string{"--mode=x"},
+		},
+		{
+			name:        "missing json",
+			args:        []string{"f.txt"},
+			expectError: true,
+		},
+		{
+			name:        "unknown option",
+			args:        []string{"--json", "--bogus"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := parseMachineArgs("list-hunks", tt.args)
+
+			if (err != nil) != tt.expectError {
+				t.Fatalf("Expected error %v, got %v", tt.expectError, err)
+			}
+			if tt.expectError {
+				return
+			}
+			if parsed.mode != tt.expectedMode {
+				t.Errorf("Expected mode %q, got %q", tt.expectedMode, parsed.mode)
+			}
+			if parsed.revision != tt.expectedRevision {
+				t.Errorf("Expected revision %q, got %q", tt.expectedRevision, parsed.revision)
+			}
+			if parsed.oldPath != tt.expectedOldPath {
+				t.Errorf("Expected old path %q, got %q", tt.expectedOldPath, parsed.oldPath)
+			}
+			if strings.Join(parsed.args, " ") != strings.Join(tt.expectedArgs, " ") {
+				t.Errorf("Expected args %q, got %q", tt.expectedArgs, parsed.args)
+			}
+		})
+	}
+}
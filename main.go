@@ -11,7 +11,21 @@ import (
 )
 
 func main() {
-	patchMode, patchRevision, files, err := parseFlags()
+	if len(os.Args) > 1 && machineCommands[os.Args[1]] {
+		if err := runMachineCommand(os.Args[1], os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	args, options, err := extractUIOptions(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	patchMode, patchRevision, files, err := processArgs(args)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
@@ -23,7 +37,38 @@ func main() {
 		os.Exit(1)
 	}
 
+	if options.wordDiff {
+		repo.EnableWordDiff()
+	}
+
 	app := ui.NewApp(repo)
+	if options.tui {
+		app.EnableTUI()
+		if patchMode == "" {
+			patchMode = "stage"
+		}
+	}
+	if options.includeUntracked {
+		if patchMode != "" && patchMode != "stage" {
+			fmt.Fprintf(os.Stderr, "Error: --include-untracked only applies when staging\n")
+			os.Exit(1)
+		}
+		app.IncludeUntracked()
+	}
+	if options.script != "" {
+		if err := app.UseScript(options.script); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if options.serve {
+		if err := app.Serve(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	if patchMode != "" {
 		if err := app.RunPatchMode(patchMode, patchRevision, files); err != nil {
@@ -31,15 +76,61 @@ func main() {
 			os.Exit(1)
 		}
 	} else {
-		if err := app.RunInteractive(); err != nil {
+		if err := app.RunInteractive(files); err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
 	}
+
+	if err := app.FinishScript(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
 }
 
-func parseFlags() (patchMode, patchRevision string, files []string, err error) {
-	return processArgs(os.Args[1:])
+// uiOptions control what is offered and how choices are made rather than
+// what is being done, so they are taken out before the git-compatible
+// arguments are parsed.
+type uiOptions struct {
+	tui              bool
+	script           string
+	serve            bool
+	includeUntracked bool
+	wordDiff         bool
+}
+
+// extractUIOptions removes --tui, --script, --serve, --include-untracked and
+// --word-diff from the options, which may appear anywhere before the "--"
+// separator.
+func extractUIOptions(args []string) ([]string, uiOptions, error) {
+	var result []string
+	var options uiOptions
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			return append(result, args[i:]...), options, nil
+		case arg == "--tui":
+			options.tui = true
+		case arg == "--serve":
+			options.serve = true
+		case arg == "--include-untracked":
+			options.includeUntracked = true
+		case arg == "--word-diff":
+			options.wordDiff = true
+		case arg == "--script":
+			if i+1 >= len(args) {
+				return nil, options, fmt.Errorf("option --script requires a file")
+			}
+			i++
+			options.script = args[i]
+		case strings.HasPrefix(arg, "--script="):
+			options.script = strings.TrimPrefix(arg, "--script=")
+		default:
+			result = append(result, arg)
+		}
+	}
+	return result, options, nil
 }
 
 func processArgs(args []string) (patchMode, patchRevision string, files []string, err error) {
@@ -48,7 +139,7 @@ func processArgs(args []string) (patchMode, patchRevision string, files []string
 
 	// Create a new flag set to avoid conflicts with testing
 	fs := flag.NewFlagSet("git-add--interactive", flag.ContinueOnError)
-	fs.StringVar(&patchFlag, "patch", "", "enable patch mode (stage, reset, checkout, worktree, stash)")
+	fs.StringVar(&patchFlag, "patch", "", "enable patch mode (stage, reset, checkout, worktree, stash, resolve)")
 
 	// Disable default error output from flag parsing
 	fs.SetOutput(&nullWriter{})
@@ -74,6 +165,14 @@ func processArgs(args []string) (patchMode, patchRevision string, files []string
 	// Get remaining arguments (files/paths)
 	remaining := fs.Args()
 
+	// The flag parser swallows a "--" that comes before any other argument,
+	// after which none of them is a revision; put it back so the code below
+	// sees where the paths start
+	hasSeparator := containsArg(args, "--")
+	if hasSeparator && !containsArg(remaining, "--") {
+		remaining = append([]string{"--"}, remaining...)
+	}
+
 	// Handle the case where we have paths without --patch (assume stage mode)
 	if !patchProvided && len(remaining) > 0 {
 		// Check if first arg is "--" (interactive mode with paths)
@@ -91,15 +190,6 @@ func processArgs(args []string) (patchMode, patchRevision string, files []string
 			patchFlag = ""
 		}
 
-		// Check if -- was present in original args
-		hasSeparator := false
-		for _, arg := range args {
-			if arg == "--" {
-				hasSeparator = true
-				break
-			}
-		}
-
 		// Validate that -- separator is present for certain modes
 		if err := validatePatchMode(patchFlag, remaining, args); err != nil {
 			return "", "", nil, err
@@ -111,11 +201,13 @@ func processArgs(args []string) (patchMode, patchRevision string, files []string
 			patchMode = "stage"
 		case "stash":
 			patchMode = "stash"
+		case "resolve":
+			patchMode = "resolve"
 		case "reset":
 			patchMode, patchRevision = parsePatchReset(remaining)
 			remaining = skipRevisionAndSeparator(remaining)
 		case "checkout":
-			patchMode, patchRevision = parsePatchCheckout(remaining, hasSeparator)
+			patchMode, patchRevision = parsePatchCheckout(remaining)
 			if patchMode != "checkout_index" {
 				remaining = skipRevisionAndSeparator(remaining)
 			}
@@ -206,36 +298,13 @@ func parsePatchReset(args []string) (mode, revision string) {
 	return "reset_nothead", revision
 }
 
-func parsePatchCheckout(args []string, hasSeparator bool) (mode, revision string) {
+// parsePatchCheckout takes the revision to check out from, if any, from
+// before the "--" separator; everything after it is a pathspec.
+func parsePatchCheckout(args []string) (mode, revision string) {
 	if len(args) == 0 || args[0] == "--" {
 		return "checkout_index", ""
 	}
 
-	// When there's a separator, we need to be careful about what we treat as a revision
-	// vs a pathspec. Common pathspecs start with special characters or look like paths
-	if hasSeparator {
-		firstArg := args[0]
-		// Check if the first argument looks like a pathspec rather than a revision
-		if strings.HasPrefix(firstArg, ":") || // pathspec magic like :(prefix:0)
-			strings.HasSuffix(firstArg, "/") || // directory paths
-			strings.Contains(firstArg, "*") || // glob patterns
-			strings.Contains(firstArg, "?") { // glob patterns
-			return "checkout_index", ""
-		}
-		// If it doesn't look like a pathspec and we have multiple args,
-		// treat the first as a revision
-		if len(args) > 1 {
-			revision = firstArg
-			if revision == "HEAD" {
-				return "checkout_head", revision
-			}
-			return "checkout_nothead", revision
-		}
-		// Single argument that doesn't look like a pathspec - could be a revision
-		// but let's be conservative and treat it as a pathspec for checkout_index
-		return "checkout_index", ""
-	}
-
 	revision = args[0]
 	if revision == "HEAD" {
 		return "checkout_head", revision
@@ -255,6 +324,15 @@ func parsePatchWorktree(args []string) (mode, revision string) {
 	return "worktree_nothead", revision
 }
 
+func containsArg(args []string, arg string) bool {
+	for _, a := range args {
+		if a == arg {
+			return true
+		}
+	}
+	return false
+}
+
 func skipRevisionAndSeparator(args []string) []string {
 	if len(args) == 0 {
 		return args
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -23,6 +24,12 @@ func TestProcessArgs(t *testing.T) {
 			args:         []string{"--"},
 			expectedMode: "",
 		},
+		{
+			name:          "interactive mode with paths",
+			args:          []string{"--", "*.md", "docs/"},
+			expectedMode:  "",
+			expectedFiles: []string{"*.md", "docs/"},
+		},
 		{
 			name:         "patch mode basic",
 			args:         []string{"--patch", "--"},
@@ -38,6 +45,11 @@ func TestProcessArgs(t *testing.T) {
 			args:         []string{"--patch=stash", "--"},
 			expectedMode: "stash",
 		},
+		{
+			name:         "patch mode with resolve",
+			args:         []string{"--patch=resolve", "--"},
+			expectedMode: "resolve",
+		},
 		{
 			name:             "patch mode with reset",
 			args:             []string{"--patch=reset", "--"},
@@ -145,6 +157,19 @@ func TestProcessArgs(t *testing.T) {
 			expectedRevision: "HEAD~1",
 			expectedFiles:    []string{"src/"},
 		},
+		{
+			name:          "checkout with a path named like a revision",
+			args:          []string{"--patch=checkout", "--", "main", "src/"},
+			expectedMode:  "checkout_index",
+			expectedFiles: []string{"main", "src/"},
+		},
+		{
+			name:             "reset with pathspec only",
+			args:             []string{"--patch=reset", "--", "file.txt"},
+			expectedMode:     "reset_head",
+			expectedRevision: "HEAD",
+			expectedFiles:    []string{"file.txt"},
+		},
 		{
 			name:          "stage with pathspec",
 			args:          []string{"--patch=stage", "--", "modified.txt"},
@@ -201,63 +226,60 @@ func TestParsePatchCheckout(t *testing.T) {
 	tests := []struct {
 		name         string
 		args         []string
-		hasSeparator bool
 		expectedMode string
 		expectedRev  string
 	}{
 		{
-			name:         "checkout with separator and single pathspec - should be checkout_index",
-			args:         []string{":(,prefix:0)salesforce/"},
-			hasSeparator: true,
+			name:         "single pathspec after separator",
+			args:         []string{"--", ":(,prefix:0)salesforce/"},
 			expectedMode: "checkout_index",
 			expectedRev:  "",
 		},
 		{
-			name:         "checkout with separator and revision plus pathspec",
-			args:         []string{"HEAD~1", "src/"},
-			hasSeparator: true,
+			name:         "revision before separator",
+			args:         []string{"HEAD~1", "--", "src/"},
 			expectedMode: "checkout_nothead",
 			expectedRev:  "HEAD~1",
 		},
 		{
-			name:         "checkout with separator and multiple pathspecs",
-			args:         []string{"src/", "test/"},
-			hasSeparator: true,
+			name:         "multiple pathspecs after separator",
+			args:         []string{"--", "src/", "test/"},
+			expectedMode: "checkout_index",
+			expectedRev:  "",
+		},
+		{
+			name:         "pathspec magic after separator",
+			args:         []string{"--", ":(exclude)*.tmp", "*.go"},
 			expectedMode: "checkout_index",
 			expectedRev:  "",
 		},
 		{
-			name:         "checkout with separator and pathspec magic",
-			args:         []string{":(exclude)*.tmp", "*.go"},
-			hasSeparator: true,
+			name:         "revision-like path after separator",
+			args:         []string{"--", "main", "src/"},
 			expectedMode: "checkout_index",
 			expectedRev:  "",
 		},
 		{
 			name:         "checkout without separator - should parse as revision",
 			args:         []string{"HEAD"},
-			hasSeparator: false,
 			expectedMode: "checkout_head",
 			expectedRev:  "HEAD",
 		},
 		{
 			name:         "checkout with custom revision",
 			args:         []string{"main"},
-			hasSeparator: false,
 			expectedMode: "checkout_nothead",
 			expectedRev:  "main",
 		},
 		{
-			name:         "checkout with empty args and separator",
+			name:         "checkout with empty args",
 			args:         []string{},
-			hasSeparator: true,
 			expectedMode: "checkout_index",
 			expectedRev:  "",
 		},
 		{
 			name:         "checkout with -- in args",
 			args:         []string{"--"},
-			hasSeparator: true,
 			expectedMode: "checkout_index",
 			expectedRev:  "",
 		},
@@ -265,7 +287,7 @@ func TestParsePatchCheckout(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			mode, rev := parsePatchCheckout(tt.args, tt.hasSeparator)
+			mode, rev := parsePatchCheckout(tt.args)
 
 			if mode != tt.expectedMode {
 				t.Errorf("Expected mode %q, got %q", tt.expectedMode, mode)
@@ -328,3 +350,95 @@ func TestSkipRevisionAndSeparator(t *testing.T) {
 		})
 	}
 }
+
+func TestExtractUIOptions(t *testing.T) {
+	tests := []struct {
+		name              string
+		args              []string
+		expected          []string
+		expectedTUI       bool
+		expectedScript    string
+		expectedUntracked bool
+		expectedWordDiff  bool
+		expectError       bool
+	}{
+		{
+			name:     "no ui options",
+			args:     []string{"--patch=stage", "--", "file.txt"},
+			expected: []string{"--patch=stage", "--", "file.txt"},
+		},
+		{
+			name:        "tui before patch",
+			args:        []string{"--tui", "--patch=reset", "--", "file.txt"},
+			expected:    []string{"--patch=reset", "--", "file.txt"},
+			expectedTUI: true,
+		},
+		{
+			name:     "tui after separator is a path",
+			args:     []string{"--patch", "--", "--tui"},
+			expected: []string{"--patch", "--", "--tui"},
+		},
+		{
+			name:           "script with separate value",
+			args:           []string{"--script", "answers.txt", "--patch", "--"},
+			expected:       []string{"--patch", "--"},
+			expectedScript: "answers.txt",
+		},
+		{
+			name:           "script with equals",
+			args:           []string{"--patch=stash", "--script=answers.txt"},
+			expected:       []string{"--patch=stash"},
+			expectedScript: "answers.txt",
+		},
+		{
+			name:     "serve",
+			args:     []string{"--serve"},
+			expected: []string{},
+		},
+		{
+			name:              "include untracked",
+			args:              []string{"--patch", "--include-untracked", "--", "new.txt"},
+			expected:          []string{"--patch", "--", "new.txt"},
+			expectedUntracked: true,
+		},
+		{
+			name:             "word diff",
+			args:             []string{"--word-diff", "--patch=reset"},
+			expected:         []string{"--patch=reset"},
+			expectedWordDiff: true,
+		},
+		{
+			name:        "script without value",
+			args:        []string{"--script"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, options, err := extractUIOptions(tt.args)
+
+			if (err != nil) != tt.expectError {
+				t.Fatalf("Expected error %v, got %v", tt.expectError, err)
+			}
+			if tt.expectError {
+				return
+			}
+			if options.tui != tt.expectedTUI {
+				t.Errorf("Expected tui %v, got %v", tt.expectedTUI, options.tui)
+			}
+			if options.script != tt.expectedScript {
+				t.Errorf("Expected script %q, got %q", tt.expectedScript, options.script)
+			}
+			if options.includeUntracked != tt.expectedUntracked {
+				t.Errorf("Expected includeUntracked %v, got %v", tt.expectedUntracked, options.includeUntracked)
+			}
+			if options.wordDiff != tt.expectedWordDiff {
+				t.Errorf("Expected wordDiff %v, got %v", tt.expectedWordDiff, options.wordDiff)
+			}
+			if strings.Join(result, " ") != strings.Join(tt.expected, " ") {
+				t.Errorf("Expected args %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}